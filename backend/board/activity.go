@@ -0,0 +1,243 @@
+package kanban
+
+import (
+	"context"
+	"net/http"
+	"reflect"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// Activity actions.
+const (
+	ActionCreated    = "created"
+	ActionUpdated    = "updated"
+	ActionMoved      = "moved"
+	ActionDeleted    = "deleted"
+	ActionChecklist  = "checklist"
+	ActionArchived   = "archived"
+	ActionUnarchived = "unarchived"
+	ActionRestored   = "restored"
+	ActionLinked     = "linked"
+	ActionUnlinked   = "unlinked"
+)
+
+// maxPage bounds ?page= on paged listings so the skip can't overflow.
+const maxPage = 100000
+
+// ActivityChange is one changed field with its values before and after.
+type ActivityChange struct {
+	Field string      `bson:"field" json:"field"`
+	Old   interface{} `bson:"old,omitempty" json:"old,omitempty"`
+	New   interface{} `bson:"new,omitempty" json:"new,omitempty"`
+}
+
+// CardActivity is an entry of a card's history. Entries outlive the card.
+type CardActivity struct {
+	ID        string           `bson:"_id,omitempty" json:"_id"`
+	CardID    string           `bson:"cardId" json:"cardId"`
+	BoardID   string           `bson:"boardId" json:"boardId"`
+	ActorID   string           `bson:"actorId,omitempty" json:"actorId,omitempty"`
+	Action    string           `bson:"action" json:"action"`
+	Changes   []ActivityChange `bson:"changes,omitempty" json:"changes,omitempty"`
+	CreatedAt time.Time        `bson:"createdAt" json:"createdAt"`
+}
+
+// diffCards lists the user-visible fields that differ between two versions.
+func diffCards(before, after *Card) []ActivityChange {
+	var out []ActivityChange
+	add := func(field string, old, new interface{}) {
+		if !reflect.DeepEqual(old, new) {
+			out = append(out, ActivityChange{Field: field, Old: old, New: new})
+		}
+	}
+	add("boardId", before.BoardID, after.BoardID)
+	add("statusId", before.StatusID, after.StatusID)
+	add("laneId", before.LaneID, after.LaneID)
+	add("position", before.Position, after.Position)
+	add("title", before.Title, after.Title)
+	add("description", before.Description, after.Description)
+	add("color", before.Color, after.Color)
+	add("image", before.Image, after.Image)
+	add("priority", before.Priority, after.Priority)
+	add("startDate", before.StartDate, after.StartDate)
+	add("dueDate", before.DueDate, after.DueDate)
+	add("reminders", before.Reminders, after.Reminders)
+	add("assignees", before.Assignees, after.Assignees)
+	add("parentId", before.ParentID, after.ParentID)
+	add("sprintId", before.SprintID, after.SprintID)
+	add("points", before.Points, after.Points)
+	oldTags, newTags := before.Tags, after.Tags
+	if len(oldTags) == 0 {
+		oldTags = nil
+	}
+	if len(newTags) == 0 {
+		newTags = nil
+	}
+	add("tags", oldTags, newTags)
+	var fieldIDs []string
+	for id := range before.Fields {
+		fieldIDs = append(fieldIDs, id)
+	}
+	for id := range after.Fields {
+		if _, ok := before.Fields[id]; !ok {
+			fieldIDs = append(fieldIDs, id)
+		}
+	}
+	sort.Strings(fieldIDs)
+	for _, id := range fieldIDs {
+		add("fields."+id, before.Fields[id], after.Fields[id])
+	}
+	return out
+}
+
+// logCardChange records what happened between before and after. A nil
+// before means the card was created, a nil after that it was deleted.
+// Updates without visible changes are not recorded.
+func logCardChange(ctx context.Context, coll *mongo.Collection, actorID string, before, after *Card) {
+	entry := CardActivity{ActorID: actorID}
+	switch {
+	case before == nil && after != nil:
+		entry.Action = ActionCreated
+		entry.CardID, entry.BoardID = after.ID, after.BoardID
+	case after == nil && before != nil:
+		entry.Action = ActionDeleted
+		entry.CardID, entry.BoardID = before.ID, before.BoardID
+	case before != nil:
+		entry.CardID, entry.BoardID = after.ID, after.BoardID
+		entry.Changes = diffCards(before, after)
+		if len(entry.Changes) == 0 {
+			return
+		}
+		entry.Action = ActionUpdated
+		if before.StatusID != after.StatusID || before.BoardID != after.BoardID {
+			entry.Action = ActionMoved
+		}
+	default:
+		return
+	}
+	recordActivity(ctx, coll, entry)
+}
+
+// recordActivity stores an entry. Failures are swallowed: history is
+// best-effort and must not fail the mutation that produced it.
+func recordActivity(ctx context.Context, coll *mongo.Collection, entry CardActivity) {
+	entry.ID = newID()
+	entry.CreatedAt = time.Now()
+	_, _ = coll.InsertOne(ctx, entry)
+}
+
+func registerActivityRoutes(router *gin.Engine, db *mongo.Database) {
+	activityColl := db.Collection("card_activity")
+	_, _ = activityColl.Indexes().CreateOne(context.TODO(), mongo.IndexModel{
+		Keys: bson.D{{Key: "cardId", Value: 1}, {Key: "createdAt", Value: -1}},
+	})
+
+	// GET /api/cards/:id/activity?page=1&limit=50, newest first
+	router.GET("/api/cards/:id/activity", func(c *gin.Context) {
+		page, _ := strconv.Atoi(c.DefaultQuery("page", "1"))
+		limit, _ := strconv.Atoi(c.DefaultQuery("limit", "50"))
+		if page < 1 {
+			page = 1
+		} else if page > maxPage {
+			page = maxPage
+		}
+		if limit < 1 || limit > 200 {
+			limit = 50
+		}
+		filter := bson.M{"cardId": c.Param("id")}
+		total, err := activityColl.CountDocuments(context.TODO(), filter)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		opts := options.Find().
+			SetSort(bson.D{{Key: "createdAt", Value: -1}, {Key: "_id", Value: -1}}).
+			SetSkip(int64((page - 1) * limit)).
+			SetLimit(int64(limit))
+		cur, err := activityColl.Find(context.TODO(), filter, opts)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		items := []CardActivity{}
+		if err := cur.All(context.TODO(), &items); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{"items": items, "total": total, "page": page, "limit": limit})
+	})
+}
+
+// changeLog is where card mutations are reported: it writes the activity
+// entry, pushes the matching live event and runs the board's rules.
+type changeLog struct {
+	activity    *mongo.Collection
+	transitions *mongo.Collection
+	hub         *eventHub
+	rules       *automation // nil until registerRuleRoutes
+}
+
+func newChangeLog(db *mongo.Database, hub *eventHub) *changeLog {
+	return &changeLog{
+		activity:    db.Collection("card_activity"),
+		transitions: db.Collection("status_transitions"),
+		hub:         hub,
+	}
+}
+
+// card reports a create (before == nil), delete (after == nil) or update.
+func (l *changeLog) card(actorID string, before, after *Card) {
+	l.record(actorID, before, after)
+	l.rules.cardChanged(before, after, nil)
+}
+
+// record is card without running rules; the rules engine reports its own
+// changes through it.
+func (l *changeLog) record(actorID string, before, after *Card) {
+	logCardChange(context.TODO(), l.activity, actorID, before, after)
+	switch {
+	case before == nil && after != nil:
+		l.transition(after.BoardID, after.ID, "", after.StatusID)
+	case after == nil && before != nil:
+		l.transition(before.BoardID, before.ID, before.StatusID, "")
+	case before.BoardID != after.BoardID:
+		l.transition(before.BoardID, before.ID, before.StatusID, "")
+		l.transition(after.BoardID, after.ID, "", after.StatusID)
+	case before.StatusID != after.StatusID:
+		l.transition(after.BoardID, after.ID, before.StatusID, after.StatusID)
+	}
+	l.hub.emitCard(actorID, before, after)
+}
+
+// transition records a card entering or leaving a column ("" is none).
+func (l *changeLog) transition(boardID, cardID, from, to string) {
+	recordTransition(context.TODO(), l.transitions, StatusTransition{
+		BoardID: boardID,
+		CardID:  cardID,
+		From:    from,
+		To:      to,
+	})
+}
+
+// cardAction reports a change made through a sub-resource (checklists, ...)
+// that has its own activity action but shows up live as a card update.
+func (l *changeLog) cardAction(actorID string, card *Card, action string, changes ...ActivityChange) {
+	if len(changes) == 0 {
+		return
+	}
+	recordActivity(context.TODO(), l.activity, CardActivity{
+		CardID:  card.ID,
+		BoardID: card.BoardID,
+		ActorID: actorID,
+		Action:  action,
+		Changes: changes,
+	})
+	l.hub.emit(card.BoardID, EventCardUpdated, actorID, card)
+}
@@ -0,0 +1,612 @@
+package kanban
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// StatusTransition records a card entering a column, leaving one, or both.
+// From is "" when the card was created or restored, To is "" when it was
+// deleted or moved to another board. Transitions are what flow analytics are
+// computed from.
+type StatusTransition struct {
+	ID      string    `bson:"_id,omitempty" json:"_id"`
+	BoardID string    `bson:"boardId" json:"boardId"`
+	CardID  string    `bson:"cardId" json:"cardId"`
+	From    string    `bson:"fromStatusId" json:"fromStatusId"`
+	To      string    `bson:"toStatusId" json:"toStatusId"`
+	At      time.Time `bson:"at" json:"at"`
+}
+
+// recordTransition stores a transition; like activity it is best-effort.
+func recordTransition(ctx context.Context, coll *mongo.Collection, t StatusTransition) {
+	if t.From == t.To {
+		return
+	}
+	t.ID = newID()
+	if t.At.IsZero() {
+		t.At = time.Now()
+	}
+	_, _ = coll.InsertOne(ctx, t)
+}
+
+// cardTransitions rebuilds the transitions of card from its activity,
+// oldest first. Only entries about deletes, restores and column changes
+// matter.
+func cardTransitions(card Card, entries []CardActivity) []StatusTransition {
+	// the column the card started in is the "old" side of its first move
+	status := card.StatusID
+first:
+	for _, e := range entries {
+		for _, ch := range e.Changes {
+			if ch.Field == "statusId" {
+				status, _ = ch.Old.(string)
+				break first
+			}
+		}
+	}
+	var out []StatusTransition
+	add := func(from, to string, at time.Time) {
+		if from != to {
+			out = append(out, StatusTransition{ID: newID(), BoardID: card.BoardID, CardID: card.ID, From: from, To: to, At: at})
+		}
+	}
+	add("", status, card.CreatedAt)
+	for _, e := range entries {
+		switch e.Action {
+		case ActionDeleted:
+			add(status, "", e.CreatedAt)
+		case ActionRestored:
+			add("", status, e.CreatedAt)
+		}
+		for _, ch := range e.Changes {
+			if ch.Field == "statusId" {
+				to, _ := ch.New.(string)
+				add(status, to, e.CreatedAt)
+				status = to
+			}
+		}
+	}
+	return out
+}
+
+// backfillBatch is how many cards backfillTransitions handles per query.
+const backfillBatch = 500
+
+// backfillTransitions rebuilds transitions from card activity the first time
+// it runs, so boards older than transition tracking have a history. It runs
+// in the background: cards are read in batches, each with one aggregation
+// over their activity. What happens after it starts is recorded live.
+func backfillTransitions(db *mongo.Database) {
+	ctx := context.TODO()
+	transColl := db.Collection("status_transitions")
+	if n, err := transColl.EstimatedDocumentCount(ctx); err != nil || n > 0 {
+		return
+	}
+	cutoff := time.Now()
+	cur, err := db.Collection("cards").Find(ctx, bson.M{"createdAt": bson.M{"$lt": cutoff}},
+		options.Find().SetProjection(bson.M{"boardId": 1, "statusId": 1, "createdAt": 1}).SetBatchSize(backfillBatch))
+	if err != nil {
+		log.Println("kanban: transition backfill:", err)
+		return
+	}
+	defer cur.Close(ctx)
+	activityColl := db.Collection("card_activity")
+	total := 0
+	batch := make([]Card, 0, backfillBatch)
+	flush := func() error {
+		if len(batch) == 0 {
+			return nil
+		}
+		ids := make([]string, len(batch))
+		for i, card := range batch {
+			ids[i] = card.ID
+		}
+		acur, err := activityColl.Aggregate(ctx, mongo.Pipeline{
+			{{Key: "$match", Value: bson.M{
+				"cardId":    bson.M{"$in": ids},
+				"createdAt": bson.M{"$lt": cutoff},
+				"$or": bson.A{
+					bson.M{"action": bson.M{"$in": bson.A{ActionDeleted, ActionRestored}}},
+					bson.M{"changes.field": "statusId"},
+				},
+			}}},
+			{{Key: "$sort", Value: bson.D{{Key: "cardId", Value: 1}, {Key: "createdAt", Value: 1}, {Key: "_id", Value: 1}}}},
+			{{Key: "$group", Value: bson.M{
+				"_id": "$cardId",
+				"entries": bson.M{"$push": bson.M{
+					"action":    "$action",
+					"createdAt": "$createdAt",
+					"changes":   bson.M{"$filter": bson.M{"input": "$changes", "cond": bson.M{"$eq": bson.A{"$$this.field", "statusId"}}}},
+				}},
+			}}},
+		})
+		if err != nil {
+			return err
+		}
+		var rows []struct {
+			CardID  string         `bson:"_id"`
+			Entries []CardActivity `bson:"entries"`
+		}
+		if err := acur.All(ctx, &rows); err != nil {
+			return err
+		}
+		entries := make(map[string][]CardActivity, len(rows))
+		for _, r := range rows {
+			entries[r.CardID] = r.Entries
+		}
+		var docs []interface{}
+		for _, card := range batch {
+			for _, t := range cardTransitions(card, entries[card.ID]) {
+				docs = append(docs, t)
+			}
+		}
+		batch = batch[:0]
+		if len(docs) == 0 {
+			return nil
+		}
+		if _, err := transColl.InsertMany(ctx, docs); err != nil {
+			return err
+		}
+		total += len(docs)
+		return nil
+	}
+	for cur.Next(ctx) {
+		var card Card
+		if err := cur.Decode(&card); err != nil {
+			continue
+		}
+		if batch = append(batch, card); len(batch) == backfillBatch {
+			if err := flush(); err != nil {
+				log.Println("kanban: transition backfill:", err)
+				return
+			}
+		}
+	}
+	if err := flush(); err != nil {
+		log.Println("kanban: transition backfill:", err)
+		return
+	}
+	if total > 0 {
+		log.Println("kanban: backfilled status transitions:", total)
+	}
+}
+
+// flowCard is a card's history summed up by flowPipeline.
+type flowCard struct {
+	ID      string     `bson:"_id"`
+	Created time.Time  `bson:"created"`
+	Started *time.Time `bson:"started"` // first entry into an in_progress column
+	Last    struct {
+		At       time.Time `bson:"at"`
+		Status   string    `bson:"status"`
+		Category string    `bson:"category"`
+	} `bson:"last"`
+	Title string `bson:"title"`
+}
+
+// flowPipeline sums up the transitions of each live card on a board: when it
+// appeared, when work started and the column it is in now. tag narrows the
+// cards (optional).
+func flowPipeline(boardID, tag string) mongo.Pipeline {
+	cardMatch := bson.M{"card.deletedAt": nil}
+	if tag != "" {
+		cardMatch["card.tags"] = tag
+	}
+	return mongo.Pipeline{
+		{{Key: "$match", Value: bson.M{"boardId": boardID}}},
+		{{Key: "$lookup", Value: bson.M{"from": "statuses", "localField": "toStatusId", "foreignField": "_id", "as": "status"}}},
+		{{Key: "$set", Value: bson.M{"category": bson.M{"$ifNull": bson.A{bson.M{"$arrayElemAt": bson.A{"$status.category", 0}}, ""}}}}},
+		{{Key: "$sort", Value: bson.D{{Key: "cardId", Value: 1}, {Key: "at", Value: 1}, {Key: "_id", Value: 1}}}},
+		{{Key: "$group", Value: bson.M{
+			"_id":     "$cardId",
+			"created": bson.M{"$min": "$at"},
+			"started": bson.M{"$min": bson.M{"$cond": bson.A{bson.M{"$eq": bson.A{"$category", CategoryInProgress}}, "$at", nil}}},
+			"last":    bson.M{"$last": bson.M{"at": "$at", "status": "$toStatusId", "category": "$category"}},
+		}}},
+		{{Key: "$lookup", Value: bson.M{"from": "cards", "localField": "_id", "foreignField": "_id", "as": "card"}}},
+		{{Key: "$unwind", Value: "$card"}},
+		{{Key: "$match", Value: cardMatch}},
+		{{Key: "$set", Value: bson.M{"title": "$card.title"}}},
+		{{Key: "$project", Value: bson.M{"card": 0}}},
+	}
+}
+
+// DurationStats summarises durations in hours.
+type DurationStats struct {
+	Count int     `json:"count"`
+	Mean  float64 `json:"mean"`
+	P50   float64 `json:"p50"`
+	P85   float64 `json:"p85"`
+	P95   float64 `json:"p95"`
+}
+
+// percentile is the nearest-rank percentile of sorted values.
+func percentile(sorted []float64, p float64) float64 {
+	if len(sorted) == 0 {
+		return 0
+	}
+	rank := int(math.Ceil(p / 100 * float64(len(sorted))))
+	if rank < 1 {
+		rank = 1
+	}
+	return sorted[rank-1]
+}
+
+func durationStats(hours []float64) DurationStats {
+	sort.Float64s(hours)
+	s := DurationStats{Count: len(hours)}
+	if len(hours) == 0 {
+		return s
+	}
+	var sum float64
+	for _, h := range hours {
+		sum += h
+	}
+	round := func(v float64) float64 { return math.Round(v*100) / 100 }
+	s.Mean = round(sum / float64(len(hours)))
+	s.P50 = round(percentile(hours, 50))
+	s.P85 = round(percentile(hours, 85))
+	s.P95 = round(percentile(hours, 95))
+	return s
+}
+
+// flowRange reads ?from= and ?to= (dates), defaulting to the last 90 days.
+func flowRange(c *gin.Context) (time.Time, time.Time, error) {
+	to := time.Now()
+	from := to.AddDate(0, 0, -90)
+	var err error
+	if v := c.Query("from"); v != "" {
+		if from, err = parseDate(v); err != nil {
+			return from, to, err
+		}
+	}
+	if v := c.Query("to"); v != "" {
+		if to, err = parseDate(v); err != nil {
+			return from, to, err
+		}
+	}
+	return from, to, nil
+}
+
+// cycleTimes computes lead time (created to done) and cycle time (first
+// in-progress to done) in hours for cards that are done, finished inside
+// [from, to).
+func cycleTimes(ctx context.Context, coll *mongo.Collection, boardID, tag string, from, to time.Time) (lead, cycle DurationStats, err error) {
+	hour := float64(time.Hour / time.Millisecond)
+	pipeline := append(flowPipeline(boardID, tag),
+		bson.D{{Key: "$match", Value: bson.M{"last.category": CategoryDone, "last.at": bson.M{"$gte": from, "$lt": to}}}},
+		bson.D{{Key: "$project", Value: bson.M{
+			"lead":  bson.M{"$divide": bson.A{bson.M{"$subtract": bson.A{"$last.at", "$created"}}, hour}},
+			"cycle": bson.M{"$divide": bson.A{bson.M{"$subtract": bson.A{"$last.at", "$started"}}, hour}},
+		}}},
+	)
+	cur, err := coll.Aggregate(ctx, pipeline)
+	if err != nil {
+		return lead, cycle, err
+	}
+	var rows []struct {
+		Lead  float64  `bson:"lead"`
+		Cycle *float64 `bson:"cycle"`
+	}
+	if err := cur.All(ctx, &rows); err != nil {
+		return lead, cycle, err
+	}
+	var leads, cycles []float64
+	for _, r := range rows {
+		leads = append(leads, r.Lead)
+		if r.Cycle != nil {
+			cycles = append(cycles, *r.Cycle)
+		}
+	}
+	return durationStats(leads), durationStats(cycles), nil
+}
+
+// cardStep is one entry of a card's column history.
+type cardStep struct {
+	To string    `bson:"to"`
+	At time.Time `bson:"at"`
+}
+
+// visitsPipeline turns the transitions matching match into visits: each
+// keeps its column and gets "until", the time of the card's next
+// transition (missing for the visit still going on).
+func visitsPipeline(match bson.M) mongo.Pipeline {
+	return mongo.Pipeline{
+		{{Key: "$match", Value: match}},
+		{{Key: "$setWindowFields", Value: bson.M{
+			"partitionBy": "$cardId",
+			"sortBy":      bson.D{{Key: "at", Value: 1}, {Key: "_id", Value: 1}},
+			"output":      bson.M{"until": bson.M{"$shift": bson.M{"output": "$at", "by": 1}}},
+		}}},
+		{{Key: "$match", Value: bson.M{"toStatusId": bson.M{"$ne": ""}}}},
+	}
+}
+
+// timeInStatus sums, per column, how long cards stayed in it on visits that
+// ended inside [from, to).
+func timeInStatus(ctx context.Context, coll *mongo.Collection, boardID string, from, to time.Time) (map[string]DurationStats, error) {
+	hour := float64(time.Hour / time.Millisecond)
+	pipeline := append(visitsPipeline(bson.M{"boardId": boardID, "at": bson.M{"$lt": to}}),
+		bson.D{{Key: "$match", Value: bson.M{"until": bson.M{"$gte": from, "$lt": to}}}},
+		bson.D{{Key: "$group", Value: bson.M{
+			"_id":   "$toStatusId",
+			"hours": bson.M{"$push": bson.M{"$divide": bson.A{bson.M{"$subtract": bson.A{"$until", "$at"}}, hour}}},
+		}}},
+	)
+	cur, err := coll.Aggregate(ctx, pipeline)
+	if err != nil {
+		return nil, err
+	}
+	var rows []struct {
+		StatusID string    `bson:"_id"`
+		Hours    []float64 `bson:"hours"`
+	}
+	if err := cur.All(ctx, &rows); err != nil {
+		return nil, err
+	}
+	out := map[string]DurationStats{}
+	for _, r := range rows {
+		out[r.StatusID] = durationStats(r.Hours)
+	}
+	return out, nil
+}
+
+// CFDDay is a day of the cumulative flow diagram.
+type CFDDay struct {
+	Date   string         `json:"date"`
+	Counts map[string]int `json:"counts"` // statusId -> cards
+}
+
+// cumulativeFlow counts, for each of days days from firstDay (UTC
+// midnight), the cards in each column at the end of the day. A visit is
+// counted on the days whose end falls after it began and no later than it
+// ended.
+func cumulativeFlow(ctx context.Context, coll *mongo.Collection, boardID string, firstDay time.Time, days int) ([]CFDDay, error) {
+	dayMs := float64(24 * time.Hour / time.Millisecond)
+	end := firstDay.AddDate(0, 0, days)
+	dayOf := func(t interface{}) bson.M {
+		return bson.M{"$toInt": bson.M{"$floor": bson.M{"$divide": bson.A{bson.M{"$subtract": bson.A{t, firstDay}}, dayMs}}}}
+	}
+	pipeline := append(visitsPipeline(bson.M{"boardId": boardID, "at": bson.M{"$lt": end}}),
+		bson.D{{Key: "$set", Value: bson.M{"until": bson.M{"$ifNull": bson.A{"$until", end}}}}},
+		bson.D{{Key: "$match", Value: bson.M{"until": bson.M{"$gte": firstDay.AddDate(0, 0, 1)}}}},
+		bson.D{{Key: "$project", Value: bson.M{
+			"status": "$toStatusId",
+			"day": bson.M{"$range": bson.A{
+				bson.M{"$max": bson.A{0, dayOf("$at")}},
+				bson.M{"$min": bson.A{days, dayOf("$until")}},
+			}},
+		}}},
+		bson.D{{Key: "$unwind", Value: "$day"}},
+		bson.D{{Key: "$group", Value: bson.M{
+			"_id":   bson.M{"day": "$day", "status": "$status"},
+			"count": bson.M{"$sum": 1},
+		}}},
+	)
+	cur, err := coll.Aggregate(ctx, pipeline)
+	if err != nil {
+		return nil, err
+	}
+	var rows []struct {
+		ID struct {
+			Day    int    `bson:"day"`
+			Status string `bson:"status"`
+		} `bson:"_id"`
+		Count int `bson:"count"`
+	}
+	if err := cur.All(ctx, &rows); err != nil {
+		return nil, err
+	}
+	series := make([]CFDDay, days)
+	for i := range series {
+		series[i] = CFDDay{Date: firstDay.AddDate(0, 0, i).Format("2006-01-02"), Counts: map[string]int{}}
+	}
+	for _, r := range rows {
+		if r.ID.Day >= 0 && r.ID.Day < days {
+			series[r.ID.Day].Counts[r.ID.Status] = r.Count
+		}
+	}
+	return series, nil
+}
+
+// weekKey names the ISO week of t, as in "2024-W07".
+func weekKey(t time.Time) string {
+	y, w := t.ISOWeek()
+	return fmt.Sprintf("%d-W%02d", y, w)
+}
+
+// throughputWeeks lists the ISO weeks from the one holding start to the one
+// holding now, with the counts found for them.
+func throughputWeeks(start, now time.Time, counts map[string]int) []ThroughputWeek {
+	out := []ThroughputWeek{}
+	for d := start; ; d = d.AddDate(0, 0, 7) {
+		if d.After(now) {
+			d = now
+		}
+		if key := weekKey(d); len(out) == 0 || out[len(out)-1].Week != key {
+			out = append(out, ThroughputWeek{key, counts[key]})
+		}
+		if d.Equal(now) {
+			return out
+		}
+	}
+}
+
+// ThroughputWeek is the number of cards finished in an ISO week.
+type ThroughputWeek struct {
+	Week  string `json:"week"`
+	Count int    `json:"count"`
+}
+
+func registerAnalyticsRoutes(router *gin.Engine, db *mongo.Database) {
+	transColl := db.Collection("status_transitions")
+	statusColl := db.Collection("statuses")
+	_, _ = transColl.Indexes().CreateMany(context.TODO(), []mongo.IndexModel{
+		{Keys: bson.D{{Key: "boardId", Value: 1}, {Key: "at", Value: 1}}},
+		{Keys: bson.D{{Key: "cardId", Value: 1}, {Key: "at", Value: 1}}},
+	})
+	go backfillTransitions(db)
+
+	statusNames := func(boardID string) map[string]string {
+		names := map[string]string{}
+		var statuses []Status
+		if cur, err := statusColl.Find(context.TODO(), bson.M{"boardId": boardID}); err == nil {
+			_ = cur.All(context.TODO(), &statuses)
+		}
+		for _, st := range statuses {
+			names[st.ID] = st.Name
+		}
+		return names
+	}
+
+	// GET /api/boards/:id/analytics/cycle-time?from=&to=&tag= — lead and
+	// cycle time percentiles in hours, and time spent per column.
+	router.GET("/api/boards/:id/analytics/cycle-time", func(c *gin.Context) {
+		from, to, err := flowRange(c)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		boardID := c.Param("id")
+		lead, cycle, err := cycleTimes(context.TODO(), transColl, boardID, c.Query("tag"), from, to)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		perStatus, err := timeInStatus(context.TODO(), transColl, boardID, from, to)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		names := statusNames(boardID)
+		type statusTime struct {
+			StatusID string `json:"statusId"`
+			Name     string `json:"name"`
+			DurationStats
+		}
+		inStatus := []statusTime{}
+		for id, s := range perStatus {
+			inStatus = append(inStatus, statusTime{id, names[id], s})
+		}
+		sort.Slice(inStatus, func(i, j int) bool { return inStatus[i].StatusID < inStatus[j].StatusID })
+		c.JSON(http.StatusOK, gin.H{"from": from, "to": to, "lead": lead, "cycle": cycle, "timeInStatus": inStatus})
+	})
+
+	// GET /api/boards/:id/analytics/throughput?weeks=12 — cards reaching a
+	// done column per ISO week, oldest week first.
+	router.GET("/api/boards/:id/analytics/throughput", func(c *gin.Context) {
+		weeks, _ := strconv.Atoi(c.DefaultQuery("weeks", "12"))
+		if weeks < 1 || weeks > 104 {
+			weeks = 12
+		}
+		now := time.Now().UTC()
+		start := now.AddDate(0, 0, -7*weeks)
+		cur, err := transColl.Aggregate(context.TODO(), mongo.Pipeline{
+			{{Key: "$match", Value: bson.M{"boardId": c.Param("id"), "at": bson.M{"$gte": start}}}},
+			{{Key: "$lookup", Value: bson.M{"from": "statuses", "localField": "toStatusId", "foreignField": "_id", "as": "status"}}},
+			{{Key: "$match", Value: bson.M{"status.category": CategoryDone}}},
+			{{Key: "$group", Value: bson.M{
+				"_id":   bson.M{"year": bson.M{"$isoWeekYear": "$at"}, "week": bson.M{"$isoWeek": "$at"}},
+				"cards": bson.M{"$addToSet": "$cardId"},
+			}}},
+			{{Key: "$project", Value: bson.M{"count": bson.M{"$size": "$cards"}}}},
+		})
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		var rows []struct {
+			ID struct {
+				Year int `bson:"year"`
+				Week int `bson:"week"`
+			} `bson:"_id"`
+			Count int `bson:"count"`
+		}
+		if err := cur.All(context.TODO(), &rows); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		counts := map[string]int{}
+		for _, r := range rows {
+			counts[fmt.Sprintf("%d-W%02d", r.ID.Year, r.ID.Week)] = r.Count
+		}
+		c.JSON(http.StatusOK, throughputWeeks(start, now, counts))
+	})
+
+	// GET /api/boards/:id/analytics/cfd?days=30 — cumulative flow: for each
+	// day, how many cards sat in each column at the end of it.
+	router.GET("/api/boards/:id/analytics/cfd", func(c *gin.Context) {
+		days, _ := strconv.Atoi(c.DefaultQuery("days", "30"))
+		if days < 1 || days > 365 {
+			days = 30
+		}
+		boardID := c.Param("id")
+		y, m, d := time.Now().UTC().Date()
+		firstDay := time.Date(y, m, d, 0, 0, 0, 0, time.UTC).AddDate(0, 0, -(days - 1))
+		series, err := cumulativeFlow(context.TODO(), transColl, boardID, firstDay, days)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{"statuses": statusNames(boardID), "days": series})
+	})
+
+	// GET /api/boards/:id/analytics/aging — cards in progress now, oldest
+	// first, with hours since work started and since the last move.
+	router.GET("/api/boards/:id/analytics/aging", func(c *gin.Context) {
+		pipeline := append(flowPipeline(c.Param("id"), c.Query("tag")),
+			bson.D{{Key: "$match", Value: bson.M{"last.category": CategoryInProgress}}},
+			bson.D{{Key: "$sort", Value: bson.M{"started": 1}}},
+		)
+		cur, err := transColl.Aggregate(context.TODO(), pipeline)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		var cards []flowCard
+		if err := cur.All(context.TODO(), &cards); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		names := statusNames(c.Param("id"))
+		type aging struct {
+			CardID        string    `json:"cardId"`
+			Title         string    `json:"title"`
+			StatusID      string    `json:"statusId"`
+			Status        string    `json:"status"`
+			StartedAt     time.Time `json:"startedAt"`
+			AgeHours      float64   `json:"ageHours"`
+			InStatusHours float64   `json:"inStatusHours"`
+		}
+		now := time.Now()
+		out := []aging{}
+		for _, fc := range cards {
+			started := fc.Last.At
+			if fc.Started != nil {
+				started = *fc.Started
+			}
+			out = append(out, aging{
+				CardID:        fc.ID,
+				Title:         fc.Title,
+				StatusID:      fc.Last.Status,
+				Status:        names[fc.Last.Status],
+				StartedAt:     started,
+				AgeHours:      math.Round(now.Sub(started).Hours()*100) / 100,
+				InStatusHours: math.Round(now.Sub(fc.Last.At).Hours()*100) / 100,
+			})
+		}
+		c.JSON(http.StatusOK, out)
+	})
+}
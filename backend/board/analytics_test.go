@@ -0,0 +1,197 @@
+package kanban
+
+import (
+	"context"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestPercentile(t *testing.T) {
+	for _, tc := range []struct {
+		values []float64
+		p      float64
+		want   float64
+	}{
+		{nil, 50, 0},
+		{[]float64{7}, 95, 7},
+		{[]float64{1, 2, 3, 4}, 50, 2},
+		{[]float64{1, 2, 3, 4}, 51, 3},
+		{[]float64{1, 2, 3, 4, 5, 6, 7, 8, 9, 10}, 85, 9},
+		{[]float64{1, 2, 3, 4, 5, 6, 7, 8, 9, 10}, 95, 10},
+		{[]float64{1, 2, 3}, 0, 1},
+	} {
+		if got := percentile(tc.values, tc.p); got != tc.want {
+			t.Errorf("percentile(%v, %v) = %v, want %v", tc.values, tc.p, got, tc.want)
+		}
+	}
+}
+
+func TestDurationStats(t *testing.T) {
+	for _, tc := range []struct {
+		hours []float64
+		want  DurationStats
+	}{
+		{nil, DurationStats{}},
+		{[]float64{5}, DurationStats{Count: 1, Mean: 5, P50: 5, P85: 5, P95: 5}},
+		// unsorted in, nearest rank out
+		{[]float64{10, 1, 4, 2, 3}, DurationStats{Count: 5, Mean: 4, P50: 3, P85: 10, P95: 10}},
+		{[]float64{1.0 / 3, 2.0 / 3}, DurationStats{Count: 2, Mean: 0.5, P50: 0.33, P85: 0.67, P95: 0.67}},
+	} {
+		if got := durationStats(tc.hours); got != tc.want {
+			t.Errorf("durationStats(%v) = %+v, want %+v", tc.hours, got, tc.want)
+		}
+	}
+}
+
+func TestCardTransitions(t *testing.T) {
+	t0 := time.Date(2024, 3, 1, 9, 0, 0, 0, time.UTC)
+	at := func(h int) time.Time { return t0.Add(time.Duration(h) * time.Hour) }
+	move := func(h int, from, to string) CardActivity {
+		return CardActivity{Action: ActionMoved, CreatedAt: at(h), Changes: []ActivityChange{{Field: "statusId", Old: from, New: to}}}
+	}
+	type step struct{ From, To string }
+	for _, tc := range []struct {
+		name    string
+		status  string // where the card is now
+		entries []CardActivity
+		want    []step
+	}{
+		{"never moved", "todo", nil, []step{{"", "todo"}}},
+		{"moved twice", "done", []CardActivity{move(1, "todo", "doing"), move(5, "doing", "done")},
+			[]step{{"", "todo"}, {"todo", "doing"}, {"doing", "done"}}},
+		{"deleted and restored", "doing", []CardActivity{
+			move(1, "todo", "doing"),
+			{Action: ActionDeleted, CreatedAt: at(2)},
+			{Action: ActionRestored, CreatedAt: at(3)},
+		}, []step{{"", "todo"}, {"todo", "doing"}, {"doing", ""}, {"", "doing"}}},
+		{"edits without a move", "todo", []CardActivity{
+			{Action: ActionUpdated, CreatedAt: at(1), Changes: []ActivityChange{{Field: "title", Old: "a", New: "b"}}},
+		}, []step{{"", "todo"}}},
+	} {
+		card := Card{ID: "c1", BoardID: "b1", StatusID: tc.status, CreatedAt: t0}
+		var got []step
+		for i, tr := range cardTransitions(card, tc.entries) {
+			if tr.CardID != "c1" || tr.BoardID != "b1" || tr.ID == "" {
+				t.Errorf("%s: transition %d = %+v", tc.name, i, tr)
+			}
+			if i == 0 && !tr.At.Equal(t0) {
+				t.Errorf("%s: created at %v, want %v", tc.name, tr.At, t0)
+			}
+			got = append(got, step{tr.From, tr.To})
+		}
+		if !reflect.DeepEqual(got, tc.want) {
+			t.Errorf("%s: transitions = %v, want %v", tc.name, got, tc.want)
+		}
+	}
+}
+
+func TestThroughputWeeks(t *testing.T) {
+	day := func(s string) time.Time {
+		d, err := time.Parse("2006-01-02", s)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return d
+	}
+	weeks := func(ws []ThroughputWeek) []string {
+		var out []string
+		for _, w := range ws {
+			out = append(out, w.Week)
+		}
+		return out
+	}
+	for _, tc := range []struct {
+		start, now string
+		want       []string
+	}{
+		{"2024-02-12", "2024-02-12", []string{"2024-W07"}},
+		{"2024-02-11", "2024-02-25", []string{"2024-W06", "2024-W07", "2024-W08"}},
+		// ISO years: 2020 has a week 53, and 2024-12-30 is in 2025-W01
+		{"2020-12-21", "2021-01-04", []string{"2020-W52", "2020-W53", "2021-W01"}},
+		{"2024-12-23", "2025-01-06", []string{"2024-W52", "2025-W01", "2025-W02"}},
+	} {
+		got := weeks(throughputWeeks(day(tc.start), day(tc.now), nil))
+		if !reflect.DeepEqual(got, tc.want) {
+			t.Errorf("throughputWeeks(%s, %s) = %v, want %v", tc.start, tc.now, got, tc.want)
+		}
+	}
+	got := throughputWeeks(day("2024-02-12"), day("2024-02-19"), map[string]int{"2024-W08": 3, "2023-W08": 9})
+	if want := []ThroughputWeek{{"2024-W07", 0}, {"2024-W08", 3}}; !reflect.DeepEqual(got, want) {
+		t.Errorf("throughputWeeks counts = %+v, want %+v", got, want)
+	}
+}
+
+func TestFlowPipelines(t *testing.T) {
+	db := testDB(t)
+	ctx := context.Background()
+	coll := db.Collection("status_transitions")
+	day0 := time.Date(2024, 3, 1, 0, 0, 0, 0, time.UTC)
+	at := func(day int, hour int) time.Time { return day0.AddDate(0, 0, day).Add(time.Duration(hour) * time.Hour) }
+
+	// a: todo on day 0, doing day 1 12:00, done day 3 00:00 exactly
+	// b: todo day 1, deleted day 2
+	// c: doing day 2, still there; other board's card must not count
+	var docs []interface{}
+	add := func(board, card, from, to string, when time.Time) {
+		docs = append(docs, StatusTransition{ID: newID(), BoardID: board, CardID: card, From: from, To: to, At: when})
+	}
+	add("b1", "a", "", "todo", at(0, 6))
+	add("b1", "a", "todo", "doing", at(1, 12))
+	add("b1", "a", "doing", "done", at(3, 0))
+	add("b1", "b", "", "todo", at(1, 3))
+	add("b1", "b", "todo", "", at(2, 3))
+	add("b1", "c", "", "doing", at(2, 1))
+	add("b2", "x", "", "todo", at(0, 1))
+	if _, err := coll.InsertMany(ctx, docs); err != nil {
+		t.Fatal(err)
+	}
+
+	t.Run("timeInStatus", func(t *testing.T) {
+		got, err := timeInStatus(ctx, coll, "b1", day0, at(10, 0))
+		if err != nil {
+			t.Fatal(err)
+		}
+		want := map[string]DurationStats{
+			"todo":  {Count: 2, Mean: 27, P50: 24, P85: 30, P95: 30},
+			"doing": {Count: 1, Mean: 36, P50: 36, P85: 36, P95: 36},
+		}
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("timeInStatus = %+v, want %+v", got, want)
+		}
+		// only visits that ended inside the range count
+		got, err = timeInStatus(ctx, coll, "b1", at(2, 0), at(2, 12))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if want := map[string]DurationStats{"todo": {Count: 1, Mean: 24, P50: 24, P85: 24, P95: 24}}; !reflect.DeepEqual(got, want) {
+			t.Errorf("timeInStatus in [day 2, day 2 12:00) = %+v, want %+v", got, want)
+		}
+	})
+
+	t.Run("cumulativeFlow", func(t *testing.T) {
+		got, err := cumulativeFlow(ctx, coll, "b1", day0, 5)
+		if err != nil {
+			t.Fatal(err)
+		}
+		want := []CFDDay{
+			{"2024-03-01", map[string]int{"todo": 1}},
+			{"2024-03-02", map[string]int{"todo": 1, "doing": 1}},
+			{"2024-03-03", map[string]int{"doing": 2}},
+			// a reaches done at the very end of day 2, so counts there from day 3
+			{"2024-03-04", map[string]int{"doing": 1, "done": 1}},
+			{"2024-03-05", map[string]int{"doing": 1, "done": 1}},
+		}
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("cumulativeFlow =\n%+v\nwant\n%+v", got, want)
+		}
+		// a window starting later still sees cards that arrived before it
+		got, err = cumulativeFlow(ctx, coll, "b1", at(3, 0), 1)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if want := []CFDDay{{"2024-03-04", map[string]int{"doing": 1, "done": 1}}}; !reflect.DeepEqual(got, want) {
+			t.Errorf("cumulativeFlow from day 3 = %+v, want %+v", got, want)
+		}
+	})
+}
@@ -0,0 +1,279 @@
+package kanban
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"mime"
+	"mime/multipart"
+	"net/http"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// uploadDir is where uploaded files live; they are served under /uploads.
+var uploadDir = "./uploads"
+
+// Attachment is the record of an uploaded file. Files sent to /api/upload
+// get one without a CardID; files added to a card carry its id.
+type Attachment struct {
+	ID         string    `bson:"_id,omitempty" json:"_id"`
+	CardID     string    `bson:"cardId,omitempty" json:"cardId,omitempty"`
+	BoardID    string    `bson:"boardId,omitempty" json:"boardId,omitempty"`
+	URL        string    `bson:"url" json:"url"` // "/uploads/..."
+	Name       string    `bson:"name" json:"name"`
+	Size       int64     `bson:"size" json:"size"`
+	MimeType   string    `bson:"mimeType" json:"mimeType"`
+	UploaderID string    `bson:"uploaderId,omitempty" json:"uploaderId,omitempty"`
+	CreatedAt  time.Time `bson:"createdAt" json:"createdAt"`
+}
+
+// sniffMime detects the type from the file content, falling back to the
+// extension when the content says nothing more specific.
+func sniffMime(file *multipart.FileHeader) string {
+	detected := "application/octet-stream"
+	if f, err := file.Open(); err == nil {
+		buf := make([]byte, 512)
+		n, _ := io.ReadFull(f, buf)
+		f.Close()
+		detected = http.DetectContentType(buf[:n])
+	}
+	if detected == "application/octet-stream" || detected == "text/plain; charset=utf-8" {
+		if byExt := mime.TypeByExtension(filepath.Ext(file.Filename)); byExt != "" {
+			return byExt
+		}
+	}
+	return detected
+}
+
+// saveUpload writes a multipart file into uploadDir and returns its record
+// (not yet stored).
+func saveUpload(c *gin.Context, file *multipart.FileHeader) (Attachment, error) {
+	// sanitize filename (use only base)
+	name := filepath.Base(file.Filename)
+	filename := fmt.Sprintf("%d_%s", time.Now().UnixNano(), name)
+	if err := c.SaveUploadedFile(file, filepath.Join(uploadDir, filename)); err != nil {
+		return Attachment{}, err
+	}
+	return Attachment{
+		ID:         newID(),
+		URL:        "/uploads/" + filename,
+		Name:       name,
+		Size:       file.Size,
+		MimeType:   sniffMime(file),
+		UploaderID: currentUserID(c),
+		CreatedAt:  time.Now(),
+	}, nil
+}
+
+// saveFile writes data that didn't come from a form (mail attachments,
+// ...) into uploadDir and returns its record (not yet stored). mimeType is
+// what the sender declared; the content decides when it says more.
+func saveFile(name, mimeType string, data []byte, uploaderID string) (Attachment, error) {
+	name = filepath.Base(name)
+	filename := fmt.Sprintf("%d_%s", time.Now().UnixNano(), name)
+	if err := os.WriteFile(filepath.Join(uploadDir, filename), data, 0o644); err != nil {
+		return Attachment{}, err
+	}
+	if detected := http.DetectContentType(data); mimeType == "" || (detected != "application/octet-stream" && detected != "text/plain; charset=utf-8") {
+		mimeType = detected
+	}
+	return Attachment{
+		ID:         newID(),
+		URL:        "/uploads/" + filename,
+		Name:       name,
+		Size:       int64(len(data)),
+		MimeType:   mimeType,
+		UploaderID: uploaderID,
+		CreatedAt:  time.Now(),
+	}, nil
+}
+
+func registerAttachmentRoutes(router *gin.Engine, db *mongo.Database, changes *changeLog) {
+	cardColl := db.Collection("cards")
+	attachColl := db.Collection("attachments")
+	_, _ = attachColl.Indexes().CreateMany(context.TODO(), []mongo.IndexModel{
+		{Keys: bson.D{{Key: "cardId", Value: 1}, {Key: "createdAt", Value: 1}}},
+		{Keys: bson.D{{Key: "url", Value: 1}}},
+	})
+
+	loadCard := func(c *gin.Context) (*Card, bool) {
+		var card Card
+		if err := cardColl.FindOne(context.TODO(), bson.M{"_id": c.Param("id"), "deletedAt": nil}).Decode(&card); err != nil {
+			c.JSON(http.StatusNotFound, gin.H{"error": "card not found"})
+			return nil, false
+		}
+		return &card, true
+	}
+	// setCover points card.Image at url ("" clears it) and reports the change.
+	setCover := func(c *gin.Context, card *Card, url string) error {
+		update := bson.M{"$set": bson.M{"image": url, "updatedAt": time.Now()}}
+		if url == "" {
+			update = bson.M{"$unset": bson.M{"image": ""}, "$set": bson.M{"updatedAt": time.Now()}}
+		}
+		if _, err := cardColl.UpdateOne(context.TODO(), bson.M{"_id": card.ID}, withVersion(update)); err != nil {
+			return err
+		}
+		before := *card
+		card.Image = url
+		card.Version++
+		changes.card(currentUserID(c), &before, card)
+		return nil
+	}
+
+	// --- UPLOAD ---
+	router.POST("/api/upload", func(c *gin.Context) {
+		file, err := c.FormFile("file")
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		att, err := saveUpload(c, file)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		if _, err := attachColl.InsertOne(context.TODO(), att); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		// return relative path that frontend can prefix with server origin
+		c.JSON(http.StatusOK, gin.H{"url": att.URL, "attachment": att})
+	})
+
+	// --- ATTACHMENTS ---
+	router.GET("/api/cards/:id/attachments", func(c *gin.Context) {
+		opts := options.Find().SetSort(bson.D{{Key: "createdAt", Value: 1}})
+		cur, err := attachColl.Find(context.TODO(), bson.M{"cardId": c.Param("id")}, opts)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		out := []Attachment{}
+		if err := cur.All(context.TODO(), &out); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(http.StatusOK, out)
+	})
+
+	// POST /api/cards/:id/attachments — multipart "file" (repeatable), or
+	// JSON {"attachmentId"} to attach something sent to /api/upload earlier.
+	// ?cover=true makes the (first) new attachment the card cover.
+	router.POST("/api/cards/:id/attachments", func(c *gin.Context) {
+		card, ok := loadCard(c)
+		if !ok {
+			return
+		}
+		var added []Attachment
+		if form, err := c.MultipartForm(); err == nil {
+			files := form.File["file"]
+			if len(files) == 0 {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "no file"})
+				return
+			}
+			for _, file := range files {
+				att, err := saveUpload(c, file)
+				if err != nil {
+					c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+					return
+				}
+				att.CardID, att.BoardID = card.ID, card.BoardID
+				if _, err := attachColl.InsertOne(context.TODO(), att); err != nil {
+					c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+					return
+				}
+				added = append(added, att)
+			}
+		} else {
+			var in struct {
+				AttachmentID string `json:"attachmentId"`
+			}
+			if err := c.BindJSON(&in); err != nil {
+				c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+				return
+			}
+			var att Attachment
+			err := attachColl.FindOneAndUpdate(context.TODO(),
+				bson.M{"_id": in.AttachmentID, "cardId": nil},
+				bson.M{"$set": bson.M{"cardId": card.ID, "boardId": card.BoardID}},
+				options.FindOneAndUpdate().SetReturnDocument(options.After)).Decode(&att)
+			if err != nil {
+				c.JSON(http.StatusNotFound, gin.H{"error": "unattached upload not found"})
+				return
+			}
+			added = append(added, att)
+		}
+
+		diff := make([]ActivityChange, len(added))
+		for i, att := range added {
+			diff[i] = ActivityChange{Field: "attachment", New: att.Name}
+		}
+		changes.cardAction(currentUserID(c), card, ActionUpdated, diff...)
+		if c.Query("cover") == "true" {
+			if err := setCover(c, card, added[0].URL); err != nil {
+				c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+				return
+			}
+		}
+		c.JSON(http.StatusOK, added)
+	})
+
+	// POST /api/cards/:id/cover {"attachmentId"} — "" removes the cover
+	router.POST("/api/cards/:id/cover", func(c *gin.Context) {
+		var in struct {
+			AttachmentID string `json:"attachmentId"`
+		}
+		if err := c.BindJSON(&in); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		card, ok := loadCard(c)
+		if !ok {
+			return
+		}
+		url := ""
+		if in.AttachmentID != "" {
+			var att Attachment
+			if err := attachColl.FindOne(context.TODO(), bson.M{"_id": in.AttachmentID, "cardId": card.ID}).Decode(&att); err != nil {
+				c.JSON(http.StatusNotFound, gin.H{"error": "attachment not found on this card"})
+				return
+			}
+			url = att.URL
+		}
+		if err := setCover(c, card, url); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		card.fillProgress()
+		c.JSON(http.StatusOK, card)
+	})
+
+	// The file itself goes with the next cleanupUploads run once nothing
+	// references it.
+	router.DELETE("/api/attachments/:id", func(c *gin.Context) {
+		var att Attachment
+		if err := attachColl.FindOne(context.TODO(), bson.M{"_id": c.Param("id")}).Decode(&att); err != nil {
+			c.JSON(http.StatusNotFound, gin.H{"error": "not found"})
+			return
+		}
+		if _, err := attachColl.DeleteOne(context.TODO(), bson.M{"_id": att.ID}); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		var card Card
+		if att.CardID != "" && cardColl.FindOne(context.TODO(), bson.M{"_id": att.CardID}).Decode(&card) == nil {
+			changes.cardAction(currentUserID(c), &card, ActionUpdated, ActivityChange{Field: "attachment", Old: att.Name})
+			if card.Image == att.URL {
+				_ = setCover(c, &card, "")
+			}
+		}
+		c.JSON(http.StatusOK, gin.H{"status": "deleted"})
+	})
+}
@@ -0,0 +1,851 @@
+package kanban
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"bell-backend/webhooks"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// Rule triggers.
+const (
+	TriggerCardCreated = "card_created"
+	TriggerCardMoved   = "card_moved"
+	TriggerTagAdded    = "tag_added"
+	TriggerDueSoon     = "due_soon"
+)
+
+// Rule actions.
+const (
+	RuleMove     = "move"
+	RuleTag      = "tag"
+	RuleUntag    = "untag"
+	RuleAssign   = "assign"
+	RuleUnassign = "unassign"
+	RuleComment  = "comment"
+	RuleWebhook  = "webhook"
+)
+
+// ruleWebhookEvent is the event a webhook action sends; its data is the
+// rule and the card as the rule left it.
+const ruleWebhookEvent = "rule.webhook"
+
+// maxRuleDepth is how many rounds of rules a single change can set off: a
+// rule's own changes fire rules again, up to this depth.
+const maxRuleDepth = 3
+
+// RuleTrigger says which card event starts a rule. The optional fields
+// narrow it down: the columns a move goes from and to, the tag added, and
+// for due_soon how many minutes before the due date it fires (a day by
+// default).
+type RuleTrigger struct {
+	Type         string `bson:"type" json:"type"`
+	StatusID     string `bson:"statusId,omitempty" json:"statusId,omitempty"`
+	FromStatusID string `bson:"fromStatusId,omitempty" json:"fromStatusId,omitempty"`
+	Tag          string `bson:"tag,omitempty" json:"tag,omitempty"`
+	Within       int    `bson:"within,omitempty" json:"within,omitempty"`
+}
+
+// RuleAction is one step of a rule. Which fields apply depends on Type:
+// move takes StatusID and Position ("top", "bottom" or "" to keep it), tag
+// and untag take Tag, assign and unassign take UserIDs (unassign without
+// any clears all assignees), comment takes Text and webhook WebhookID, one
+// of the rule creator's webhook subscriptions. The call goes through its
+// delivery queue, signed and retried, whatever events it takes otherwise;
+// a subscription for "rule.webhook" only gets these.
+type RuleAction struct {
+	Type      string   `bson:"type" json:"type"`
+	StatusID  string   `bson:"statusId,omitempty" json:"statusId,omitempty"`
+	Position  string   `bson:"position,omitempty" json:"position,omitempty"`
+	Tag       string   `bson:"tag,omitempty" json:"tag,omitempty"`
+	UserIDs   []string `bson:"userIds,omitempty" json:"userIds,omitempty"`
+	Text      string   `bson:"text,omitempty" json:"text,omitempty"`
+	WebhookID string   `bson:"webhookId,omitempty" json:"webhookId,omitempty"`
+}
+
+// Rule is a board automation: when Trigger happens to a card that matches
+// Condition (the card query language of query.go, "" for any card), run
+// Actions in order. Changes are made on behalf of the rule's creator.
+type Rule struct {
+	ID        string       `bson:"_id,omitempty" json:"_id"`
+	BoardID   string       `bson:"boardId" json:"boardId"`
+	Name      string       `bson:"name" json:"name"`
+	Enabled   bool         `bson:"enabled" json:"enabled"`
+	Trigger   RuleTrigger  `bson:"trigger" json:"trigger"`
+	Condition string       `bson:"condition,omitempty" json:"condition,omitempty"`
+	Actions   []RuleAction `bson:"actions" json:"actions"`
+	CreatedBy string       `bson:"createdBy,omitempty" json:"createdBy,omitempty"`
+	CreatedAt time.Time    `bson:"createdAt" json:"createdAt"`
+	UpdatedAt time.Time    `bson:"updatedAt" json:"updatedAt"`
+}
+
+// validate checks a rule against its board's columns and its creator's
+// webhooks.
+func (r *Rule) validate(ctx context.Context, db *mongo.Database, hooks *webhooks.Dispatcher) error {
+	if strings.TrimSpace(r.Name) == "" {
+		return errors.New("name is required")
+	}
+	column := func(id string) error {
+		if id == "" {
+			return nil
+		}
+		if n, _ := db.Collection("statuses").CountDocuments(ctx, bson.M{"_id": id, "boardId": r.BoardID, "deletedAt": nil}); n == 0 {
+			return fmt.Errorf("status %q not found on this board", id)
+		}
+		return nil
+	}
+	switch r.Trigger.Type {
+	case TriggerCardCreated, TriggerTagAdded:
+	case TriggerCardMoved:
+		if err := column(r.Trigger.StatusID); err != nil {
+			return err
+		}
+		if err := column(r.Trigger.FromStatusID); err != nil {
+			return err
+		}
+	case TriggerDueSoon:
+		if r.Trigger.Within < 0 || r.Trigger.Within > maxReminder {
+			return fmt.Errorf("within must be 0..%d minutes", maxReminder)
+		}
+	default:
+		return fmt.Errorf("unknown trigger %q", r.Trigger.Type)
+	}
+	if r.Condition != "" {
+		if _, err := compileQuery(ctx, db, r.Condition, r.CreatedBy); err != nil {
+			return err
+		}
+	}
+	if len(r.Actions) == 0 {
+		return errors.New("a rule needs at least one action")
+	}
+	for i, a := range r.Actions {
+		var err error
+		switch a.Type {
+		case RuleMove:
+			if a.StatusID == "" {
+				err = errors.New("statusId is required")
+			} else if a.Position != "" && a.Position != "top" && a.Position != "bottom" {
+				err = errors.New(`position must be "top", "bottom" or ""`)
+			} else {
+				err = column(a.StatusID)
+			}
+		case RuleTag, RuleUntag:
+			if strings.TrimSpace(a.Tag) == "" {
+				err = errors.New("tag is required")
+			}
+		case RuleAssign:
+			if len(a.UserIDs) == 0 {
+				err = errors.New("userIds is required")
+			}
+		case RuleUnassign:
+		case RuleComment:
+			if strings.TrimSpace(a.Text) == "" {
+				err = errors.New("text is required")
+			}
+		case RuleWebhook:
+			if a.WebhookID == "" {
+				err = errors.New("webhookId is required")
+			} else if owner, herr := hooks.Owner(a.WebhookID); herr != nil {
+				err = herr
+			} else if owner != r.CreatedBy {
+				err = errors.New("webhook not found")
+			}
+		default:
+			err = fmt.Errorf("unknown action %q", a.Type)
+		}
+		if err != nil {
+			return fmt.Errorf("action %d: %v", i+1, err)
+		}
+	}
+	return nil
+}
+
+// describe says what an action does, for dry runs and the execution log.
+func (a RuleAction) describe() string {
+	switch a.Type {
+	case RuleMove:
+		if a.Position != "" {
+			return fmt.Sprintf("move to %s (%s)", a.StatusID, a.Position)
+		}
+		return "move to " + a.StatusID
+	case RuleTag:
+		return "add tag " + a.Tag
+	case RuleUntag:
+		return "remove tag " + a.Tag
+	case RuleAssign:
+		return "assign " + strings.Join(a.UserIDs, ", ")
+	case RuleUnassign:
+		if len(a.UserIDs) == 0 {
+			return "clear assignees"
+		}
+		return "unassign " + strings.Join(a.UserIDs, ", ")
+	case RuleComment:
+		return "comment " + strconv.Quote(a.Text)
+	case RuleWebhook:
+		return "call webhook " + a.WebhookID
+	}
+	return a.Type
+}
+
+// RuleRun is an entry of the execution log: a rule that fired on a card, or
+// was skipped by the loop protection.
+type RuleRun struct {
+	ID      string   `bson:"_id,omitempty" json:"_id"`
+	RuleID  string   `bson:"ruleId" json:"ruleId"`
+	BoardID string   `bson:"boardId" json:"boardId"`
+	CardID  string   `bson:"cardId" json:"cardId"`
+	Trigger string   `bson:"trigger" json:"trigger"`
+	Depth   int      `bson:"depth" json:"depth"`   // 0 when set off by a person
+	Status  string   `bson:"status" json:"status"` // "ok", "error" or "skipped"
+	Actions []string `bson:"actions,omitempty" json:"actions,omitempty"`
+	Error   string   `bson:"error,omitempty" json:"error,omitempty"`
+	// Key makes once-only triggers (due_soon) fire once across replicas.
+	Key       string    `bson:"key,omitempty" json:"-"`
+	CreatedAt time.Time `bson:"createdAt" json:"createdAt"`
+}
+
+// ruleChain follows one user change through the rules it sets off. A rule
+// runs at most once per card in a chain, and the chain stops at maxRuleDepth.
+type ruleChain struct {
+	depth int
+	seen  map[string]bool
+}
+
+// ruleEvent is a trigger that happened to a card.
+type ruleEvent struct {
+	trigger string
+	tag     string // tag_added
+}
+
+// cardEvents lists the triggers a change between before and after sets off.
+func cardEvents(before, after *Card) []ruleEvent {
+	if after == nil || after.DeletedAt != nil {
+		return nil
+	}
+	var events []ruleEvent
+	var old *Card
+	switch {
+	case before == nil:
+		events = append(events, ruleEvent{trigger: TriggerCardCreated})
+	default:
+		if before.StatusID != after.StatusID || before.BoardID != after.BoardID {
+			events = append(events, ruleEvent{trigger: TriggerCardMoved})
+		}
+		if before.BoardID == after.BoardID {
+			old = before
+		}
+	}
+	// a label is added when neither its id nor its name was there before,
+	// so renaming a label doesn't count
+	had := map[string]bool{}
+	if old != nil {
+		for _, t := range old.Tags {
+			had[labelKey(t)] = true
+		}
+		for _, id := range old.Labels {
+			had[id] = true
+		}
+	}
+	for i, t := range after.Tags {
+		if had[labelKey(t)] || (i < len(after.Labels) && had[after.Labels[i]]) {
+			continue
+		}
+		events = append(events, ruleEvent{trigger: TriggerTagAdded, tag: t})
+	}
+	return events
+}
+
+// matches reports whether the rule's trigger fits the event.
+func (r *Rule) matches(ev ruleEvent, before, after *Card) bool {
+	if r.Trigger.Type != ev.trigger {
+		return false
+	}
+	switch ev.trigger {
+	case TriggerCardMoved:
+		if r.Trigger.StatusID != "" && r.Trigger.StatusID != after.StatusID {
+			return false
+		}
+		if r.Trigger.FromStatusID != "" && (before == nil || r.Trigger.FromStatusID != before.StatusID) {
+			return false
+		}
+	case TriggerTagAdded:
+		if r.Trigger.Tag != "" && labelKey(r.Trigger.Tag) != labelKey(ev.tag) {
+			return false
+		}
+	}
+	return true
+}
+
+// automation runs board rules. It is fed by the changeLog, so every card
+// change made through the API, and the rules' own changes, are seen.
+type automation struct {
+	db       *mongo.Database
+	rules    *mongo.Collection
+	runs     *mongo.Collection
+	cards    *mongo.Collection
+	comments *mongo.Collection
+	changes  *changeLog
+	hooks    *webhooks.Dispatcher
+}
+
+func newAutomation(db *mongo.Database, changes *changeLog) *automation {
+	return &automation{
+		db:       db,
+		rules:    db.Collection("rules"),
+		runs:     db.Collection("rule_runs"),
+		cards:    db.Collection("cards"),
+		comments: db.Collection("comments"),
+		changes:  changes,
+		hooks:    changes.hub.hooks,
+	}
+}
+
+// cardChanged runs the rules set off by a card change. chain is nil for a
+// change made by a person.
+func (a *automation) cardChanged(before, after *Card, chain *ruleChain) {
+	if a == nil {
+		return
+	}
+	events := cardEvents(before, after)
+	if len(events) == 0 {
+		return
+	}
+	if chain == nil {
+		chain = &ruleChain{seen: map[string]bool{}}
+	}
+	ctx := context.TODO()
+	types := bson.A{}
+	for _, ev := range events {
+		types = append(types, ev.trigger)
+	}
+	var rules []Rule
+	cur, err := a.rules.Find(ctx, bson.M{"boardId": after.BoardID, "enabled": true, "trigger.type": bson.M{"$in": types}},
+		options.Find().SetSort(bson.D{{Key: "_id", Value: 1}}))
+	if err != nil {
+		log.Println("kanban: rules:", err)
+		return
+	}
+	if err := cur.All(ctx, &rules); err != nil {
+		log.Println("kanban: rules:", err)
+		return
+	}
+	for i := range rules {
+		rule := &rules[i]
+		for _, ev := range events {
+			if rule.matches(ev, before, after) {
+				a.fire(ctx, rule, after.ID, ev.trigger, chain, "")
+				break
+			}
+		}
+	}
+}
+
+// fire checks the condition and runs the rule's actions on a card, writing
+// the execution log entry. key, when set, makes the run once-only.
+func (a *automation) fire(ctx context.Context, rule *Rule, cardID, trigger string, chain *ruleChain, key string) {
+	run := RuleRun{
+		ID:        newID(),
+		RuleID:    rule.ID,
+		BoardID:   rule.BoardID,
+		CardID:    cardID,
+		Trigger:   trigger,
+		Depth:     chain.depth,
+		Key:       key,
+		CreatedAt: time.Now(),
+	}
+	once := rule.ID + "/" + cardID
+	if chain.depth >= maxRuleDepth || chain.seen[once] {
+		run.Status = "skipped"
+		run.Error = "loop protection: rule already ran on this card in this chain or chain too deep"
+		_, _ = a.runs.InsertOne(ctx, run)
+		return
+	}
+	if ok, err := a.conditionHolds(ctx, rule, cardID); err != nil || !ok {
+		return
+	}
+	chain.seen[once] = true
+	if key != "" {
+		// claim first: another replica may be firing the same occurrence
+		run.Status = "running"
+		if _, err := a.runs.InsertOne(ctx, run); err != nil {
+			return
+		}
+	}
+
+	before, after, done, err := a.apply(ctx, rule, cardID)
+	run.Actions = done
+	run.Status = "ok"
+	if err != nil {
+		run.Status, run.Error = "error", err.Error()
+	}
+	if key != "" {
+		_, _ = a.runs.ReplaceOne(ctx, bson.M{"_id": run.ID}, run)
+	} else {
+		_, _ = a.runs.InsertOne(ctx, run)
+	}
+	if after != nil && len(diffCards(before, after)) > 0 {
+		a.changes.record(rule.CreatedBy, before, after)
+		a.cardChanged(before, after, &ruleChain{depth: chain.depth + 1, seen: chain.seen})
+	}
+}
+
+// conditionHolds checks the rule's condition against the card as stored now.
+func (a *automation) conditionHolds(ctx context.Context, rule *Rule, cardID string) (bool, error) {
+	filter := bson.M{"_id": cardID, "deletedAt": nil}
+	if rule.Condition != "" {
+		cond, err := compileQuery(ctx, a.db, rule.Condition, rule.CreatedBy)
+		if err != nil {
+			return false, err
+		}
+		filter = bson.M{"$and": bson.A{filter, cond}}
+	}
+	n, err := a.cards.CountDocuments(ctx, filter)
+	return n > 0, err
+}
+
+// apply runs the actions in order. Card field changes are gathered and
+// written in one update, if nobody wrote the card since it was read, and
+// comments happen as they come; webhook calls are queued last, with the
+// card as written. It returns the card before and after (after is nil when
+// the card wasn't written) and the actions that were done.
+func (a *automation) apply(ctx context.Context, rule *Rule, cardID string) (*Card, *Card, []string, error) {
+	var before Card
+	if err := a.cards.FindOne(ctx, bson.M{"_id": cardID}).Decode(&before); err != nil {
+		return nil, nil, nil, err
+	}
+	card := before
+	card.Tags = append([]string(nil), before.Tags...)
+	card.Assignees = append([]string(nil), before.Assignees...)
+	var done []string
+	var calls []RuleAction
+	fail := func(act RuleAction, err error) (*Card, *Card, []string, error) {
+		return nil, nil, done, fmt.Errorf("%s: %v", act.describe(), err)
+	}
+	for _, act := range rule.Actions {
+		switch act.Type {
+		case RuleMove:
+			if msg, refuse := checkBlockedMove(ctx, a.db, &card, act.StatusID); refuse {
+				return fail(act, errors.New(msg))
+			}
+			if act.Position != "" {
+				// one step past the first (top) or last (bottom) card of the column
+				step, dir := 1.0, -1
+				if act.Position == "top" {
+					step, dir = -1, 1
+				}
+				var edge Card
+				err := a.cards.FindOne(ctx,
+					bson.M{"boardId": card.BoardID, "statusId": act.StatusID, "deletedAt": nil, "_id": bson.M{"$ne": card.ID}},
+					options.FindOne().SetSort(bson.D{{Key: "position", Value: dir}})).Decode(&edge)
+				if err == nil {
+					card.Position = edge.Position + step
+				}
+			}
+			card.StatusID = act.StatusID
+		case RuleTag:
+			card.Tags = append(card.Tags, act.Tag) // repeats go in resolveCardLabels
+		case RuleUntag:
+			var kept []string
+			for _, t := range card.Tags {
+				if labelKey(t) != labelKey(act.Tag) {
+					kept = append(kept, t)
+				}
+			}
+			card.Tags = kept
+		case RuleAssign:
+			for _, id := range act.UserIDs {
+				if !containsString(card.Assignees, id) {
+					card.Assignees = append(card.Assignees, id)
+				}
+			}
+		case RuleUnassign:
+			if len(act.UserIDs) == 0 {
+				card.Assignees = nil
+			} else {
+				card.Assignees = removeStrings(card.Assignees, act.UserIDs...)
+			}
+		case RuleComment:
+			now := time.Now()
+			cm := Comment{ID: newID(), CardID: card.ID, BoardID: card.BoardID, AuthorID: rule.CreatedBy, Body: act.Text, CreatedAt: now, UpdatedAt: now}
+			if _, err := a.comments.InsertOne(ctx, cm); err != nil {
+				return fail(act, err)
+			}
+		case RuleWebhook:
+			calls = append(calls, act)
+			continue
+		}
+		done = append(done, act.describe())
+	}
+
+	if err := resolveCardLabels(ctx, a.db, &card, true); err != nil {
+		return nil, nil, done, err
+	}
+	if len(card.Assignees) == 0 {
+		card.Assignees = nil
+	}
+	if len(diffCards(&before, &card)) == 0 {
+		return &before, nil, done, a.call(calls, rule, &before, &done)
+	}
+	card.UpdatedAt = time.Now()
+	set := bson.M{"statusId": card.StatusID, "position": card.Position, "updatedAt": card.UpdatedAt}
+	unset := bson.M{}
+	for field, v := range map[string][]string{"tags": card.Tags, "labels": card.Labels, "assignees": card.Assignees} {
+		if v == nil {
+			unset[field] = ""
+		} else {
+			set[field] = v
+		}
+	}
+	update := bson.M{"$set": set}
+	if len(unset) > 0 {
+		update["$unset"] = unset
+	}
+	// the card as read: a write in between wins, and the run says so
+	read := &precondition{versions: []int64{before.Version}}
+	res, err := a.cards.UpdateOne(ctx, read.filter(bson.M{"_id": card.ID}), withVersion(update))
+	if err != nil {
+		return nil, nil, done, err
+	}
+	if res.MatchedCount == 0 {
+		return nil, nil, done, errors.New("card changed while the rule ran; its card changes were not made")
+	}
+	card.Version++
+	return &before, &card, done, a.call(calls, rule, &card, &done)
+}
+
+// call queues the webhook actions with the card as the rule left it. The
+// subscription's delivery log shows how each went.
+func (a *automation) call(calls []RuleAction, rule *Rule, card *Card, done *[]string) error {
+	for _, act := range calls {
+		data := gin.H{"rule": gin.H{"_id": rule.ID, "name": rule.Name}, "card": card}
+		if err := a.hooks.Deliver(act.WebhookID, ruleWebhookEvent, card.BoardID, rule.CreatedBy, data); err != nil {
+			return fmt.Errorf("%s: %v", act.describe(), err)
+		}
+		*done = append(*done, act.describe())
+	}
+	return nil
+}
+
+// dueSoon fires due_soon rules for cards whose due date is within the
+// rule's window. Each rule fires once per card and due date.
+func (a *automation) dueSoon(now time.Time) {
+	ctx := context.TODO()
+	var rules []Rule
+	cur, err := a.rules.Find(ctx, bson.M{"enabled": true, "trigger.type": TriggerDueSoon})
+	if err != nil {
+		return
+	}
+	if err := cur.All(ctx, &rules); err != nil {
+		return
+	}
+	for i := range rules {
+		rule := &rules[i]
+		within := rule.Trigger.Within
+		if within == 0 {
+			within = 24 * 60
+		}
+		var cards []Card
+		cur, err := a.cards.Find(ctx, bson.M{
+			"boardId":    rule.BoardID,
+			"deletedAt":  nil,
+			"archivedAt": nil,
+			"dueDate":    bson.M{"$gt": now, "$lte": now.Add(time.Duration(within) * time.Minute)},
+		})
+		if err != nil {
+			continue
+		}
+		if err := cur.All(ctx, &cards); err != nil {
+			continue
+		}
+		for _, card := range cards {
+			key := fmt.Sprintf("%s/%s/%d", rule.ID, card.ID, card.DueDate.Unix())
+			if n, _ := a.runs.CountDocuments(ctx, bson.M{"key": key}); n > 0 {
+				continue
+			}
+			a.fire(ctx, rule, card.ID, TriggerDueSoon, &ruleChain{seen: map[string]bool{}}, key)
+		}
+	}
+}
+
+// startAutomationScheduler checks due_soon rules every minute.
+func startAutomationScheduler(a *automation) {
+	go func() {
+		ticker := time.NewTicker(time.Minute)
+		defer ticker.Stop()
+		for {
+			<-ticker.C
+			a.dueSoon(time.Now())
+		}
+	}()
+}
+
+// RuleDryRun is what a rule would do to one card.
+type RuleDryRun struct {
+	CardID  string   `json:"cardId"`
+	Title   string   `json:"title"`
+	Actions []string `json:"actions"`
+}
+
+// dryRun lists the live cards of the board the rule would act on if its
+// trigger happened to them now, without changing anything. Triggers that
+// depend on a card's state (the column moved to, the tag, the due window)
+// narrow the cards down; the condition is applied as well.
+func (a *automation) dryRun(ctx context.Context, rule *Rule, limit int) ([]RuleDryRun, int, error) {
+	filters := bson.A{bson.M{"boardId": rule.BoardID, "deletedAt": nil, "archivedAt": nil}}
+	switch rule.Trigger.Type {
+	case TriggerCardMoved:
+		if rule.Trigger.StatusID != "" {
+			filters = append(filters, bson.M{"statusId": rule.Trigger.StatusID})
+		}
+	case TriggerTagAdded:
+		if rule.Trigger.Tag != "" {
+			ids, err := labelIDs(ctx, a.db, rule.BoardID, []string{rule.Trigger.Tag})
+			if err != nil {
+				return nil, 0, err
+			}
+			filters = append(filters, bson.M{"labels": bson.M{"$in": ids}})
+		}
+	case TriggerDueSoon:
+		within := rule.Trigger.Within
+		if within == 0 {
+			within = 24 * 60
+		}
+		now := time.Now()
+		filters = append(filters, bson.M{"dueDate": bson.M{"$gt": now, "$lte": now.Add(time.Duration(within) * time.Minute)}})
+	}
+	if rule.Condition != "" {
+		cond, err := compileQuery(ctx, a.db, rule.Condition, rule.CreatedBy)
+		if err != nil {
+			return nil, 0, err
+		}
+		filters = append(filters, cond)
+	}
+	filter := bson.M{"$and": filters}
+	total, err := a.cards.CountDocuments(ctx, filter)
+	if err != nil {
+		return nil, 0, err
+	}
+	cur, err := a.cards.Find(ctx, filter, options.Find().SetSort(bson.D{{Key: "_id", Value: 1}}).SetLimit(int64(limit)))
+	if err != nil {
+		return nil, 0, err
+	}
+	var cards []Card
+	if err := cur.All(ctx, &cards); err != nil {
+		return nil, 0, err
+	}
+	out := make([]RuleDryRun, 0, len(cards))
+	for _, card := range cards {
+		dr := RuleDryRun{CardID: card.ID, Title: card.Title, Actions: []string{}}
+		for _, act := range rule.Actions {
+			dr.Actions = append(dr.Actions, act.describe())
+		}
+		out = append(out, dr)
+	}
+	return out, int(total), nil
+}
+
+func containsString(list []string, s string) bool {
+	for _, v := range list {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
+
+// removeStrings returns list without any of drop.
+func removeStrings(list []string, drop ...string) []string {
+	var out []string
+	for _, v := range list {
+		if !containsString(drop, v) {
+			out = append(out, v)
+		}
+	}
+	return out
+}
+
+func registerRuleRoutes(router *gin.Engine, db *mongo.Database, changes *changeLog) {
+	auto := newAutomation(db, changes)
+	changes.rules = auto
+	startAutomationScheduler(auto)
+	_, _ = auto.rules.Indexes().CreateOne(context.TODO(), mongo.IndexModel{
+		Keys: bson.D{{Key: "boardId", Value: 1}, {Key: "trigger.type", Value: 1}},
+	})
+	_, _ = auto.runs.Indexes().CreateMany(context.TODO(), []mongo.IndexModel{
+		{Keys: bson.D{{Key: "boardId", Value: 1}, {Key: "createdAt", Value: -1}}},
+		{
+			Keys: bson.D{{Key: "key", Value: 1}},
+			Options: options.Index().SetUnique(true).
+				SetPartialFilterExpression(bson.M{"key": bson.M{"$exists": true}}),
+		},
+	})
+
+	loadRule := func(c *gin.Context) (*Rule, bool) {
+		var r Rule
+		if err := auto.rules.FindOne(context.TODO(), bson.M{"_id": c.Param("id")}).Decode(&r); err != nil {
+			c.JSON(http.StatusNotFound, gin.H{"error": "rule not found"})
+			return nil, false
+		}
+		return &r, true
+	}
+
+	router.GET("/api/boards/:id/rules", func(c *gin.Context) {
+		cur, err := auto.rules.Find(context.TODO(), bson.M{"boardId": c.Param("id")}, options.Find().SetSort(bson.D{{Key: "_id", Value: 1}}))
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		out := []Rule{}
+		if err := cur.All(context.TODO(), &out); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(http.StatusOK, out)
+	})
+
+	router.POST("/api/boards/:id/rules", requireUser(), func(c *gin.Context) {
+		var r Rule
+		if err := c.BindJSON(&r); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		r.BoardID = c.Param("id")
+		r.CreatedBy = currentUserID(c)
+		if n, _ := db.Collection("boards").CountDocuments(context.TODO(), bson.M{"_id": r.BoardID, "deletedAt": nil}); n == 0 {
+			c.JSON(http.StatusNotFound, gin.H{"error": "board not found"})
+			return
+		}
+		if err := r.validate(context.TODO(), db, auto.hooks); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		now := time.Now()
+		r.ID = newID()
+		r.CreatedAt, r.UpdatedAt = now, now
+		if _, err := auto.rules.InsertOne(context.TODO(), r); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(http.StatusOK, r)
+	})
+
+	// PUT /api/rules/:id replaces the rule; board and creator stay.
+	router.PUT("/api/rules/:id", requireUser(), func(c *gin.Context) {
+		before, ok := loadRule(c)
+		if !ok {
+			return
+		}
+		var r Rule
+		if err := c.BindJSON(&r); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		r.ID, r.BoardID, r.CreatedBy, r.CreatedAt = before.ID, before.BoardID, before.CreatedBy, before.CreatedAt
+		if err := r.validate(context.TODO(), db, auto.hooks); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		r.UpdatedAt = time.Now()
+		if _, err := auto.rules.ReplaceOne(context.TODO(), bson.M{"_id": r.ID}, r); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(http.StatusOK, r)
+	})
+
+	router.DELETE("/api/rules/:id", requireUser(), func(c *gin.Context) {
+		r, ok := loadRule(c)
+		if !ok {
+			return
+		}
+		if _, err := auto.rules.DeleteOne(context.TODO(), bson.M{"_id": r.ID}); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{"status": "deleted"})
+	})
+
+	// POST /api/boards/:id/rules/dry-run?limit=50 tests a rule, sent as the
+	// body, against the board's cards; POST /api/rules/:id/dry-run tests a
+	// saved one. Nothing is changed.
+	dryRun := func(c *gin.Context, r *Rule) {
+		limit, _ := strconv.Atoi(c.DefaultQuery("limit", "50"))
+		if limit < 1 || limit > 500 {
+			limit = 50
+		}
+		if err := r.validate(context.TODO(), db, auto.hooks); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		cards, total, err := auto.dryRun(context.TODO(), r, limit)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{"matched": total, "cards": cards})
+	}
+	router.POST("/api/boards/:id/rules/dry-run", func(c *gin.Context) {
+		var r Rule
+		if err := c.BindJSON(&r); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		r.BoardID = c.Param("id")
+		r.CreatedBy = currentUserID(c)
+		dryRun(c, &r)
+	})
+	router.POST("/api/rules/:id/dry-run", func(c *gin.Context) {
+		if r, ok := loadRule(c); ok {
+			dryRun(c, r)
+		}
+	})
+
+	// GET /api/boards/:id/rules/runs?ruleId=&page=1&limit=50 — the
+	// execution log, newest first.
+	router.GET("/api/boards/:id/rules/runs", func(c *gin.Context) {
+		page, _ := strconv.Atoi(c.DefaultQuery("page", "1"))
+		limit, _ := strconv.Atoi(c.DefaultQuery("limit", "50"))
+		if page < 1 {
+			page = 1
+		} else if page > maxPage {
+			page = maxPage
+		}
+		if limit < 1 || limit > 200 {
+			limit = 50
+		}
+		filter := bson.M{"boardId": c.Param("id")}
+		if id := c.Query("ruleId"); id != "" {
+			filter["ruleId"] = id
+		}
+		total, err := auto.runs.CountDocuments(context.TODO(), filter)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		cur, err := auto.runs.Find(context.TODO(), filter, options.Find().
+			SetSort(bson.D{{Key: "createdAt", Value: -1}, {Key: "_id", Value: -1}}).
+			SetSkip(int64((page-1)*limit)).
+			SetLimit(int64(limit)))
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		items := []RuleRun{}
+		if err := cur.All(context.TODO(), &items); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{"items": items, "total": total, "page": page, "limit": limit})
+	})
+}
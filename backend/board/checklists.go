@@ -0,0 +1,390 @@
+package kanban
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"reflect"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// Checklist is a named, ordered list of items embedded in a card.
+type Checklist struct {
+	ID    string          `bson:"id" json:"id"`
+	Name  string          `bson:"name" json:"name"`
+	Items []ChecklistItem `bson:"items" json:"items"`
+}
+
+// ChecklistItem is one step of a checklist. Once converted into a full card,
+// CardID points at it.
+type ChecklistItem struct {
+	ID         string     `bson:"id" json:"id"`
+	Text       string     `bson:"text" json:"text"`
+	Done       bool       `bson:"done" json:"done"`
+	AssigneeID string     `bson:"assigneeId,omitempty" json:"assigneeId,omitempty"`
+	DueDate    *time.Time `bson:"dueDate,omitempty" json:"dueDate,omitempty"`
+	CardID     string     `bson:"cardId,omitempty" json:"cardId,omitempty"`
+}
+
+// ChecklistProgress is the done/total count over all checklists of a card.
+type ChecklistProgress struct {
+	Done  int `json:"done"`
+	Total int `json:"total"`
+}
+
+// fillProgress computes card.Progress from its checklists.
+func (card *Card) fillProgress() {
+	if len(card.Checklists) == 0 {
+		card.Progress = nil
+		return
+	}
+	p := ChecklistProgress{}
+	for _, cl := range card.Checklists {
+		for _, it := range cl.Items {
+			p.Total++
+			if it.Done {
+				p.Done++
+			}
+		}
+	}
+	card.Progress = &p
+}
+
+func (card *Card) checklist(id string) *Checklist {
+	for i := range card.Checklists {
+		if card.Checklists[i].ID == id {
+			return &card.Checklists[i]
+		}
+	}
+	return nil
+}
+
+func (cl *Checklist) itemIndex(id string) int {
+	for i := range cl.Items {
+		if cl.Items[i].ID == id {
+			return i
+		}
+	}
+	return -1
+}
+
+func registerChecklistRoutes(router *gin.Engine, db *mongo.Database, changes *changeLog) {
+	cardColl := db.Collection("cards")
+
+	loadCard := func(c *gin.Context) (*Card, bool) {
+		var card Card
+		if err := cardColl.FindOne(context.TODO(), bson.M{"_id": c.Param("id")}).Decode(&card); err != nil {
+			c.JSON(http.StatusNotFound, gin.H{"error": "card not found"})
+			return nil, false
+		}
+		return &card, true
+	}
+	loadChecklist := func(c *gin.Context) (*Card, *Checklist, bool) {
+		card, ok := loadCard(c)
+		if !ok {
+			return nil, nil, false
+		}
+		cl := card.checklist(c.Param("checklistId"))
+		if cl == nil {
+			c.JSON(http.StatusNotFound, gin.H{"error": "checklist not found"})
+			return nil, nil, false
+		}
+		return card, cl, true
+	}
+	loadItem := func(c *gin.Context) (*Card, *Checklist, int, bool) {
+		card, cl, ok := loadChecklist(c)
+		if !ok {
+			return nil, nil, -1, false
+		}
+		idx := cl.itemIndex(c.Param("itemId"))
+		if idx < 0 {
+			c.JSON(http.StatusNotFound, gin.H{"error": "item not found"})
+			return nil, nil, -1, false
+		}
+		return card, cl, idx, true
+	}
+	// write stores the card's checklists, whole, so only if the card is
+	// still at the version they were read at (and at one the client expects,
+	// with If-Match). Otherwise it answers 409 (412) with the card as it is
+	// now and returns false.
+	write := func(c *gin.Context, card *Card) bool {
+		pre, ok := readPrecondition(c, card.Version)
+		if !ok {
+			return false
+		}
+		card.UpdatedAt = time.Now()
+		res, err := cardColl.UpdateOne(context.TODO(), pre.filter(bson.M{"_id": card.ID}), withVersion(bson.M{
+			"$set": bson.M{"checklists": card.Checklists, "updatedAt": card.UpdatedAt},
+		}))
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return false
+		}
+		if res.MatchedCount > 0 {
+			card.Version++
+			return true
+		}
+		var current Card
+		if err := cardColl.FindOne(context.TODO(), bson.M{"_id": card.ID}).Decode(&current); err != nil {
+			c.JSON(http.StatusNotFound, gin.H{"error": "card not found"})
+			return false
+		}
+		current.fillProgress()
+		pre.fail(c, current.Version, current)
+		return false
+	}
+	save := func(c *gin.Context, card *Card, diff ...ActivityChange) {
+		if !write(c, card) {
+			return
+		}
+		card.fillProgress()
+		changes.cardAction(currentUserID(c), card, ActionChecklist, diff...)
+		c.JSON(http.StatusOK, card)
+	}
+
+	// --- CHECKLISTS ---
+	router.POST("/api/cards/:id/checklists", func(c *gin.Context) {
+		var in struct {
+			Name string `json:"name"`
+		}
+		if err := c.BindJSON(&in); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		card, ok := loadCard(c)
+		if !ok {
+			return
+		}
+		if strings.TrimSpace(in.Name) == "" {
+			in.Name = "Checklist"
+		}
+		card.Checklists = append(card.Checklists, Checklist{ID: newID(), Name: in.Name, Items: []ChecklistItem{}})
+		save(c, card, ActivityChange{Field: "checklist", New: in.Name})
+	})
+
+	router.PUT("/api/cards/:id/checklists/:checklistId", func(c *gin.Context) {
+		var in struct {
+			Name string `json:"name"`
+		}
+		if err := c.BindJSON(&in); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		card, cl, ok := loadChecklist(c)
+		if !ok {
+			return
+		}
+		change := ActivityChange{Field: "checklist.name", Old: cl.Name, New: cl.Name}
+		if strings.TrimSpace(in.Name) != "" {
+			cl.Name = in.Name
+			change.New = in.Name
+		}
+		save(c, card, change)
+	})
+
+	router.DELETE("/api/cards/:id/checklists/:checklistId", func(c *gin.Context) {
+		card, ok := loadCard(c)
+		if !ok {
+			return
+		}
+		var diff []ActivityChange
+		kept := card.Checklists[:0]
+		for _, cl := range card.Checklists {
+			if cl.ID != c.Param("checklistId") {
+				kept = append(kept, cl)
+				continue
+			}
+			diff = append(diff, ActivityChange{Field: "checklist", Old: cl.Name})
+		}
+		if len(diff) == 0 {
+			c.JSON(http.StatusNotFound, gin.H{"error": "checklist not found"})
+			return
+		}
+		card.Checklists = kept
+		save(c, card, diff...)
+	})
+
+	// --- ITEMS ---
+	router.POST("/api/cards/:id/checklists/:checklistId/items", func(c *gin.Context) {
+		var in struct {
+			Text       string     `json:"text"`
+			AssigneeID string     `json:"assigneeId"`
+			DueDate    *time.Time `json:"dueDate"`
+			Position   *int       `json:"position"` // optional, appended when empty
+		}
+		if err := c.BindJSON(&in); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		if strings.TrimSpace(in.Text) == "" {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "text is required"})
+			return
+		}
+		card, cl, ok := loadChecklist(c)
+		if !ok {
+			return
+		}
+		item := ChecklistItem{ID: newID(), Text: in.Text, AssigneeID: in.AssigneeID, DueDate: in.DueDate}
+		pos := len(cl.Items)
+		if in.Position != nil && *in.Position >= 0 && *in.Position < pos {
+			pos = *in.Position
+		}
+		cl.Items = append(cl.Items, ChecklistItem{})
+		copy(cl.Items[pos+1:], cl.Items[pos:])
+		cl.Items[pos] = item
+		save(c, card, ActivityChange{Field: "checklist.item", New: item.Text})
+	})
+
+	router.PUT("/api/cards/:id/checklists/:checklistId/items/:itemId", func(c *gin.Context) {
+		// pointers so a partial body only touches the fields it carries
+		var in struct {
+			Text       *string    `json:"text"`
+			Done       *bool      `json:"done"`
+			AssigneeID *string    `json:"assigneeId"`
+			DueDate    *time.Time `json:"dueDate"`
+			ClearDue   bool       `json:"clearDueDate"`
+		}
+		if err := c.BindJSON(&in); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		card, cl, idx, ok := loadItem(c)
+		if !ok {
+			return
+		}
+		it := &cl.Items[idx]
+		before := *it
+		if in.Text != nil && strings.TrimSpace(*in.Text) != "" {
+			it.Text = *in.Text
+		}
+		if in.Done != nil {
+			it.Done = *in.Done
+		}
+		if in.AssigneeID != nil {
+			it.AssigneeID = *in.AssigneeID
+		}
+		if in.DueDate != nil {
+			it.DueDate = in.DueDate
+		}
+		if in.ClearDue {
+			it.DueDate = nil
+		}
+		var diff []ActivityChange
+		if before.Text != it.Text {
+			diff = append(diff, ActivityChange{Field: "checklist.item.text", Old: before.Text, New: it.Text})
+		}
+		if before.Done != it.Done {
+			diff = append(diff, ActivityChange{Field: "checklist.item.done", Old: before.Done, New: it.Done})
+		}
+		if before.AssigneeID != it.AssigneeID {
+			diff = append(diff, ActivityChange{Field: "checklist.item.assigneeId", Old: before.AssigneeID, New: it.AssigneeID})
+		}
+		if !reflect.DeepEqual(before.DueDate, it.DueDate) {
+			diff = append(diff, ActivityChange{Field: "checklist.item.dueDate", Old: before.DueDate, New: it.DueDate})
+		}
+		save(c, card, diff...)
+	})
+
+	router.POST("/api/cards/:id/checklists/:checklistId/items/:itemId/move", func(c *gin.Context) {
+		var in struct {
+			Position int `json:"position"`
+		}
+		if err := c.BindJSON(&in); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		card, cl, idx, ok := loadItem(c)
+		if !ok {
+			return
+		}
+		item := cl.Items[idx]
+		cl.Items = append(cl.Items[:idx], cl.Items[idx+1:]...)
+		pos := in.Position
+		if pos < 0 {
+			pos = 0
+		}
+		if pos > len(cl.Items) {
+			pos = len(cl.Items)
+		}
+		cl.Items = append(cl.Items, ChecklistItem{})
+		copy(cl.Items[pos+1:], cl.Items[pos:])
+		cl.Items[pos] = item
+		save(c, card, ActivityChange{Field: "checklist.item.position", Old: idx, New: pos})
+	})
+
+	router.DELETE("/api/cards/:id/checklists/:checklistId/items/:itemId", func(c *gin.Context) {
+		card, cl, idx, ok := loadItem(c)
+		if !ok {
+			return
+		}
+		removed := cl.Items[idx].Text
+		cl.Items = append(cl.Items[:idx], cl.Items[idx+1:]...)
+		save(c, card, ActivityChange{Field: "checklist.item", Old: removed})
+	})
+
+	// Convert an item into a standalone card in the same column. The new card
+	// links back through ParentID and the item keeps a pointer to it, which
+	// is written first so an item is converted once. An optional body
+	// {"fields": {...}} gives the new card's custom field values, which the
+	// board's required fields need.
+	router.POST("/api/cards/:id/checklists/:checklistId/items/:itemId/convert", func(c *gin.Context) {
+		var body struct {
+			Fields map[string]interface{} `json:"fields"`
+		}
+		if err := c.ShouldBindJSON(&body); err != nil && err != io.EOF {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		parent, cl, idx, ok := loadItem(c)
+		if !ok {
+			return
+		}
+		it := &cl.Items[idx]
+		if it.CardID != "" {
+			c.JSON(http.StatusConflict, gin.H{"error": "item already converted", "cardId": it.CardID})
+			return
+		}
+		now := time.Now()
+		child := Card{
+			ID:        newID(),
+			BoardID:   parent.BoardID,
+			StatusID:  parent.StatusID,
+			ParentID:  parent.ID,
+			Title:     it.Text,
+			Color:     parent.Color,
+			DueDate:   it.DueDate,
+			Fields:    body.Fields,
+			CreatedBy: currentUserID(c),
+			CreatedAt: now,
+			UpdatedAt: now,
+		}
+		if it.AssigneeID != "" {
+			child.Assignees = []string{it.AssigneeID}
+		}
+		if err := createCardFields(context.TODO(), db, &child); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		it.CardID = child.ID
+		if !write(c, parent) {
+			return
+		}
+		if _, err := cardColl.InsertOne(context.TODO(), child); err != nil {
+			it.CardID = ""
+			_, _ = cardColl.UpdateOne(context.TODO(), bson.M{"_id": parent.ID, "version": parent.Version},
+				withVersion(bson.M{"$set": bson.M{"checklists": parent.Checklists}}))
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		changes.card(currentUserID(c), nil, &child)
+		parent.fillProgress()
+		changes.cardAction(currentUserID(c), parent, ActionChecklist,
+			ActivityChange{Field: "checklist.item.cardId", Old: it.Text, New: child.ID})
+		c.JSON(http.StatusOK, gin.H{"card": child, "parent": parent})
+	})
+}
@@ -0,0 +1,296 @@
+package kanban
+
+import (
+	"context"
+	"net/http"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	"bell-backend/models"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// Comment on a card. Body is markdown and is stored as typed; rendering is
+// up to the client. Replies point at their parent through ParentID.
+type Comment struct {
+	ID        string        `bson:"_id,omitempty" json:"_id"`
+	CardID    string        `bson:"cardId" json:"cardId"`
+	BoardID   string        `bson:"boardId" json:"boardId"`
+	ParentID  string        `bson:"parentId,omitempty" json:"parentId,omitempty"`
+	AuthorID  string        `bson:"authorId" json:"authorId"`
+	Body      string        `bson:"body" json:"body"`
+	Mentions  []string      `bson:"mentions,omitempty" json:"mentions,omitempty"` // user ids
+	Edits     []CommentEdit `bson:"edits,omitempty" json:"-"`
+	Edited    bool          `bson:"edited" json:"edited"`
+	Deleted   bool          `bson:"deleted" json:"deleted"`
+	DeletedAt *time.Time    `bson:"deletedAt,omitempty" json:"deletedAt,omitempty"`
+	CreatedAt time.Time     `bson:"createdAt" json:"createdAt"`
+	UpdatedAt time.Time     `bson:"updatedAt" json:"updatedAt"`
+	Replies   []*Comment    `bson:"-" json:"replies,omitempty"`
+}
+
+// CommentEdit keeps the body a comment had before an edit.
+type CommentEdit struct {
+	Body     string    `bson:"body" json:"body"`
+	EditedAt time.Time `bson:"editedAt" json:"editedAt"`
+}
+
+var (
+	mentionRe  = regexp.MustCompile(`(^|[^\w@])@([\w][\w.\-]*)`)
+	codeSpanRe = regexp.MustCompile("(?s)```.*?```|`[^`\n]*`")
+)
+
+// parseMentions returns the distinct lower-cased @handles of a markdown body.
+// Handles inside code spans and blocks are ignored, as are e-mail addresses.
+func parseMentions(body string) []string {
+	body = codeSpanRe.ReplaceAllString(body, " ")
+	seen := map[string]bool{}
+	var out []string
+	for _, m := range mentionRe.FindAllStringSubmatch(body, -1) {
+		handle := strings.ToLower(strings.TrimRight(m[2], ".-"))
+		if handle == "" || seen[handle] {
+			continue
+		}
+		seen[handle] = true
+		out = append(out, handle)
+	}
+	return out
+}
+
+// resolveMentions maps handles to user ids. A handle matches the local part
+// of a user's e-mail or their name with spaces removed, case-insensitively.
+func resolveMentions(ctx context.Context, usersColl *mongo.Collection, handles []string) []string {
+	if len(handles) == 0 {
+		return nil
+	}
+	wanted := map[string]bool{}
+	for _, h := range handles {
+		wanted[h] = true
+	}
+	// Names are matched with spaces stripped, which a query can't express,
+	// so the (small) user list is scanned here.
+	cur, err := usersColl.Find(ctx, bson.M{}, options.Find().SetProjection(bson.M{"name": 1, "email": 1}))
+	if err != nil {
+		return nil
+	}
+	var users []models.User
+	if err := cur.All(ctx, &users); err != nil {
+		return nil
+	}
+	var ids []string
+	for _, u := range users {
+		local := strings.ToLower(strings.SplitN(u.Email, "@", 2)[0])
+		name := strings.ToLower(strings.ReplaceAll(u.Name, " ", ""))
+		if wanted[local] || wanted[name] {
+			ids = append(ids, u.ID.Hex())
+		}
+	}
+	return ids
+}
+
+// buildCommentTree nests replies under their parents, oldest first. Replies
+// whose parent is missing are kept at the top level.
+func buildCommentTree(flat []Comment) []*Comment {
+	sort.SliceStable(flat, func(i, j int) bool { return flat[i].CreatedAt.Before(flat[j].CreatedAt) })
+	byID := make(map[string]*Comment, len(flat))
+	for i := range flat {
+		byID[flat[i].ID] = &flat[i]
+	}
+	roots := []*Comment{}
+	for i := range flat {
+		c := &flat[i]
+		if c.Deleted {
+			c.Body = ""
+		}
+		if parent, ok := byID[c.ParentID]; ok && c.ParentID != "" {
+			parent.Replies = append(parent.Replies, c)
+			continue
+		}
+		roots = append(roots, c)
+	}
+	return roots
+}
+
+func registerCommentRoutes(router *gin.Engine, db *mongo.Database) {
+	cardColl := db.Collection("cards")
+	commentColl := db.Collection("comments")
+	notifColl := db.Collection("notifications")
+	usersColl := db.Collection("users")
+
+	// mentionNotify notifies everyone cm mentions who is not in skip. Authors
+	// mentioning themselves aren't told. Call it once cm is stored.
+	mentionNotify := func(ctx context.Context, cm Comment, skip []string) {
+		already := map[string]bool{cm.AuthorID: true}
+		for _, id := range skip {
+			already[id] = true
+		}
+		var fresh []string
+		for _, id := range cm.Mentions {
+			if !already[id] {
+				fresh = append(fresh, id)
+			}
+		}
+		_ = notify(ctx, notifColl, fresh, Notification{
+			Type:      "mention",
+			BoardID:   cm.BoardID,
+			CardID:    cm.CardID,
+			CommentID: cm.ID,
+			ActorID:   cm.AuthorID,
+			Text:      cm.Body,
+		})
+	}
+
+	router.GET("/api/cards/:id/comments", func(c *gin.Context) {
+		cur, err := commentColl.Find(context.TODO(), bson.M{"cardId": c.Param("id")})
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		var flat []Comment
+		if err := cur.All(context.TODO(), &flat); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(http.StatusOK, buildCommentTree(flat))
+	})
+
+	router.POST("/api/cards/:id/comments", requireUser(), func(c *gin.Context) {
+		var in struct {
+			Body     string `json:"body"`
+			ParentID string `json:"parentId"`
+		}
+		if err := c.BindJSON(&in); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		if strings.TrimSpace(in.Body) == "" {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "body is required"})
+			return
+		}
+		var card Card
+		if err := cardColl.FindOne(context.TODO(), bson.M{"_id": c.Param("id")}).Decode(&card); err != nil {
+			c.JSON(http.StatusNotFound, gin.H{"error": "card not found"})
+			return
+		}
+		if in.ParentID != "" {
+			cnt, err := commentColl.CountDocuments(context.TODO(), bson.M{"_id": in.ParentID, "cardId": card.ID})
+			if err != nil || cnt == 0 {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "parent comment not found on this card"})
+				return
+			}
+		}
+		now := time.Now()
+		cm := Comment{
+			ID:        newID(),
+			CardID:    card.ID,
+			BoardID:   card.BoardID,
+			ParentID:  in.ParentID,
+			AuthorID:  currentUserID(c),
+			Body:      in.Body,
+			CreatedAt: now,
+			UpdatedAt: now,
+		}
+		cm.Mentions = resolveMentions(context.TODO(), usersColl, parseMentions(cm.Body))
+		if _, err := commentColl.InsertOne(context.TODO(), cm); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		mentionNotify(context.TODO(), cm, nil)
+		c.JSON(http.StatusOK, cm)
+	})
+
+	router.PUT("/api/comments/:id", requireUser(), func(c *gin.Context) {
+		var in struct {
+			Body string `json:"body"`
+		}
+		if err := c.BindJSON(&in); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		if strings.TrimSpace(in.Body) == "" {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "body is required"})
+			return
+		}
+		var cm Comment
+		if err := commentColl.FindOne(context.TODO(), bson.M{"_id": c.Param("id")}).Decode(&cm); err != nil {
+			c.JSON(http.StatusNotFound, gin.H{"error": "not found"})
+			return
+		}
+		if cm.AuthorID != currentUserID(c) {
+			c.JSON(http.StatusForbidden, gin.H{"error": "only the author can edit a comment"})
+			return
+		}
+		if cm.Deleted {
+			c.JSON(http.StatusConflict, gin.H{"error": "comment is deleted"})
+			return
+		}
+		if cm.Body == in.Body {
+			c.JSON(http.StatusOK, cm)
+			return
+		}
+		now := time.Now()
+		prev := CommentEdit{Body: cm.Body, EditedAt: now}
+		notified := cm.Mentions
+		cm.Body = in.Body
+		cm.Mentions = resolveMentions(context.TODO(), usersColl, parseMentions(cm.Body))
+		cm.Edited = true
+		cm.UpdatedAt = now
+		_, err := commentColl.UpdateOne(context.TODO(), bson.M{"_id": cm.ID}, bson.M{
+			"$set": bson.M{
+				"body":      cm.Body,
+				"mentions":  cm.Mentions,
+				"edited":    true,
+				"updatedAt": now,
+			},
+			"$push": bson.M{"edits": prev},
+		})
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		mentionNotify(context.TODO(), cm, notified)
+		c.JSON(http.StatusOK, cm)
+	})
+
+	router.GET("/api/comments/:id/history", func(c *gin.Context) {
+		var cm Comment
+		if err := commentColl.FindOne(context.TODO(), bson.M{"_id": c.Param("id")}).Decode(&cm); err != nil {
+			c.JSON(http.StatusNotFound, gin.H{"error": "not found"})
+			return
+		}
+		if cm.Deleted {
+			c.JSON(http.StatusOK, []CommentEdit{})
+			return
+		}
+		history := append([]CommentEdit{}, cm.Edits...)
+		c.JSON(http.StatusOK, history)
+	})
+
+	// Soft delete: the document stays so replies keep their thread.
+	router.DELETE("/api/comments/:id", requireUser(), func(c *gin.Context) {
+		var cm Comment
+		if err := commentColl.FindOne(context.TODO(), bson.M{"_id": c.Param("id")}).Decode(&cm); err != nil {
+			c.JSON(http.StatusNotFound, gin.H{"error": "not found"})
+			return
+		}
+		if cm.AuthorID != currentUserID(c) {
+			c.JSON(http.StatusForbidden, gin.H{"error": "only the author can delete a comment"})
+			return
+		}
+		now := time.Now()
+		_, err := commentColl.UpdateOne(context.TODO(), bson.M{"_id": cm.ID}, bson.M{
+			"$set": bson.M{"deleted": true, "deletedAt": now, "updatedAt": now},
+		})
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{"status": "deleted"})
+	})
+}
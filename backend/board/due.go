@@ -0,0 +1,265 @@
+package kanban
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// maxReminder caps reminder offsets at 30 days before the due date.
+const maxReminder = 30 * 24 * 60
+
+// parseDate accepts RFC3339 timestamps and plain YYYY-MM-DD dates (UTC midnight).
+func parseDate(s string) (time.Time, error) {
+	s = strings.TrimSpace(s)
+	if t, err := time.Parse(time.RFC3339, s); err == nil {
+		return t, nil
+	}
+	if t, err := time.Parse("2006-01-02", s); err == nil {
+		return t, nil
+	}
+	return time.Time{}, fmt.Errorf("invalid date %q: use RFC3339 or YYYY-MM-DD", s)
+}
+
+// clearable lists optional card fields a PUT can remove by sending them
+// empty ("", null or []).
+var clearable = []string{"image", "tags", "labels", "priority", "laneId", "dueDate", "startDate", "reminders", "assignees", "sprintId", "points", "fields"}
+
+// UnmarshalJSON accepts dates as RFC3339 or YYYY-MM-DD, with "" meaning
+// no date (what the UI sends for an empty date picker), and remembers which
+// clearable fields the body set to empty.
+func (card *Card) UnmarshalJSON(data []byte) error {
+	type plain Card
+	aux := struct {
+		*plain
+		DueDate   *string `json:"dueDate"`
+		StartDate *string `json:"startDate"`
+	}{plain: (*plain)(card)}
+	if err := json.Unmarshal(data, &aux); err != nil {
+		return err
+	}
+	var err error
+	if card.DueDate, err = optionalDate(aux.DueDate); err != nil {
+		return err
+	}
+	if card.StartDate, err = optionalDate(aux.StartDate); err != nil {
+		return err
+	}
+	for _, r := range card.Reminders {
+		if r < 0 || r > maxReminder {
+			return fmt.Errorf("reminder offsets are minutes before the due date, 0..%d", maxReminder)
+		}
+	}
+
+	var raw map[string]json.RawMessage
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return err
+	}
+	_, card.versionSent = raw["version"]
+	card.cleared = nil
+	for _, key := range clearable {
+		v, ok := raw[key]
+		if !ok {
+			continue
+		}
+		switch strings.TrimSpace(string(v)) {
+		case `""`, "null", "[]":
+			card.cleared = append(card.cleared, key)
+		}
+	}
+	return nil
+}
+
+func optionalDate(s *string) (*time.Time, error) {
+	if s == nil || strings.TrimSpace(*s) == "" {
+		return nil, nil
+	}
+	t, err := parseDate(*s)
+	if err != nil {
+		return nil, err
+	}
+	return &t, nil
+}
+
+// migrateDueDates converts dueDate strings written by older versions into
+// real dates; empty or unparsable values are dropped.
+func migrateDueDates(db *mongo.Database) {
+	pipeline := mongo.Pipeline{{{Key: "$set", Value: bson.M{
+		"dueDate": bson.M{"$dateFromString": bson.M{
+			"dateString": "$dueDate",
+			"onError":    "$$REMOVE",
+			"onNull":     "$$REMOVE",
+		}},
+	}}}}
+	res, err := db.Collection("cards").UpdateMany(context.TODO(), bson.M{"dueDate": bson.M{"$type": "string"}}, pipeline)
+	if err != nil {
+		log.Println("kanban: dueDate migration:", err)
+		return
+	}
+	if res.ModifiedCount > 0 {
+		log.Println("kanban: migrated dueDate on cards:", res.ModifiedCount)
+	}
+}
+
+// doneStatusIDs are the ids of boardID's done-category columns, or of every
+// board's when boardID is "". Cards in them aren't overdue.
+func doneStatusIDs(ctx context.Context, db *mongo.Database, boardID string) ([]string, error) {
+	filter := bson.M{"category": CategoryDone}
+	if boardID != "" {
+		filter["boardId"] = boardID
+	}
+	cur, err := db.Collection("statuses").Find(ctx, filter, options.Find().SetProjection(bson.M{"_id": 1}))
+	if err != nil {
+		return nil, err
+	}
+	var statuses []Status
+	if err := cur.All(ctx, &statuses); err != nil {
+		return nil, err
+	}
+	ids := make([]string, len(statuses))
+	for i, st := range statuses {
+		ids[i] = st.ID
+	}
+	return ids, nil
+}
+
+// applyDueFilters adds ?dueBefore=, ?dueAfter= and ?overdue=true to a card
+// filter; overdue leaves out cards that are done.
+func applyDueFilters(c *gin.Context, db *mongo.Database, filter bson.M) error {
+	due := bson.M{}
+	if v := c.Query("dueBefore"); v != "" {
+		t, err := parseDate(v)
+		if err != nil {
+			return err
+		}
+		due["$lt"] = t
+	}
+	if v := c.Query("dueAfter"); v != "" {
+		t, err := parseDate(v)
+		if err != nil {
+			return err
+		}
+		due["$gte"] = t
+	}
+	if c.Query("overdue") == "true" {
+		now := time.Now()
+		if before, ok := due["$lt"].(time.Time); !ok || now.Before(before) {
+			due["$lt"] = now
+		}
+		boardID, _ := filter["boardId"].(string)
+		done, err := doneStatusIDs(context.TODO(), db, boardID)
+		if err != nil {
+			return err
+		}
+		filter["statusId"] = bson.M{"$nin": done}
+	}
+	if len(due) > 0 {
+		filter["dueDate"] = due
+	}
+	return nil
+}
+
+// reminderRecipients are the card's assignees, or its creator when unassigned.
+func reminderRecipients(card *Card) []string {
+	if len(card.Assignees) > 0 {
+		return card.Assignees
+	}
+	if card.CreatedBy != "" {
+		return []string{card.CreatedBy}
+	}
+	return nil
+}
+
+// sendDueReminders emits "due_soon" notifications for reminder offsets that
+// have come up and "overdue" ones for cards past their due date, leaving
+// out cards that are done. Each
+// notification is claimed with a conditional update first, so running this
+// on several replicas (or again after a restart) sends it only once.
+func sendDueReminders(db *mongo.Database, now time.Time) {
+	ctx := context.TODO()
+	cardColl := db.Collection("cards")
+	notifColl := db.Collection("notifications")
+	done, err := doneStatusIDs(ctx, db, "")
+	if err != nil {
+		log.Println("kanban: due reminders:", err)
+		return
+	}
+	live := bson.M{"deletedAt": nil, "archivedAt": nil, "statusId": bson.M{"$nin": done}}
+
+	soon := bson.M{
+		"reminders.0": bson.M{"$exists": true},
+		"dueDate":     bson.M{"$gt": now, "$lte": now.Add(maxReminder * time.Minute)},
+	}
+	for k, v := range live {
+		soon[k] = v
+	}
+	var cards []Card
+	if cur, err := cardColl.Find(ctx, soon); err == nil {
+		_ = cur.All(ctx, &cards)
+	}
+	for i := range cards {
+		card := &cards[i]
+		for _, offset := range card.Reminders {
+			if card.DueDate.Add(-time.Duration(offset) * time.Minute).After(now) {
+				continue
+			}
+			res, err := cardColl.UpdateOne(ctx,
+				bson.M{"_id": card.ID, "remindersSent": bson.M{"$ne": offset}},
+				bson.M{"$addToSet": bson.M{"remindersSent": offset}})
+			if err != nil || res.ModifiedCount == 0 {
+				continue
+			}
+			_ = notify(ctx, notifColl, reminderRecipients(card), Notification{
+				Type:    "due_soon",
+				BoardID: card.BoardID,
+				CardID:  card.ID,
+				Text:    fmt.Sprintf("%s is due %s", card.Title, card.DueDate.Format(time.RFC3339)),
+			})
+		}
+	}
+
+	overdue := bson.M{"dueDate": bson.M{"$lte": now}, "overdueNotified": bson.M{"$ne": true}}
+	for k, v := range live {
+		overdue[k] = v
+	}
+	cards = nil
+	if cur, err := cardColl.Find(ctx, overdue); err == nil {
+		_ = cur.All(ctx, &cards)
+	}
+	for i := range cards {
+		card := &cards[i]
+		res, err := cardColl.UpdateOne(ctx,
+			bson.M{"_id": card.ID, "overdueNotified": bson.M{"$ne": true}},
+			bson.M{"$set": bson.M{"overdueNotified": true}})
+		if err != nil || res.ModifiedCount == 0 {
+			continue
+		}
+		_ = notify(ctx, notifColl, reminderRecipients(card), Notification{
+			Type:    "overdue",
+			BoardID: card.BoardID,
+			CardID:  card.ID,
+			Text:    fmt.Sprintf("%s is overdue", card.Title),
+		})
+	}
+}
+
+// startDueScheduler migrates old due dates and checks reminders every minute.
+func startDueScheduler(db *mongo.Database) {
+	migrateDueDates(db)
+	go func() {
+		ticker := time.NewTicker(time.Minute)
+		defer ticker.Stop()
+		for {
+			<-ticker.C
+			sendDueReminders(db, time.Now())
+		}
+	}()
+}
@@ -0,0 +1,352 @@
+package kanban
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"bell-backend/utils"
+	"bell-backend/webhooks"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// Board event types pushed to live subscribers.
+const (
+	EventBoardCreated  = "board.created"
+	EventBoardUpdated  = "board.updated"
+	EventBoardDeleted  = "board.deleted"
+	EventStatusCreated = "status.created"
+	EventStatusUpdated = "status.updated"
+	EventStatusDeleted = "status.deleted"
+	EventCardCreated   = "card.created"
+	EventCardUpdated   = "card.updated"
+	EventCardMoved     = "card.moved"
+	EventCardDeleted   = "card.deleted"
+
+	// Archiving hides an entity from default lists; restoring brings it
+	// back from the trash.
+	EventBoardArchived    = "board.archived"
+	EventBoardUnarchived  = "board.unarchived"
+	EventBoardRestored    = "board.restored"
+	EventStatusArchived   = "status.archived"
+	EventStatusUnarchived = "status.unarchived"
+	EventStatusRestored   = "status.restored"
+	EventCardArchived     = "card.archived"
+	EventCardUnarchived   = "card.unarchived"
+	EventCardRestored     = "card.restored"
+)
+
+// BoardEvent is one change on a board. Payload is the JSON of the affected
+// document (or {"_id": ...} for deletes). Events are kept for a day in
+// board_events so reconnecting clients can resume from their last id.
+type BoardEvent struct {
+	ID        string          `bson:"_id" json:"id"`
+	BoardID   string          `bson:"boardId" json:"boardId"`
+	Type      string          `bson:"type" json:"type"`
+	ActorID   string          `bson:"actorId,omitempty" json:"actorId,omitempty"`
+	Payload   json.RawMessage `bson:"payload" json:"payload"`
+	CreatedAt time.Time       `bson:"createdAt" json:"createdAt"`
+}
+
+// Broker fans events out to the subscribers of a board. Implementations
+// decide how events reach other backend instances.
+type Broker interface {
+	// Publish is called once for every event stored by this instance.
+	Publish(ev BoardEvent)
+	// Subscribe returns a channel of events for boardID. The channel is
+	// closed when the subscriber falls too far behind or cancel is called.
+	Subscribe(boardID string) (events <-chan BoardEvent, cancel func())
+}
+
+// memoryBroker delivers events inside one process only.
+type memoryBroker struct {
+	mu   sync.Mutex
+	subs map[string]map[chan BoardEvent]struct{}
+}
+
+func newMemoryBroker() *memoryBroker {
+	return &memoryBroker{subs: map[string]map[chan BoardEvent]struct{}{}}
+}
+
+func (b *memoryBroker) Publish(ev BoardEvent) { b.deliver(ev) }
+
+func (b *memoryBroker) deliver(ev BoardEvent) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	for ch := range b.subs[ev.BoardID] {
+		select {
+		case ch <- ev:
+		default:
+			// Too slow: drop the subscriber, it will reconnect and resume
+			// from its last event id.
+			delete(b.subs[ev.BoardID], ch)
+			close(ch)
+		}
+	}
+}
+
+func (b *memoryBroker) Subscribe(boardID string) (<-chan BoardEvent, func()) {
+	ch := make(chan BoardEvent, 64)
+	b.mu.Lock()
+	if b.subs[boardID] == nil {
+		b.subs[boardID] = map[chan BoardEvent]struct{}{}
+	}
+	b.subs[boardID][ch] = struct{}{}
+	b.mu.Unlock()
+	cancel := func() {
+		b.mu.Lock()
+		defer b.mu.Unlock()
+		if _, ok := b.subs[boardID][ch]; ok {
+			delete(b.subs[boardID], ch)
+			close(ch)
+		}
+		if len(b.subs[boardID]) == 0 {
+			delete(b.subs, boardID)
+		}
+	}
+	return ch, cancel
+}
+
+// mongoBroker tails inserts into board_events with a change stream, so an
+// event stored by any instance reaches subscribers on every instance.
+// Change streams need a replica set. A stream that fails is reopened where
+// it left off.
+type mongoBroker struct {
+	*memoryBroker
+	coll *mongo.Collection
+}
+
+var brokerPipeline = mongo.Pipeline{{{Key: "$match", Value: bson.M{"operationType": "insert"}}}}
+
+func newMongoBroker(coll *mongo.Collection) (*mongoBroker, error) {
+	stream, err := coll.Watch(context.Background(), brokerPipeline)
+	if err != nil {
+		return nil, err
+	}
+	b := &mongoBroker{memoryBroker: newMemoryBroker(), coll: coll}
+	go b.tail(stream)
+	return b, nil
+}
+
+// tail delivers what stream brings and, when it stops, reopens it after the
+// last event seen, backing off while that keeps failing. If the resume
+// point has left the oplog it starts over from now.
+func (b *mongoBroker) tail(stream *mongo.ChangeStream) {
+	delay := time.Second
+	for {
+		for stream.Next(context.Background()) {
+			delay = time.Second
+			var change struct {
+				FullDocument BoardEvent `bson:"fullDocument"`
+			}
+			if err := stream.Decode(&change); err != nil {
+				continue
+			}
+			b.deliver(change.FullDocument)
+		}
+		log.Println("kanban: board event change stream stopped, reopening:", stream.Err())
+		resume := stream.ResumeToken()
+		stream.Close(context.Background())
+
+		for {
+			time.Sleep(delay)
+			if delay < time.Minute {
+				delay *= 2
+			}
+			var err error
+			if resume != nil {
+				stream, err = b.coll.Watch(context.Background(), brokerPipeline, options.ChangeStream().SetResumeAfter(resume))
+				if err == nil {
+					break
+				}
+				var serr mongo.ServerError
+				if !errors.As(err, &serr) || !(serr.HasErrorCode(286) || serr.HasErrorCode(280)) {
+					log.Println("kanban: reopening board event change stream:", err)
+					continue
+				}
+				// ChangeStreamHistoryLost / ChangeStreamFatalError
+				log.Println("kanban: cannot resume board event change stream:", err)
+				resume = nil
+			}
+			stream, err = b.coll.Watch(context.Background(), brokerPipeline)
+			if err == nil {
+				break
+			}
+			log.Println("kanban: reopening board event change stream:", err)
+		}
+	}
+}
+
+// Publish is a no-op: the insert itself comes back through the change stream.
+func (b *mongoBroker) Publish(BoardEvent) {}
+
+// eventHub stores board events and hands them to the broker and to the
+// outgoing webhooks.
+type eventHub struct {
+	coll   *mongo.Collection
+	broker Broker
+	hooks  *webhooks.Dispatcher
+}
+
+// newEventHub picks the broker from EVENT_BROKER ("mongo" or "memory",
+// default memory). If change streams are unavailable it falls back to memory.
+func newEventHub(db *mongo.Database) *eventHub {
+	coll := db.Collection("board_events")
+	_, _ = coll.Indexes().CreateMany(context.TODO(), []mongo.IndexModel{
+		{Keys: bson.D{{Key: "boardId", Value: 1}, {Key: "_id", Value: 1}}},
+		{Keys: bson.D{{Key: "createdAt", Value: 1}}, Options: options.Index().SetExpireAfterSeconds(24 * 60 * 60)},
+	})
+	h := &eventHub{coll: coll, broker: newMemoryBroker()}
+	if strings.EqualFold(os.Getenv("EVENT_BROKER"), "mongo") {
+		b, err := newMongoBroker(coll)
+		if err != nil {
+			log.Println("kanban: change streams unavailable, using in-process events:", err)
+		} else {
+			h.broker = b
+		}
+	}
+	return h
+}
+
+// emit records an event for boardID. Errors are logged, never returned:
+// a failed push must not fail the request that caused it.
+func (h *eventHub) emit(boardID, eventType, actorID string, payload interface{}) {
+	if h == nil || boardID == "" {
+		return
+	}
+	data, err := json.Marshal(payload)
+	if err != nil {
+		log.Println("kanban: event encode:", err)
+		return
+	}
+	ev := BoardEvent{
+		ID:        newID(),
+		BoardID:   boardID,
+		Type:      eventType,
+		ActorID:   actorID,
+		Payload:   data,
+		CreatedAt: time.Now(),
+	}
+	if _, err := h.coll.InsertOne(context.TODO(), ev); err != nil {
+		log.Println("kanban: event store:", err)
+	}
+	h.broker.Publish(ev)
+	h.hooks.Publish(eventType, boardID, actorID, ev.Payload)
+}
+
+// emitCard emits the card event matching an activity entry.
+func (h *eventHub) emitCard(actorID string, before, after *Card) {
+	switch {
+	case before == nil && after != nil:
+		h.emit(after.BoardID, EventCardCreated, actorID, after)
+	case after == nil && before != nil:
+		h.emit(before.BoardID, EventCardDeleted, actorID, gin.H{"_id": before.ID})
+	case before != nil:
+		if before.BoardID != after.BoardID {
+			// moved across boards: gone from one, new on the other
+			h.emit(before.BoardID, EventCardDeleted, actorID, gin.H{"_id": before.ID})
+			h.emit(after.BoardID, EventCardCreated, actorID, after)
+			return
+		}
+		eventType := EventCardUpdated
+		if before.StatusID != after.StatusID {
+			eventType = EventCardMoved
+		}
+		h.emit(after.BoardID, eventType, actorID, after)
+	}
+}
+
+// since returns stored events of a board newer than lastID, oldest first.
+func (h *eventHub) since(ctx context.Context, boardID, lastID string) ([]BoardEvent, error) {
+	opts := options.Find().SetSort(bson.D{{Key: "_id", Value: 1}}).SetLimit(1000)
+	cur, err := h.coll.Find(ctx, bson.M{"boardId": boardID, "_id": bson.M{"$gt": lastID}}, opts)
+	if err != nil {
+		return nil, err
+	}
+	var out []BoardEvent
+	err = cur.All(ctx, &out)
+	return out, err
+}
+
+func writeSSE(c *gin.Context, ev BoardEvent) {
+	data, _ := json.Marshal(ev)
+	fmt.Fprintf(c.Writer, "id: %s\nevent: %s\ndata: %s\n\n", ev.ID, ev.Type, data)
+}
+
+func registerEventRoutes(router *gin.Engine, hub *eventHub) {
+	// GET /api/boards/:id/events — Server-Sent Events stream.
+	// EventSource can't send headers, so the token may also come as ?token=.
+	// Resume with the Last-Event-ID header (sent by browsers on reconnect)
+	// or ?lastEventId=.
+	router.GET("/api/boards/:id/events", func(c *gin.Context) {
+		tokenStr := strings.TrimPrefix(c.GetHeader("Authorization"), "Bearer ")
+		if tokenStr == "" {
+			tokenStr = c.Query("token")
+		}
+		if _, err := utils.ParseJWT(tokenStr); tokenStr == "" || err != nil {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "authorization required"})
+			return
+		}
+		boardID := c.Param("id")
+		lastID := c.GetHeader("Last-Event-ID")
+		if lastID == "" {
+			lastID = c.Query("lastEventId")
+		}
+
+		// Subscribe before replaying so nothing falls in between.
+		events, cancel := hub.broker.Subscribe(boardID)
+		defer cancel()
+
+		c.Header("Content-Type", "text/event-stream")
+		c.Header("Cache-Control", "no-cache")
+		c.Header("Connection", "keep-alive")
+		c.Header("X-Accel-Buffering", "no")
+		c.Status(http.StatusOK)
+
+		replayed := map[string]bool{}
+		if lastID != "" {
+			missed, err := hub.since(c.Request.Context(), boardID, lastID)
+			if err != nil {
+				return
+			}
+			for _, ev := range missed {
+				writeSSE(c, ev)
+				replayed[ev.ID] = true
+			}
+		}
+		fmt.Fprint(c.Writer, "retry: 3000\n\n")
+		c.Writer.Flush()
+
+		ping := time.NewTicker(25 * time.Second)
+		defer ping.Stop()
+		for {
+			select {
+			case <-c.Request.Context().Done():
+				return
+			case <-ping.C:
+				fmt.Fprint(c.Writer, ": ping\n\n")
+				c.Writer.Flush()
+			case ev, ok := <-events:
+				if !ok {
+					return
+				}
+				if replayed[ev.ID] {
+					continue
+				}
+				writeSSE(c, ev)
+				c.Writer.Flush()
+			}
+		}
+	})
+}
@@ -0,0 +1,478 @@
+package kanban
+
+import (
+	"archive/zip"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// exportVersion is the version of the board export format. Importers accept
+// documents up to this version.
+const exportVersion = 1
+
+// exportDoc is the name of the JSON document inside an export ZIP; the
+// attachment files sit next to it under files/.
+const exportDoc = "board.json"
+
+// Limits on what an import may bring.
+const (
+	maxImportFiles    = 1000
+	maxImportFileSize = 100 << 20 // each file and the document itself
+	maxImportSize     = 1 << 30   // everything in the archive, unpacked
+)
+
+// BoardExport is a self-contained copy of a board, with its cards' comments,
+// that can be imported into another environment.
+type BoardExport struct {
+	Version    int       `json:"version"`
+	ExportedAt time.Time `json:"exportedAt"`
+	boardSnapshot
+}
+
+// ImportIssue is something the importer had to drop or change.
+type ImportIssue struct {
+	Kind    string `json:"kind"` // "status", "lane", "card", "link", "attachment", "user", "board"
+	ID      string `json:"id,omitempty"`
+	Message string `json:"message"`
+}
+
+// validate checks the structure of an export and returns the problems that
+// make it unusable. References that merely point nowhere are not errors;
+// conflicts reports those.
+func (doc *BoardExport) validate() []string {
+	var problems []string
+	if doc.Version < 1 || doc.Version > exportVersion {
+		problems = append(problems, fmt.Sprintf("unsupported export version %d (this server reads 1..%d)", doc.Version, exportVersion))
+	}
+	if strings.TrimSpace(doc.Board.Name) == "" {
+		problems = append(problems, "board name is missing")
+	}
+	seen := map[string]bool{}
+	unique := func(kind, id string) {
+		if id == "" {
+			problems = append(problems, kind+" without _id")
+			return
+		}
+		if seen[id] {
+			problems = append(problems, fmt.Sprintf("duplicate id %s (%s)", id, kind))
+		}
+		seen[id] = true
+	}
+	unique("board", doc.Board.ID)
+	for _, l := range doc.Board.Lanes {
+		unique("lane", l.ID)
+	}
+	for _, st := range doc.Statuses {
+		unique("status", st.ID)
+		if st.Category != "" && !validCategory(st.Category) {
+			problems = append(problems, fmt.Sprintf("status %s has unknown category %q", st.ID, st.Category))
+		}
+	}
+	for _, l := range doc.Labels {
+		unique("label", l.ID)
+	}
+	if err := checkFields(append([]CustomField(nil), doc.Board.Fields...)); err != nil {
+		problems = append(problems, err.Error())
+	}
+	for _, f := range doc.Board.Fields {
+		unique("field", f.ID)
+	}
+	for _, card := range doc.Cards {
+		unique("card", card.ID)
+	}
+	for _, l := range doc.Links {
+		if _, ok := reverseLink[l.Type]; !ok {
+			problems = append(problems, fmt.Sprintf("link %s has unknown type %q", l.ID, l.Type))
+		}
+	}
+	return problems
+}
+
+// conflicts drops references the document cannot satisfy here — cards in
+// missing columns or lanes, links to cards that are not in the document,
+// users unknown to this environment — and reports each one.
+func (doc *BoardExport) conflicts(ctx context.Context, usersColl *mongo.Collection) []ImportIssue {
+	issues := []ImportIssue{}
+	statuses, lanes, cards := map[string]bool{}, map[string]bool{}, map[string]bool{}
+	for _, st := range doc.Statuses {
+		statuses[st.ID] = true
+	}
+	for _, l := range doc.Board.Lanes {
+		lanes[l.ID] = true
+	}
+	for _, card := range doc.Cards {
+		cards[card.ID] = true
+	}
+
+	users := map[string]bool{}
+	var userIDs []primitive.ObjectID
+	userFields := map[string]bool{}
+	for _, f := range doc.Board.Fields {
+		if f.Type == FieldUser {
+			userFields[f.ID] = true
+		}
+	}
+	for _, card := range doc.Cards {
+		for _, id := range card.Assignees {
+			if oid, err := primitive.ObjectIDFromHex(id); err == nil {
+				userIDs = append(userIDs, oid)
+			}
+		}
+		for id, v := range card.Fields {
+			if s, ok := v.(string); ok && userFields[id] {
+				if oid, err := primitive.ObjectIDFromHex(s); err == nil {
+					userIDs = append(userIDs, oid)
+				}
+			}
+		}
+	}
+	for _, cm := range doc.Comments {
+		if oid, err := primitive.ObjectIDFromHex(cm.AuthorID); err == nil {
+			userIDs = append(userIDs, oid)
+		}
+	}
+	if len(userIDs) > 0 {
+		var found []struct {
+			ID primitive.ObjectID `bson:"_id"`
+		}
+		if cur, err := usersColl.Find(ctx, bson.M{"_id": bson.M{"$in": userIDs}}); err == nil {
+			_ = cur.All(ctx, &found)
+		}
+		for _, u := range found {
+			users[u.ID.Hex()] = true
+		}
+	}
+
+	for i := range doc.Cards {
+		card := &doc.Cards[i]
+		if card.StatusID != "" && !statuses[card.StatusID] {
+			issues = append(issues, ImportIssue{"status", card.StatusID, fmt.Sprintf("card %q points at a column that is not in the export; it is imported without one", card.Title)})
+		}
+		if card.LaneID != "" && !lanes[card.LaneID] {
+			issues = append(issues, ImportIssue{"lane", card.LaneID, fmt.Sprintf("card %q points at a lane that is not in the export", card.Title)})
+			card.LaneID = ""
+		}
+		if card.ParentID != "" && !cards[card.ParentID] {
+			issues = append(issues, ImportIssue{"card", card.ParentID, fmt.Sprintf("parent of card %q is not in the export", card.Title)})
+		}
+		var assignees []string
+		for _, id := range card.Assignees {
+			if users[id] {
+				assignees = append(assignees, id)
+				continue
+			}
+			issues = append(issues, ImportIssue{"user", id, fmt.Sprintf("assignee of card %q does not exist here and was removed", card.Title)})
+		}
+		card.Assignees = assignees
+		for id, v := range card.Fields {
+			if s, ok := v.(string); ok && userFields[id] && !users[s] {
+				issues = append(issues, ImportIssue{"user", s, fmt.Sprintf("user in a custom field of card %q does not exist here and was removed", card.Title)})
+				delete(card.Fields, id)
+			}
+		}
+	}
+	titles := map[string]string{}
+	for _, card := range doc.Cards {
+		titles[card.ID] = card.Title
+	}
+	for _, cm := range doc.Comments {
+		if cm.AuthorID != "" && !users[cm.AuthorID] && cards[cm.CardID] {
+			// kept: the text matters more than who wrote it
+			issues = append(issues, ImportIssue{"user", cm.AuthorID, fmt.Sprintf("author of a comment on card %q does not exist here", titles[cm.CardID])})
+		}
+	}
+	var links []CardLink
+	for _, l := range doc.Links {
+		if !cards[l.FromID] || !cards[l.ToID] {
+			issues = append(issues, ImportIssue{"link", l.ID, "link to a card outside the export was dropped"})
+			continue
+		}
+		links = append(links, l)
+	}
+	doc.Links = links
+	return issues
+}
+
+// readImport reads an export from the request: a JSON body, or a multipart
+// "file" holding either the JSON or a ZIP made by the exporter. For ZIPs the
+// attachment files are returned by their name under files/.
+func readImport(c *gin.Context) (*BoardExport, map[string]*zip.File, error) {
+	var doc BoardExport
+	file, err := c.FormFile("file")
+	if err != nil {
+		body := http.MaxBytesReader(c.Writer, c.Request.Body, maxImportFileSize)
+		if err := json.NewDecoder(body).Decode(&doc); err != nil {
+			return nil, nil, fmt.Errorf("invalid export document: %w", err)
+		}
+		return &doc, nil, nil
+	}
+	if file.Size > maxImportSize {
+		return nil, nil, fmt.Errorf("archive is larger than %d MB", maxImportSize>>20)
+	}
+	f, err := file.Open()
+	if err != nil {
+		return nil, nil, err
+	}
+	defer f.Close()
+	zr, err := zip.NewReader(f, file.Size)
+	if err != nil {
+		// not a ZIP: the file is the JSON itself
+		if _, err := f.Seek(0, io.SeekStart); err != nil {
+			return nil, nil, err
+		}
+		if err := json.NewDecoder(io.LimitReader(f, maxImportFileSize)).Decode(&doc); err != nil {
+			return nil, nil, fmt.Errorf("invalid export document: %w", err)
+		}
+		return &doc, nil, nil
+	}
+	if len(zr.File) > maxImportFiles+1 {
+		return nil, nil, fmt.Errorf("archive holds more than %d files", maxImportFiles)
+	}
+	files := map[string]*zip.File{}
+	var docFile *zip.File
+	var total uint64
+	for _, zf := range zr.File {
+		// the sizes are what the archive claims; extractTo holds them to it
+		if zf.UncompressedSize64 > maxImportFileSize {
+			return nil, nil, fmt.Errorf("%s is larger than %d MB", zf.Name, maxImportFileSize>>20)
+		}
+		if total += zf.UncompressedSize64; total > maxImportSize {
+			return nil, nil, fmt.Errorf("archive unpacks to more than %d MB", maxImportSize>>20)
+		}
+		switch {
+		case zf.Name == exportDoc:
+			docFile = zf
+		case strings.HasPrefix(zf.Name, "files/"):
+			files[path.Base(zf.Name)] = zf
+		}
+	}
+	if docFile == nil {
+		return nil, nil, fmt.Errorf("%s missing from archive", exportDoc)
+	}
+	rc, err := docFile.Open()
+	if err != nil {
+		return nil, nil, err
+	}
+	defer rc.Close()
+	if err := json.NewDecoder(io.LimitReader(rc, maxImportFileSize)).Decode(&doc); err != nil {
+		return nil, nil, fmt.Errorf("invalid export document: %w", err)
+	}
+	return &doc, files, nil
+}
+
+// restoreFiles stores the archive's file of every attachment in uploadDir
+// under a fresh name. Files already on this server are never reused, so an
+// import can't reach into other boards' uploads. Attachments whose file is
+// not in the archive are dropped and reported; card covers follow the new
+// urls, or are cleared with them.
+func (doc *BoardExport) restoreFiles(files map[string]*zip.File) ([]ImportIssue, error) {
+	var issues []ImportIssue
+	urls := map[string]string{}
+	var kept []Attachment
+	for _, att := range doc.Attachments {
+		zf, ok := files[path.Base(att.URL)]
+		if !ok {
+			issues = append(issues, ImportIssue{"attachment", att.ID, fmt.Sprintf("file %q is not in the archive and was dropped", att.Name)})
+			continue
+		}
+		if _, done := urls[att.URL]; !done {
+			name := fmt.Sprintf("%d_%s", time.Now().UnixNano(), filepath.Base(att.Name))
+			if err := extractTo(zf, filepath.Join(uploadDir, name)); err != nil {
+				return issues, err
+			}
+			urls[att.URL] = "/uploads/" + name
+		}
+		att.URL = urls[att.URL]
+		kept = append(kept, att)
+	}
+	doc.Attachments = kept
+	for i := range doc.Cards {
+		card := &doc.Cards[i]
+		if url, ok := urls[card.Image]; ok {
+			card.Image = url
+		} else if strings.HasPrefix(card.Image, "/uploads/") {
+			card.Image = ""
+		}
+	}
+	return issues, nil
+}
+
+// extractTo copies zf to dst, failing if it is larger than the archive said
+// or than maxImportFileSize.
+func extractTo(zf *zip.File, dst string) error {
+	rc, err := zf.Open()
+	if err != nil {
+		return err
+	}
+	defer rc.Close()
+	out, err := os.Create(dst)
+	if err != nil {
+		return err
+	}
+	n, err := io.Copy(out, io.LimitReader(rc, maxImportFileSize+1))
+	if err == nil && (n > maxImportFileSize || uint64(n) > zf.UncompressedSize64) {
+		err = fmt.Errorf("%s is larger than the archive says", zf.Name)
+	}
+	if err != nil {
+		out.Close()
+		os.Remove(dst)
+		return err
+	}
+	return out.Close()
+}
+
+// writeExportZip streams the export document and the attachment files.
+func writeExportZip(w io.Writer, doc *BoardExport) error {
+	zw := zip.NewWriter(w)
+	jw, err := zw.Create(exportDoc)
+	if err != nil {
+		return err
+	}
+	enc := json.NewEncoder(jw)
+	enc.SetIndent("", "  ")
+	if err := enc.Encode(doc); err != nil {
+		return err
+	}
+	written := map[string]bool{}
+	for _, att := range doc.Attachments {
+		base := path.Base(att.URL)
+		if written[base] {
+			continue
+		}
+		f, err := os.Open(filepath.Join(uploadDir, base))
+		if err != nil {
+			continue // file already gone; the importer reports it
+		}
+		fw, err := zw.Create("files/" + base)
+		if err == nil {
+			_, err = io.Copy(fw, f)
+		}
+		f.Close()
+		if err != nil {
+			return err
+		}
+		written[base] = true
+	}
+	return zw.Close()
+}
+
+// exportBoard reads boardID with its cards and their comments.
+func exportBoard(ctx context.Context, db *mongo.Database, boardID string) (*BoardExport, error) {
+	snap, err := loadSnapshot(ctx, db, boardID, true)
+	if err != nil {
+		return nil, err
+	}
+	ids := make([]string, len(snap.Cards))
+	for i, card := range snap.Cards {
+		ids[i] = card.ID
+	}
+	if len(ids) > 0 {
+		cur, err := db.Collection("comments").Find(ctx, bson.M{"cardId": bson.M{"$in": ids}},
+			options.Find().SetSort(bson.D{{Key: "createdAt", Value: 1}}))
+		if err != nil {
+			return nil, err
+		}
+		if err := cur.All(ctx, &snap.Comments); err != nil {
+			return nil, err
+		}
+		for i := range snap.Comments {
+			if snap.Comments[i].Deleted {
+				snap.Comments[i].Body = "" // kept for the replies' sake
+			}
+		}
+	}
+	return &BoardExport{Version: exportVersion, ExportedAt: time.Now(), boardSnapshot: *snap}, nil
+}
+
+// importAs drops and reports what doc can't bring into this environment,
+// stores the archive's files and inserts the rest as a new board called
+// name. doc must have passed validate.
+func (doc *BoardExport) importAs(ctx context.Context, db *mongo.Database, files map[string]*zip.File, name, actorID string) (*Board, map[string]string, []ImportIssue, error) {
+	issues := doc.conflicts(ctx, db.Collection("users"))
+	fileIssues, err := doc.restoreFiles(files)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	issues = append(issues, fileIssues...)
+	if n, _ := db.Collection("boards").CountDocuments(ctx, bson.M{"name": name, "deletedAt": nil}); n > 0 {
+		issues = append(issues, ImportIssue{Kind: "board", Message: fmt.Sprintf("a board named %q already exists", name)})
+	}
+	b, ids, err := doc.insertAsNew(ctx, db, name, actorID)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	return b, ids, issues, nil
+}
+
+func registerExportRoutes(router *gin.Engine, db *mongo.Database, hub *eventHub) {
+	// GET /api/boards/:id/export — JSON by default, ?files=true for a ZIP
+	// that also carries the attachment files.
+	router.GET("/api/boards/:id/export", func(c *gin.Context) {
+		doc, err := exportBoard(context.TODO(), db, c.Param("id"))
+		if err == mongo.ErrNoDocuments {
+			c.JSON(http.StatusNotFound, gin.H{"error": "board not found"})
+			return
+		}
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		name := "board-" + doc.Board.ID
+		if c.Query("files") != "true" {
+			c.Header("Content-Disposition", fmt.Sprintf(`attachment; filename="%s.json"`, name))
+			c.JSON(http.StatusOK, doc)
+			return
+		}
+		c.Header("Content-Type", "application/zip")
+		c.Header("Content-Disposition", fmt.Sprintf(`attachment; filename="%s.zip"`, name))
+		c.Status(http.StatusOK)
+		if err := writeExportZip(c.Writer, doc); err != nil {
+			_ = c.Error(err) // headers are out; the client sees a broken archive
+		}
+	})
+
+	// POST /api/boards/import — body is an export (JSON, or multipart "file"
+	// with the JSON or ZIP). ?name= renames the board. Everything gets new
+	// ids; what could not be carried over is listed under "conflicts".
+	router.POST("/api/boards/import", func(c *gin.Context) {
+		doc, files, err := readImport(c)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		if problems := doc.validate(); len(problems) > 0 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid export document", "problems": problems})
+			return
+		}
+		name := strings.TrimSpace(c.Query("name"))
+		if name == "" {
+			name = doc.Board.Name
+		}
+		b, ids, issues, err := doc.importAs(context.TODO(), db, files, name, currentUserID(c))
+		if err != nil {
+			if errors.As(err, new(*requiredFieldError)) {
+				c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+				return
+			}
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		hub.emit(b.ID, EventBoardCreated, currentUserID(c), b)
+		c.JSON(http.StatusOK, gin.H{"board": b, "ids": ids, "conflicts": issues})
+	})
+}
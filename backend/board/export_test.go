@@ -0,0 +1,374 @@
+package kanban
+
+import (
+	"archive/zip"
+	"bytes"
+	"context"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// testDB connects to MONGO_TEST_URI and hands out a fresh database that is
+// dropped after the test. Without MONGO_TEST_URI the test is skipped.
+func testDB(t *testing.T) *mongo.Database {
+	t.Helper()
+	uri := os.Getenv("MONGO_TEST_URI")
+	if uri == "" {
+		t.Skip("MONGO_TEST_URI not set")
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	client, err := mongo.Connect(ctx, options.Client().ApplyURI(uri))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := client.Ping(ctx, nil); err != nil {
+		t.Fatal(err)
+	}
+	db := client.Database("bell_test_" + newID())
+	t.Cleanup(func() {
+		_ = db.Drop(context.Background())
+		_ = client.Disconnect(context.Background())
+	})
+	return db
+}
+
+// testUploads points uploadDir at a temporary directory for the test.
+func testUploads(t *testing.T) string {
+	t.Helper()
+	dir, old := t.TempDir(), uploadDir
+	uploadDir = dir
+	t.Cleanup(func() { uploadDir = old })
+	return dir
+}
+
+// importRequest reads data back the way POST /api/boards/import does, as a
+// multipart "file".
+func importRequest(t *testing.T, data []byte) (*BoardExport, map[string]*zip.File) {
+	t.Helper()
+	var body bytes.Buffer
+	mw := multipart.NewWriter(&body)
+	fw, err := mw.CreateFormFile("file", "board.zip")
+	if err != nil {
+		t.Fatal(err)
+	}
+	fw.Write(data)
+	mw.Close()
+	c, _ := gin.CreateTestContext(httptest.NewRecorder())
+	c.Request = httptest.NewRequest(http.MethodPost, "/api/boards/import", &body)
+	c.Request.Header.Set("Content-Type", mw.FormDataContentType())
+	doc, files, err := readImport(c)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return doc, files
+}
+
+func hasIssue(issues []ImportIssue, kind, id string) bool {
+	for _, is := range issues {
+		if is.Kind == kind && is.ID == id {
+			return true
+		}
+	}
+	return false
+}
+
+func TestExportValidate(t *testing.T) {
+	doc := &BoardExport{Version: exportVersion, boardSnapshot: boardSnapshot{
+		Board:    Board{ID: "b1", Name: "Ops"},
+		Statuses: []Status{{ID: "s1", Category: CategoryDone}, {ID: "s2", Category: "later"}},
+		Cards:    []Card{{ID: "c1"}, {ID: "c1"}, {ID: "s1"}},
+		Links:    []CardLink{{ID: "l1", Type: "follows", FromID: "c1", ToID: "s1"}},
+	}}
+	problems := strings.Join(doc.validate(), "\n")
+	for _, want := range []string{
+		`duplicate id c1 (card)`,
+		`duplicate id s1 (card)`,
+		`status s2 has unknown category "later"`,
+		`link l1 has unknown type "follows"`,
+	} {
+		if !strings.Contains(problems, want) {
+			t.Errorf("problems miss %q:\n%s", want, problems)
+		}
+	}
+
+	doc = &BoardExport{Version: exportVersion + 1, boardSnapshot: boardSnapshot{Board: Board{ID: "b1"}}}
+	problems = strings.Join(doc.validate(), "\n")
+	for _, want := range []string{"unsupported export version", "board name is missing"} {
+		if !strings.Contains(problems, want) {
+			t.Errorf("problems miss %q:\n%s", want, problems)
+		}
+	}
+}
+
+func TestExportZipFiles(t *testing.T) {
+	dir := testUploads(t)
+	if err := os.WriteFile(filepath.Join(dir, "1_plan.png"), []byte("png"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "2_other.txt"), []byte("secret"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	doc := &BoardExport{Version: exportVersion, boardSnapshot: boardSnapshot{
+		Board: Board{ID: "b1", Name: "Ops"},
+		Cards: []Card{
+			{ID: "c1", Image: "/uploads/1_plan.png"},
+			{ID: "c2", Image: "/uploads/2_other.txt"},
+		},
+		Attachments: []Attachment{
+			{ID: "a1", CardID: "c1", URL: "/uploads/1_plan.png", Name: "plan.png"},
+			{ID: "a2", CardID: "c2", URL: "/uploads/2_other.txt", Name: "other.txt"},
+		},
+	}}
+	var buf bytes.Buffer
+	if err := writeExportZip(&buf, doc); err != nil {
+		t.Fatal(err)
+	}
+	// the archive is all the importer reads: take other.txt out of it and
+	// plan.png off the disk
+	if err := os.Remove(filepath.Join(dir, "1_plan.png")); err != nil {
+		t.Fatal(err)
+	}
+	zr, err := zip.NewReader(bytes.NewReader(buf.Bytes()), int64(buf.Len()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var kept bytes.Buffer
+	zw := zip.NewWriter(&kept)
+	for _, zf := range zr.File {
+		if zf.Name == "files/2_other.txt" {
+			continue
+		}
+		if err := zw.Copy(zf); err != nil {
+			t.Fatal(err)
+		}
+	}
+	zw.Close()
+
+	got, files := importRequest(t, kept.Bytes())
+	if len(got.Cards) != 2 || len(got.Attachments) != 2 {
+		t.Fatalf("document did not survive the archive: %+v", got.boardSnapshot)
+	}
+	issues, err := got.restoreFiles(files)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(got.Attachments) != 1 {
+		t.Fatalf("attachments = %+v, want only plan.png", got.Attachments)
+	}
+	att := got.Attachments[0]
+	if att.URL == "/uploads/1_plan.png" || !strings.HasSuffix(att.URL, "_plan.png") {
+		t.Errorf("restored url = %q, want a fresh name", att.URL)
+	}
+	if data, err := os.ReadFile(filepath.Join(dir, filepath.Base(att.URL))); err != nil || string(data) != "png" {
+		t.Errorf("restored file = %q, %v", data, err)
+	}
+	if got.Cards[0].Image != att.URL {
+		t.Errorf("cover = %q, want %q", got.Cards[0].Image, att.URL)
+	}
+	if got.Cards[1].Image != "" {
+		t.Errorf("cover of the dropped file = %q, want none", got.Cards[1].Image)
+	}
+	if !hasIssue(issues, "attachment", "a2") {
+		t.Errorf("issues = %+v, want a2 reported", issues)
+	}
+}
+
+func TestImportLimits(t *testing.T) {
+	var buf bytes.Buffer
+	zw := zip.NewWriter(&buf)
+	for i := 0; i <= maxImportFiles+1; i++ {
+		if _, err := zw.Create("files/" + newID()); err != nil {
+			t.Fatal(err)
+		}
+	}
+	zw.Close()
+	var body bytes.Buffer
+	mw := multipart.NewWriter(&body)
+	fw, _ := mw.CreateFormFile("file", "board.zip")
+	fw.Write(buf.Bytes())
+	mw.Close()
+	c, _ := gin.CreateTestContext(httptest.NewRecorder())
+	c.Request = httptest.NewRequest(http.MethodPost, "/api/boards/import", &body)
+	c.Request.Header.Set("Content-Type", mw.FormDataContentType())
+	if _, _, err := readImport(c); err == nil || !strings.Contains(err.Error(), "more than") {
+		t.Errorf("readImport of %d files: err = %v", maxImportFiles+2, err)
+	}
+}
+
+func TestExportImportRoundTrip(t *testing.T) {
+	db := testDB(t)
+	dir := testUploads(t)
+	ctx := context.Background()
+	now := time.Now().UTC().Truncate(time.Millisecond)
+
+	// missing is a user of the source environment unknown here
+	known, missing := primitive.NewObjectID(), primitive.NewObjectID()
+	if _, err := db.Collection("users").InsertOne(ctx, bson.M{"_id": known, "name": "Ann", "email": "ann@example.com"}); err != nil {
+		t.Fatal(err)
+	}
+	board := Board{ID: newID(), Name: "Ops", CreatedAt: now, UpdatedAt: now,
+		Lanes:  []Swimlane{{ID: newID(), Name: "Urgent"}},
+		Fields: []CustomField{{ID: newID(), Name: "Owner", Type: FieldUser}},
+	}
+	todo := Status{ID: newID(), BoardID: board.ID, Name: "To do", Category: CategoryTodo, CreatedAt: now}
+	done := Status{ID: newID(), BoardID: board.ID, Name: "Done", Category: CategoryDone, CreatedAt: now}
+	bug := Label{ID: newID(), BoardID: board.ID, Name: "bug", Key: "bug", Color: "#ff0000", CreatedAt: now}
+	parent := Card{ID: newID(), BoardID: board.ID, StatusID: todo.ID, LaneID: board.Lanes[0].ID, Title: "Parent",
+		Labels: []string{bug.ID}, Tags: []string{"bug"}, Assignees: []string{known.Hex(), missing.Hex()},
+		Fields: map[string]interface{}{board.Fields[0].ID: missing.Hex()}, Image: "/uploads/1_plan.png", CreatedAt: now}
+	child := Card{ID: newID(), BoardID: board.ID, StatusID: done.ID, ParentID: parent.ID, Title: "Child", CreatedAt: now}
+	link := CardLink{ID: newID(), Type: LinkBlocks, FromID: parent.ID, ToID: child.ID, FromBoardID: board.ID, ToBoardID: board.ID, CreatedAt: now}
+	att := Attachment{ID: newID(), CardID: parent.ID, BoardID: board.ID, URL: "/uploads/1_plan.png", Name: "plan.png", CreatedAt: now}
+	first := Comment{ID: newID(), CardID: parent.ID, BoardID: board.ID, AuthorID: known.Hex(), Body: "first", CreatedAt: now}
+	reply := Comment{ID: newID(), CardID: parent.ID, BoardID: board.ID, ParentID: first.ID, AuthorID: missing.Hex(), Body: "reply", CreatedAt: now.Add(time.Second)}
+	if err := os.WriteFile(filepath.Join(dir, "1_plan.png"), []byte("png"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	for coll, docs := range map[string][]interface{}{
+		"boards":      {board},
+		"statuses":    {todo, done},
+		"labels":      {bug},
+		"cards":       {parent, child},
+		"card_links":  {link},
+		"attachments": {att},
+		"comments":    {first, reply},
+	} {
+		if _, err := db.Collection(coll).InsertMany(ctx, docs); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	doc, err := exportBoard(ctx, db, board.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var buf bytes.Buffer
+	if err := writeExportZip(&buf, doc); err != nil {
+		t.Fatal(err)
+	}
+
+	got, files := importRequest(t, buf.Bytes())
+	if problems := got.validate(); len(problems) > 0 {
+		t.Fatalf("validate: %v", problems)
+	}
+	b, ids, issues, err := got.importAs(ctx, db, files, "Ops", known.Hex())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, old := range []string{board.ID, board.Lanes[0].ID, board.Fields[0].ID, todo.ID, done.ID, parent.ID, child.ID, first.ID, reply.ID} {
+		if ids[old] == "" || ids[old] == old {
+			t.Errorf("id %s mapped to %q", old, ids[old])
+		}
+	}
+	if b.ID != ids[board.ID] || b.Name != "Ops" {
+		t.Errorf("board = %s %q", b.ID, b.Name)
+	}
+	if len(b.Lanes) != 1 || b.Lanes[0].ID != ids[board.Lanes[0].ID] {
+		t.Errorf("lanes = %+v", b.Lanes)
+	}
+
+	var cards []Card
+	cur, err := db.Collection("cards").Find(ctx, bson.M{"boardId": b.ID})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := cur.All(ctx, &cards); err != nil {
+		t.Fatal(err)
+	}
+	byID := map[string]Card{}
+	for _, card := range cards {
+		byID[card.ID] = card
+	}
+	p, ok := byID[ids[parent.ID]]
+	if !ok || len(byID) != 2 {
+		t.Fatalf("imported cards = %+v", cards)
+	}
+	if p.StatusID != ids[todo.ID] || p.LaneID != ids[board.Lanes[0].ID] {
+		t.Errorf("parent in status %s lane %s", p.StatusID, p.LaneID)
+	}
+	if len(p.Assignees) != 1 || p.Assignees[0] != known.Hex() {
+		t.Errorf("assignees = %v, want only the known user", p.Assignees)
+	}
+	if len(p.Fields) != 0 {
+		t.Errorf("fields = %v, want the unknown owner dropped", p.Fields)
+	}
+	var label Label
+	if len(p.Labels) != 1 || db.Collection("labels").FindOne(ctx, bson.M{"_id": p.Labels[0], "boardId": b.ID}).Decode(&label) != nil || label.Name != "bug" {
+		t.Errorf("labels = %v", p.Labels)
+	}
+	if p.Labels[0] == bug.ID {
+		t.Errorf("label id was not remapped")
+	}
+	if c := byID[ids[child.ID]]; c.ParentID != p.ID || c.StatusID != ids[done.ID] {
+		t.Errorf("child = %+v", c)
+	}
+
+	var links []CardLink
+	if cur, err = db.Collection("card_links").Find(ctx, bson.M{"fromBoardId": b.ID}); err == nil {
+		_ = cur.All(ctx, &links)
+	}
+	if len(links) != 1 || links[0].FromID != p.ID || links[0].ToID != ids[child.ID] || links[0].Type != LinkBlocks {
+		t.Errorf("links = %+v", links)
+	}
+
+	var atts []Attachment
+	if cur, err = db.Collection("attachments").Find(ctx, bson.M{"boardId": b.ID}); err == nil {
+		_ = cur.All(ctx, &atts)
+	}
+	if len(atts) != 1 || atts[0].CardID != p.ID || atts[0].URL == att.URL {
+		t.Errorf("attachments = %+v", atts)
+	} else if p.Image != atts[0].URL {
+		t.Errorf("cover = %q, want %q", p.Image, atts[0].URL)
+	}
+
+	var comments []Comment
+	if cur, err = db.Collection("comments").Find(ctx, bson.M{"boardId": b.ID}); err == nil {
+		_ = cur.All(ctx, &comments)
+	}
+	replies := 0
+	for _, cm := range comments {
+		if cm.CardID != p.ID {
+			t.Errorf("comment %s on card %s", cm.ID, cm.CardID)
+		}
+		if cm.ParentID == ids[first.ID] {
+			replies++
+		}
+	}
+	if len(comments) != 2 || replies != 1 {
+		t.Errorf("comments = %+v", comments)
+	}
+
+	if !hasIssue(issues, "user", missing.Hex()) {
+		t.Errorf("issues = %+v, want the missing user reported", issues)
+	}
+	users := 0
+	for _, is := range issues {
+		if is.Kind == "user" {
+			users++
+		}
+	}
+	if users != 3 { // assignee, custom field, comment author
+		t.Errorf("%d user issues, want 3: %+v", users, issues)
+	}
+	if !hasIssue(issues, "board", "") {
+		t.Errorf("issues = %+v, want the name clash reported", issues)
+	}
+
+	if n, _ := db.Collection("cards").CountDocuments(ctx, bson.M{"boardId": board.ID}); n != 2 {
+		t.Errorf("source board has %d cards after the import", n)
+	}
+}
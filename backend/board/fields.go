@@ -0,0 +1,705 @@
+package kanban
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"net/http"
+	"net/url"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// Custom field types.
+const (
+	FieldText        = "text"
+	FieldNumber      = "number"
+	FieldDate        = "date"
+	FieldSelect      = "select"
+	FieldMultiSelect = "multiselect"
+	FieldUser        = "user"
+	FieldURL         = "url"
+)
+
+const maxFieldText = 10000
+
+// CustomField is a board-defined card field. Cards keep their values in
+// Card.Fields by field id: text, select, user (id) and url as strings,
+// numbers as float64, dates as dates and multi-selects as string lists.
+type CustomField struct {
+	ID       string   `bson:"id" json:"id"`
+	Name     string   `bson:"name" json:"name"`
+	Type     string   `bson:"type" json:"type"`
+	Options  []string `bson:"options,omitempty" json:"options,omitempty"` // select and multiselect
+	Required bool     `bson:"required,omitempty" json:"required,omitempty"`
+	Position int      `bson:"position" json:"position"`
+}
+
+// check tidies a definition and reports what is wrong with it.
+func (f *CustomField) check() error {
+	f.Name = strings.TrimSpace(f.Name)
+	if f.Name == "" {
+		return errors.New("field name is required")
+	}
+	switch f.Type {
+	case FieldText, FieldNumber, FieldDate, FieldUser, FieldURL:
+		f.Options = nil
+		return nil
+	case FieldSelect, FieldMultiSelect:
+	default:
+		return fmt.Errorf("unknown field type %q (use text, number, date, select, multiselect, user or url)", f.Type)
+	}
+	var opts []string
+	for _, o := range f.Options {
+		o = strings.TrimSpace(o)
+		if o == "" {
+			continue
+		}
+		for _, prev := range opts {
+			if strings.EqualFold(prev, o) {
+				return fmt.Errorf("%s: option %q is listed twice", f.Name, o)
+			}
+		}
+		opts = append(opts, o)
+	}
+	if len(opts) == 0 {
+		return fmt.Errorf("%s: a %s field needs options", f.Name, f.Type)
+	}
+	f.Options = opts
+	return nil
+}
+
+// option returns the option matching s, ignoring case.
+func (f *CustomField) option(s string) (string, bool) {
+	for _, o := range f.Options {
+		if strings.EqualFold(o, s) {
+			return o, true
+		}
+	}
+	return "", false
+}
+
+// value checks v against the field's type and returns it in stored form.
+// nil and empty values come back as nil, meaning no value.
+func (f *CustomField) value(v interface{}) (interface{}, error) {
+	if v == nil {
+		return nil, nil
+	}
+	invalid := fmt.Errorf("%s: not a valid %s value", f.Name, f.Type)
+	switch f.Type {
+	case FieldText, FieldURL, FieldUser, FieldSelect:
+		s, ok := v.(string)
+		if !ok {
+			return nil, invalid
+		}
+		if s = strings.TrimSpace(s); s == "" {
+			return nil, nil
+		}
+		switch f.Type {
+		case FieldText:
+			if len(s) > maxFieldText {
+				return nil, fmt.Errorf("%s: longer than %d characters", f.Name, maxFieldText)
+			}
+		case FieldURL:
+			if u, err := url.Parse(s); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+				return nil, fmt.Errorf("%s: must be an http(s) URL", f.Name)
+			}
+		case FieldUser:
+			if !primitive.IsValidObjectID(s) {
+				return nil, fmt.Errorf("%s: %q is not a user id", f.Name, s)
+			}
+		case FieldSelect:
+			opt, ok := f.option(s)
+			if !ok {
+				return nil, fmt.Errorf("%s: %q is not one of %s", f.Name, s, strings.Join(f.Options, ", "))
+			}
+			s = opt
+		}
+		return s, nil
+	case FieldNumber:
+		var n float64
+		switch x := v.(type) {
+		case float64:
+			n = x
+		case int32:
+			n = float64(x)
+		case int64:
+			n = float64(x)
+		case int:
+			n = float64(x)
+		case string:
+			if strings.TrimSpace(x) == "" {
+				return nil, nil
+			}
+			var err error
+			if n, err = strconv.ParseFloat(strings.TrimSpace(x), 64); err != nil {
+				return nil, invalid
+			}
+		default:
+			return nil, invalid
+		}
+		if math.IsNaN(n) || math.IsInf(n, 0) {
+			return nil, invalid
+		}
+		return n, nil
+	case FieldDate:
+		switch x := v.(type) {
+		case time.Time:
+			return x, nil
+		case primitive.DateTime:
+			return x.Time(), nil
+		case string:
+			if strings.TrimSpace(x) == "" {
+				return nil, nil
+			}
+			t, err := parseDate(x)
+			if err != nil {
+				return nil, fmt.Errorf("%s: %v", f.Name, err)
+			}
+			return t, nil
+		}
+		return nil, invalid
+	case FieldMultiSelect:
+		var items []interface{}
+		switch x := v.(type) {
+		case []interface{}:
+			items = x
+		case primitive.A:
+			items = x
+		case []string:
+			for _, s := range x {
+				items = append(items, s)
+			}
+		default:
+			return nil, invalid
+		}
+		var out []string
+		for _, item := range items {
+			s, ok := item.(string)
+			if !ok {
+				return nil, invalid
+			}
+			opt, ok := f.option(strings.TrimSpace(s))
+			if !ok {
+				return nil, fmt.Errorf("%s: %q is not one of %s", f.Name, s, strings.Join(f.Options, ", "))
+			}
+			if !containsString(out, opt) {
+				out = append(out, opt)
+			}
+		}
+		if len(out) == 0 {
+			return nil, nil
+		}
+		return out, nil
+	}
+	return nil, invalid
+}
+
+// checkFields checks a board's definitions, names being unique.
+func checkFields(fields []CustomField) error {
+	seen := map[string]bool{}
+	for i := range fields {
+		if err := fields[i].check(); err != nil {
+			return err
+		}
+		key := strings.ToLower(fields[i].Name)
+		if seen[key] {
+			return fmt.Errorf("there is already a field called %q", fields[i].Name)
+		}
+		seen[key] = true
+	}
+	return nil
+}
+
+// field finds one of the board's custom fields by id or name.
+func (b *Board) field(key string) *CustomField {
+	for i := range b.Fields {
+		if b.Fields[i].ID == key {
+			return &b.Fields[i]
+		}
+	}
+	for i := range b.Fields {
+		if strings.EqualFold(b.Fields[i].Name, key) {
+			return &b.Fields[i]
+		}
+	}
+	return nil
+}
+
+// fieldValues checks values (keyed by field id or name) against the board
+// and returns them in stored form by field id; cleared fields map to nil.
+// User values must be existing users.
+func fieldValues(ctx context.Context, db *mongo.Database, b *Board, values map[string]interface{}) (map[string]interface{}, error) {
+	out := map[string]interface{}{}
+	var users []primitive.ObjectID
+	for key, v := range values {
+		f := b.field(key)
+		if f == nil {
+			return nil, fmt.Errorf("unknown custom field %q", key)
+		}
+		clean, err := f.value(v)
+		if err != nil {
+			return nil, err
+		}
+		if id, ok := clean.(string); ok && f.Type == FieldUser {
+			oid, _ := primitive.ObjectIDFromHex(id)
+			users = append(users, oid)
+		}
+		out[f.ID] = clean
+	}
+	if len(users) > 0 {
+		n, err := db.Collection("users").CountDocuments(ctx, bson.M{"_id": bson.M{"$in": users}})
+		if err != nil {
+			return nil, err
+		}
+		distinct := map[primitive.ObjectID]bool{}
+		for _, u := range users {
+			distinct[u] = true
+		}
+		if int(n) != len(distinct) {
+			return nil, errors.New("custom field names a user that doesn't exist")
+		}
+	}
+	return out, nil
+}
+
+func loadFieldBoard(ctx context.Context, db *mongo.Database, boardID string) (*Board, error) {
+	var b Board
+	if err := db.Collection("boards").FindOne(ctx, bson.M{"_id": boardID}).Decode(&b); err != nil {
+		return nil, errors.New("board not found")
+	}
+	return &b, nil
+}
+
+// requiredFieldError is a card without a value of a required field.
+type requiredFieldError struct{ name string }
+
+func (e *requiredFieldError) Error() string { return e.name + " is required" }
+
+// checkRequiredFields fails unless values has each required field of b.
+func checkRequiredFields(b *Board, values map[string]interface{}) error {
+	for _, f := range b.Fields {
+		if _, ok := values[f.ID]; f.Required && !ok {
+			return &requiredFieldError{f.Name}
+		}
+	}
+	return nil
+}
+
+// createCardFields validates the values of a new card and requires the
+// board's required fields. Every way of adding a card to a board goes
+// through it, so cards made by mail, recurrences or conversions are held to
+// the same fields as ones made in the UI.
+func createCardFields(ctx context.Context, db *mongo.Database, card *Card) error {
+	if len(card.Fields) == 0 {
+		card.Fields = nil
+	}
+	b, err := loadFieldBoard(ctx, db, card.BoardID)
+	if err != nil {
+		if card.Fields == nil {
+			return nil
+		}
+		return err
+	}
+	values, err := fieldValues(ctx, db, b, card.Fields)
+	if err != nil {
+		return err
+	}
+	card.Fields = nil
+	for id, v := range values {
+		if v == nil {
+			continue
+		}
+		if card.Fields == nil {
+			card.Fields = map[string]interface{}{}
+		}
+		card.Fields[id] = v
+	}
+	return checkRequiredFields(b, card.Fields)
+}
+
+// updateCardFields merges the values a PUT sent into the card's current
+// ones; null clears a value, except of a required field. Values of fields
+// the card's board doesn't define (it moved, or the field went) are dropped,
+// and a card moved to another board needs that board's required fields.
+func updateCardFields(ctx context.Context, db *mongo.Database, before, update *Card) error {
+	boardID := update.BoardID
+	if boardID == "" {
+		boardID = before.BoardID
+	}
+	clearAll := containsString(update.cleared, "fields")
+	if update.Fields == nil && !clearAll && boardID == before.BoardID {
+		return nil
+	}
+	b, err := loadFieldBoard(ctx, db, boardID)
+	if err != nil {
+		return err
+	}
+	sent := update.Fields
+	if clearAll {
+		sent = map[string]interface{}{}
+		for id := range before.Fields {
+			if f := b.field(id); f != nil && f.ID == id {
+				sent[id] = nil
+			}
+		}
+	}
+	values, err := fieldValues(ctx, db, b, sent)
+	if err != nil {
+		return err
+	}
+	merged := map[string]interface{}{}
+	for id, v := range before.Fields {
+		if f := b.field(id); f != nil && f.ID == id {
+			merged[id] = v
+		}
+	}
+	for id, v := range values {
+		if v != nil {
+			merged[id] = v
+			continue
+		}
+		if f := b.field(id); f.Required {
+			if _, had := merged[id]; had {
+				return fmt.Errorf("%s is required", f.Name)
+			}
+		}
+		delete(merged, id)
+	}
+	if boardID != before.BoardID {
+		if err := checkRequiredFields(b, merged); err != nil {
+			return err
+		}
+	}
+	update.cleared = removeStrings(update.cleared, "fields")
+	if len(merged) == 0 {
+		update.Fields = nil
+		update.cleared = append(update.cleared, "fields")
+	} else {
+		update.Fields = merged
+	}
+	return nil
+}
+
+func hasFieldFilters(c *gin.Context) bool {
+	for key := range c.Request.URL.Query() {
+		if strings.HasPrefix(key, "field.") {
+			return true
+		}
+	}
+	return false
+}
+
+// fieldFilters turns the ?field.<name>= parameters of a card listing into
+// filters on b's cards. Several values (repeated, or comma-separated for
+// select, multiselect and user fields) match any; "none" matches cards
+// without a value and "me" the current user. Numbers and dates also take
+// ?field.<name>.gt=, .gte=, .lt= and .lte=; a date alone matches the day.
+func fieldFilters(c *gin.Context, b *Board) ([]bson.M, error) {
+	var and []bson.M
+	params := c.Request.URL.Query()
+	keys := make([]string, 0, len(params))
+	for key := range params {
+		if strings.HasPrefix(key, "field.") {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		name, op := strings.TrimPrefix(key, "field."), ""
+		f := b.field(name)
+		if f == nil {
+			if i := strings.LastIndex(name, "."); i > 0 {
+				name, op = name[:i], name[i+1:]
+				f = b.field(name)
+			}
+		}
+		if f == nil {
+			return nil, fmt.Errorf("unknown custom field %q", name)
+		}
+		path := "fields." + f.ID
+		if op != "" {
+			clause, err := fieldRange(f, path, op, params[key])
+			if err != nil {
+				return nil, err
+			}
+			and = append(and, clause)
+			continue
+		}
+		var values []string
+		for _, v := range params[key] {
+			if f.Type == FieldSelect || f.Type == FieldMultiSelect || f.Type == FieldUser {
+				values = append(values, strings.Split(v, ",")...)
+			} else {
+				values = append(values, v)
+			}
+		}
+		var or bson.A
+		for _, v := range values {
+			v = strings.TrimSpace(v)
+			if strings.EqualFold(v, "none") {
+				or = append(or, bson.M{path: bson.M{"$in": bson.A{nil, "", bson.A{}}}})
+				continue
+			}
+			if f.Type == FieldUser && strings.EqualFold(v, "me") {
+				if v = currentUserID(c); v == "" {
+					return nil, fmt.Errorf(`%s: "me" needs a signed-in user`, f.Name)
+				}
+			}
+			switch f.Type {
+			case FieldText, FieldURL:
+				or = append(or, bson.M{path: primitive.Regex{Pattern: "^" + regexp.QuoteMeta(v) + "$", Options: "i"}})
+			case FieldDate:
+				day, err := parseDate(v)
+				if err != nil {
+					return nil, fmt.Errorf("%s: %v", f.Name, err)
+				}
+				or = append(or, bson.M{path: bson.M{"$gte": day, "$lt": day.AddDate(0, 0, 1)}})
+			default:
+				// a multiselect matches when one of its options does
+				one := *f
+				if one.Type == FieldMultiSelect {
+					one.Type = FieldSelect
+				}
+				clean, err := one.value(v)
+				if err != nil {
+					return nil, err
+				}
+				or = append(or, bson.M{path: clean})
+			}
+		}
+		if len(or) == 1 {
+			and = append(and, or[0].(bson.M))
+		} else if len(or) > 1 {
+			and = append(and, bson.M{"$or": or})
+		}
+	}
+	return and, nil
+}
+
+func fieldRange(f *CustomField, path, op string, values []string) (bson.M, error) {
+	ops := map[string]string{"gt": "$gt", "gte": "$gte", "lt": "$lt", "lte": "$lte"}
+	mop, ok := ops[op]
+	if !ok {
+		return nil, fmt.Errorf("unknown comparison %q (use gt, gte, lt or lte)", op)
+	}
+	if f.Type != FieldNumber && f.Type != FieldDate {
+		return nil, fmt.Errorf("%s: only number and date fields can be compared", f.Name)
+	}
+	cond := bson.M{}
+	for _, v := range values {
+		clean, err := f.value(v)
+		if err != nil {
+			return nil, err
+		}
+		if clean == nil {
+			return nil, fmt.Errorf("%s: missing value to compare with", f.Name)
+		}
+		cond[mop] = clean
+	}
+	return bson.M{path: cond}, nil
+}
+
+func registerFieldRoutes(router *gin.Engine, db *mongo.Database, changes *changeLog) {
+	boardColl := db.Collection("boards")
+	cardColl := db.Collection("cards")
+	hub := changes.hub
+
+	loadBoard := func(c *gin.Context) (*Board, bool) {
+		var b Board
+		if err := boardColl.FindOne(context.TODO(), bson.M{"_id": c.Param("id"), "deletedAt": nil}).Decode(&b); err != nil {
+			c.JSON(http.StatusNotFound, gin.H{"error": "board not found"})
+			return nil, false
+		}
+		return &b, true
+	}
+	// saveFields writes the field list back, on the same terms as saveLanes
+	// in swimlanes.go; callers answer when it returns true.
+	saveFields := func(c *gin.Context, b *Board) bool {
+		if err := checkFields(b.Fields); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return false
+		}
+		for i := range b.Fields {
+			b.Fields[i].Position = i
+		}
+		pre, ok := readPrecondition(c, b.Version)
+		if !ok {
+			return false
+		}
+		b.UpdatedAt = time.Now()
+		res, err := boardColl.UpdateOne(context.TODO(), pre.filter(bson.M{"_id": b.ID}),
+			withVersion(bson.M{"$set": bson.M{"fields": b.Fields, "updatedAt": b.UpdatedAt}}))
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return false
+		}
+		if res.MatchedCount == 0 {
+			var current Board
+			if err := boardColl.FindOne(context.TODO(), bson.M{"_id": b.ID}).Decode(&current); err != nil {
+				c.JSON(http.StatusNotFound, gin.H{"error": "board not found"})
+				return false
+			}
+			pre.fail(c, current.Version, current)
+			return false
+		}
+		b.Version++
+		hub.emit(b.ID, EventBoardUpdated, currentUserID(c), b)
+		return true
+	}
+	fieldIndex := func(c *gin.Context, b *Board) int {
+		for i, f := range b.Fields {
+			if f.ID == c.Param("fieldId") {
+				return i
+			}
+		}
+		c.JSON(http.StatusNotFound, gin.H{"error": "field not found"})
+		return -1
+	}
+
+	router.GET("/api/boards/:id/fields", func(c *gin.Context) {
+		b, ok := loadBoard(c)
+		if !ok {
+			return
+		}
+		out := b.Fields
+		if out == nil {
+			out = []CustomField{}
+		}
+		c.JSON(http.StatusOK, out)
+	})
+
+	// POST /api/boards/:id/fields {"name", "type", "options", "required"}
+	router.POST("/api/boards/:id/fields", func(c *gin.Context) {
+		var f CustomField
+		if err := c.BindJSON(&f); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		b, ok := loadBoard(c)
+		if !ok {
+			return
+		}
+		f.ID = newID()
+		b.Fields = append(b.Fields, f)
+		if saveFields(c, b) {
+			c.JSON(http.StatusOK, b.Fields[len(b.Fields)-1])
+		}
+	})
+
+	// PUT /api/boards/:id/fields/:fieldId {"name", "options", "required",
+	// "position"} — the type is fixed. Options left out are cleared from
+	// the cards that had them; ones only changing case are respelled there.
+	router.PUT("/api/boards/:id/fields/:fieldId", func(c *gin.Context) {
+		var in struct {
+			Name     *string   `json:"name"`
+			Type     *string   `json:"type"`
+			Options  *[]string `json:"options"`
+			Required *bool     `json:"required"`
+			Position *int      `json:"position"`
+		}
+		if err := c.BindJSON(&in); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		b, ok := loadBoard(c)
+		if !ok {
+			return
+		}
+		idx := fieldIndex(c, b)
+		if idx < 0 {
+			return
+		}
+		f := b.Fields[idx]
+		if in.Type != nil && *in.Type != f.Type {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "a field's type can't be changed; add a new field instead"})
+			return
+		}
+		if in.Name != nil {
+			f.Name = *in.Name
+		}
+		if in.Required != nil {
+			f.Required = *in.Required
+		}
+		old := f.Options
+		if in.Options != nil {
+			f.Options = *in.Options
+		}
+		b.Fields[idx] = f
+		if in.Position != nil {
+			rest := append(append([]CustomField(nil), b.Fields[:idx]...), b.Fields[idx+1:]...)
+			pos := *in.Position
+			if pos < 0 {
+				pos = 0
+			}
+			if pos > len(rest) {
+				pos = len(rest)
+			}
+			b.Fields = append(rest[:pos:pos], append([]CustomField{f}, rest[pos:]...)...)
+		}
+		if !saveFields(c, b) {
+			return
+		}
+		// compare with the options as saved (trimmed); values whose option
+		// only changed case take the new spelling
+		saved := b.field(f.ID)
+		path := "fields." + f.ID
+		var dropped []string
+		for _, o := range old {
+			n, kept := saved.option(o)
+			switch {
+			case !kept:
+				dropped = append(dropped, o)
+			case n != o && f.Type == FieldSelect:
+				_, _ = cardColl.UpdateMany(context.TODO(), bson.M{"boardId": b.ID, path: o},
+					withVersion(bson.M{"$set": bson.M{path: n}}))
+			case n != o:
+				_, _ = cardColl.UpdateMany(context.TODO(), bson.M{"boardId": b.ID, path: o},
+					withVersion(bson.M{"$set": bson.M{path + ".$[el]": n}}),
+					options.Update().SetArrayFilters(options.ArrayFilters{Filters: []interface{}{bson.M{"el": o}}}))
+			}
+		}
+		if len(dropped) > 0 {
+			if f.Type == FieldSelect {
+				_, _ = cardColl.UpdateMany(context.TODO(), bson.M{"boardId": b.ID, path: bson.M{"$in": dropped}},
+					withVersion(bson.M{"$unset": bson.M{path: ""}}))
+			} else {
+				_, _ = cardColl.UpdateMany(context.TODO(), bson.M{"boardId": b.ID, path: bson.M{"$in": dropped}},
+					withVersion(bson.M{"$pull": bson.M{path: bson.M{"$in": dropped}}}))
+				_, _ = cardColl.UpdateMany(context.TODO(), bson.M{"boardId": b.ID, path: bson.A{}},
+					withVersion(bson.M{"$unset": bson.M{path: ""}}))
+			}
+		}
+		c.JSON(http.StatusOK, saved)
+	})
+
+	// DELETE /api/boards/:id/fields/:fieldId drops the field and its values.
+	router.DELETE("/api/boards/:id/fields/:fieldId", func(c *gin.Context) {
+		b, ok := loadBoard(c)
+		if !ok {
+			return
+		}
+		idx := fieldIndex(c, b)
+		if idx < 0 {
+			return
+		}
+		id := b.Fields[idx].ID
+		b.Fields = append(b.Fields[:idx], b.Fields[idx+1:]...)
+		if !saveFields(c, b) {
+			return
+		}
+		_, _ = cardColl.UpdateMany(context.TODO(), bson.M{"boardId": b.ID, "fields." + id: bson.M{"$exists": true}},
+			withVersion(bson.M{"$unset": bson.M{"fields." + id: ""}}))
+		c.JSON(http.StatusOK, gin.H{"status": "deleted"})
+	})
+}
@@ -0,0 +1,457 @@
+package kanban
+
+import (
+	"bytes"
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// ImportReport describes what an importer created (or, on a dry run,
+// would create) and what it could not map.
+type ImportReport struct {
+	DryRun     bool     `json:"dryRun"`
+	Board      string   `json:"board"`
+	Statuses   []string `json:"statuses"`
+	Cards      int      `json:"cards"`
+	Checklists int      `json:"checklists"`
+	Comments   int      `json:"comments"`
+	Tags       []string `json:"tags"`
+	Unmapped   []string `json:"unmapped"`
+}
+
+func reportFor(snap *boardSnapshot, unmapped []string) ImportReport {
+	r := ImportReport{Board: snap.Board.Name, Statuses: []string{}, Tags: []string{}, Unmapped: unmapped}
+	if r.Unmapped == nil {
+		r.Unmapped = []string{}
+	}
+	for _, st := range snap.Statuses {
+		r.Statuses = append(r.Statuses, st.Name)
+	}
+	tags := map[string]bool{}
+	for _, card := range snap.Cards {
+		r.Checklists += len(card.Checklists)
+		for _, t := range card.Tags {
+			if !tags[t] {
+				tags[t] = true
+				r.Tags = append(r.Tags, t)
+			}
+		}
+	}
+	sort.Strings(r.Tags)
+	r.Cards, r.Comments = len(snap.Cards), len(snap.Comments)
+	return r
+}
+
+// importedComment prefixes a comment with its original author, since those
+// are not users here; the importing user becomes the author.
+func importedComment(author string, at time.Time, body string) string {
+	if author == "" {
+		author = "unknown"
+	}
+	return fmt.Sprintf("*%s, %s:*\n\n%s", author, at.Format("2006-01-02 15:04"), body)
+}
+
+// --- Trello ---
+
+type trelloExport struct {
+	Name  string `json:"name"`
+	Lists []struct {
+		ID     string  `json:"id"`
+		Name   string  `json:"name"`
+		Closed bool    `json:"closed"`
+		Pos    float64 `json:"pos"`
+	} `json:"lists"`
+	Cards []struct {
+		ID          string  `json:"id"`
+		Name        string  `json:"name"`
+		Desc        string  `json:"desc"`
+		IDList      string  `json:"idList"`
+		Closed      bool    `json:"closed"`
+		Pos         float64 `json:"pos"`
+		Due         string  `json:"due"`
+		Start       string  `json:"start"`
+		DueComplete bool    `json:"dueComplete"`
+		Labels      []struct {
+			Name  string `json:"name"`
+			Color string `json:"color"`
+		} `json:"labels"`
+		IDMembers   []string          `json:"idMembers"`
+		Attachments []json.RawMessage `json:"attachments"`
+	} `json:"cards"`
+	Checklists []struct {
+		ID         string `json:"id"`
+		IDCard     string `json:"idCard"`
+		Name       string `json:"name"`
+		CheckItems []struct {
+			Name  string  `json:"name"`
+			State string  `json:"state"`
+			Pos   float64 `json:"pos"`
+		} `json:"checkItems"`
+	} `json:"checklists"`
+	Actions []struct {
+		ID   string    `json:"id"`
+		Type string    `json:"type"`
+		Date time.Time `json:"date"`
+		Data struct {
+			Text string `json:"text"`
+			Card struct {
+				ID string `json:"id"`
+			} `json:"card"`
+		} `json:"data"`
+		MemberCreator struct {
+			FullName string `json:"fullName"`
+		} `json:"memberCreator"`
+	} `json:"actions"`
+	CustomFields []json.RawMessage `json:"customFields"`
+}
+
+// parseTrello maps lists to statuses, cards to cards, labels to tags (the
+// colour when a label has no name), checklists and comment actions.
+func parseTrello(data []byte) (*boardSnapshot, []string, error) {
+	var in trelloExport
+	if err := json.Unmarshal(data, &in); err != nil {
+		return nil, nil, fmt.Errorf("not a Trello board export: %w", err)
+	}
+	if len(in.Lists) == 0 {
+		return nil, nil, fmt.Errorf("not a Trello board export: no lists")
+	}
+	snap := &boardSnapshot{Board: Board{ID: "trello", Name: in.Name}}
+	var unmapped []string
+	skip := func(n int, what string) {
+		if n > 0 {
+			unmapped = append(unmapped, fmt.Sprintf("%d %s", n, what))
+		}
+	}
+
+	sort.SliceStable(in.Lists, func(i, j int) bool { return in.Lists[i].Pos < in.Lists[j].Pos })
+	openLists := map[string]bool{}
+	closedLists := 0
+	for _, l := range in.Lists {
+		if l.Closed {
+			closedLists++
+			continue
+		}
+		openLists[l.ID] = true
+		snap.Statuses = append(snap.Statuses, Status{ID: l.ID, Name: l.Name, BoardID: snap.Board.ID})
+	}
+
+	checklists := map[string][]Checklist{}
+	for _, cl := range in.Checklists {
+		sort.SliceStable(cl.CheckItems, func(i, j int) bool { return cl.CheckItems[i].Pos < cl.CheckItems[j].Pos })
+		list := Checklist{Name: cl.Name}
+		for _, item := range cl.CheckItems {
+			list.Items = append(list.Items, ChecklistItem{Text: item.Name, Done: item.State == "complete"})
+		}
+		checklists[cl.IDCard] = append(checklists[cl.IDCard], list)
+	}
+
+	closedCards, withMembers, attachments, dueComplete := 0, 0, 0, 0
+	cards := map[string]bool{}
+	for _, tc := range in.Cards {
+		if tc.Closed || !openLists[tc.IDList] {
+			closedCards++
+			continue
+		}
+		card := Card{
+			ID:          tc.ID,
+			BoardID:     snap.Board.ID,
+			StatusID:    tc.IDList,
+			Position:    tc.Pos,
+			Title:       tc.Name,
+			Description: tc.Desc,
+			Checklists:  checklists[tc.ID],
+		}
+		for _, l := range tc.Labels {
+			tag := strings.TrimSpace(l.Name)
+			if tag == "" {
+				tag = l.Color
+			}
+			if tag != "" {
+				card.Tags = append(card.Tags, tag)
+			}
+		}
+		var err error
+		if card.DueDate, err = optionalDate(&tc.Due); err != nil {
+			return nil, nil, fmt.Errorf("card %q: %w", tc.Name, err)
+		}
+		if card.StartDate, err = optionalDate(&tc.Start); err != nil {
+			return nil, nil, fmt.Errorf("card %q: %w", tc.Name, err)
+		}
+		if len(tc.IDMembers) > 0 {
+			withMembers++
+		}
+		if tc.DueComplete {
+			dueComplete++
+		}
+		attachments += len(tc.Attachments)
+		cards[tc.ID] = true
+		snap.Cards = append(snap.Cards, card)
+	}
+
+	for i := len(in.Actions) - 1; i >= 0; i-- { // Trello lists newest first
+		a := in.Actions[i]
+		if a.Type != "commentCard" || !cards[a.Data.Card.ID] {
+			continue
+		}
+		snap.Comments = append(snap.Comments, Comment{
+			ID:        a.ID,
+			CardID:    a.Data.Card.ID,
+			Body:      importedComment(a.MemberCreator.FullName, a.Date, a.Data.Text),
+			CreatedAt: a.Date,
+			UpdatedAt: a.Date,
+		})
+	}
+
+	skip(closedLists, "archived lists skipped")
+	skip(closedCards, "archived cards skipped")
+	skip(withMembers, "cards with members (Trello members are not users here)")
+	skip(dueComplete, "\"due complete\" marks")
+	skip(attachments, "attachments (files are not part of the export)")
+	skip(len(in.CustomFields), "custom fields")
+	return snap, unmapped, nil
+}
+
+// --- Jira ---
+
+// jiraDate parses the dates of a Jira CSV export ("02/Jan/06 3:04 PM" by
+// default) and falls back to ISO dates.
+func jiraDate(s string) (*time.Time, error) {
+	s = strings.TrimSpace(s)
+	if s == "" {
+		return nil, nil
+	}
+	for _, layout := range []string{"02/Jan/06 3:04 PM", "02/Jan/06", "2006-01-02 15:04", "2006-01-02 15:04:05"} {
+		if t, err := time.Parse(layout, s); err == nil {
+			return &t, nil
+		}
+	}
+	return optionalDate(&s)
+}
+
+var jiraCategories = map[string]string{
+	"to do":       CategoryTodo,
+	"in progress": CategoryInProgress,
+	"done":        CategoryDone,
+}
+
+// jiraColumns are the CSV columns parseJira maps; everything else with a
+// value is reported as unmapped.
+var jiraColumns = map[string]bool{
+	"summary": true, "issue id": true, "status": true, "status category": true,
+	"priority": true, "description": true, "labels": true, "due date": true,
+	"comment": true, "parent id": true, "parent": true, "issue type": true,
+}
+
+// parseJira maps a Jira issue CSV: statuses in order of first appearance
+// (categorised when the export has "Status Category"), labels and the
+// issue type to tags, comments, and sub-tasks to child cards.
+func parseJira(data []byte, name string) (*boardSnapshot, []string, error) {
+	r := csv.NewReader(bytes.NewReader(data))
+	r.FieldsPerRecord = -1
+	r.LazyQuotes = true
+	header, err := r.Read()
+	if err != nil {
+		return nil, nil, fmt.Errorf("not a Jira CSV export: %w", err)
+	}
+	cols := map[string][]int{} // Jira repeats Labels, Comment, ... columns
+	for i, h := range header {
+		header[i] = strings.TrimSpace(strings.TrimPrefix(h, "\ufeff"))
+		key := strings.ToLower(header[i])
+		cols[key] = append(cols[key], i)
+	}
+	if cols["summary"] == nil || cols["status"] == nil {
+		return nil, nil, fmt.Errorf("not a Jira CSV export: Summary and Status columns are required")
+	}
+
+	snap := &boardSnapshot{Board: Board{ID: "jira", Name: name}}
+	statuses := map[string]bool{}
+	unmapped := map[string]bool{}
+	ids := map[string]int{} // issue id to the line it was first on
+	var repeated []string
+	for line := 2; ; line++ {
+		row, err := r.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, nil, fmt.Errorf("line %d: %w", line, err)
+		}
+		values := func(col string) []string {
+			var out []string
+			for _, i := range cols[col] {
+				if i < len(row) && strings.TrimSpace(row[i]) != "" {
+					out = append(out, strings.TrimSpace(row[i]))
+				}
+			}
+			return out
+		}
+		value := func(col string) string {
+			if v := values(col); len(v) > 0 {
+				return v[0]
+			}
+			return ""
+		}
+		for i, h := range header {
+			if !jiraColumns[strings.ToLower(h)] && i < len(row) && strings.TrimSpace(row[i]) != "" {
+				unmapped[h] = true
+			}
+		}
+
+		status := value("status")
+		if status != "" && !statuses[status] {
+			statuses[status] = true
+			snap.Statuses = append(snap.Statuses, Status{
+				ID:       "status:" + status,
+				Name:     status,
+				BoardID:  snap.Board.ID,
+				Category: jiraCategories[strings.ToLower(value("status category"))],
+			})
+		}
+		id := value("issue id")
+		if first, seen := ids[id]; seen {
+			// concatenated exports repeat issues; keep both as cards
+			repeated = append(repeated, fmt.Sprintf("line %d: issue id %s repeats line %d, imported as a separate card", line, id, first))
+			id = ""
+		} else if id != "" {
+			ids[id] = line
+		}
+		if id == "" {
+			id = "row:" + strconv.Itoa(line)
+		}
+		parent := value("parent id")
+		if parent == "" {
+			parent = value("parent")
+		}
+		card := Card{
+			ID:          id,
+			BoardID:     snap.Board.ID,
+			StatusID:    "status:" + status,
+			Position:    float64(line),
+			Title:       value("summary"),
+			Description: value("description"),
+			Priority:    strings.ToLower(value("priority")),
+			ParentID:    parent,
+			Tags:        values("labels"),
+		}
+		if t := strings.ToLower(value("issue type")); t != "" {
+			card.Tags = append(card.Tags, t)
+		}
+		if card.DueDate, err = jiraDate(value("due date")); err != nil {
+			return nil, nil, fmt.Errorf("line %d: %w", line, err)
+		}
+		for n, raw := range values("comment") {
+			// "date;author;body"
+			cm := Comment{ID: fmt.Sprintf("%s:comment:%d", id, n), CardID: id, Body: raw}
+			if parts := strings.SplitN(raw, ";", 3); len(parts) == 3 {
+				if at, err := jiraDate(parts[0]); err == nil && at != nil {
+					cm.Body = importedComment(parts[1], *at, parts[2])
+					cm.CreatedAt, cm.UpdatedAt = *at, *at
+				}
+			}
+			snap.Comments = append(snap.Comments, cm)
+		}
+		snap.Cards = append(snap.Cards, card)
+	}
+	var skipped []string
+	for h := range unmapped {
+		skipped = append(skipped, "column "+h)
+	}
+	sort.Strings(skipped)
+	return snap, append(skipped, repeated...), nil
+}
+
+// errImportTooLarge is answered with 413.
+var errImportTooLarge = fmt.Errorf("upload is larger than %d MB", maxImportFileSize>>20)
+
+// importBody is the uploaded multipart "file", or the raw request body, up
+// to maxImportFileSize as for export documents.
+func importBody(c *gin.Context) ([]byte, error) {
+	if file, err := c.FormFile("file"); err == nil {
+		if file.Size > maxImportFileSize {
+			return nil, errImportTooLarge
+		}
+		f, err := file.Open()
+		if err != nil {
+			return nil, err
+		}
+		defer f.Close()
+		return io.ReadAll(io.LimitReader(f, maxImportFileSize))
+	}
+	data, err := io.ReadAll(http.MaxBytesReader(c.Writer, c.Request.Body, maxImportFileSize))
+	var tooLarge *http.MaxBytesError
+	if errors.As(err, &tooLarge) {
+		return nil, errImportTooLarge
+	}
+	return data, err
+}
+
+func registerImporterRoutes(router *gin.Engine, db *mongo.Database, hub *eventHub) {
+	// run parses the upload and, unless ?dryRun=true, creates the board.
+	run := func(c *gin.Context, parse func([]byte) (*boardSnapshot, []string, error)) {
+		data, err := importBody(c)
+		if err == errImportTooLarge {
+			c.JSON(http.StatusRequestEntityTooLarge, gin.H{"error": err.Error()})
+			return
+		}
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		snap, unmapped, err := parse(data)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		if name := strings.TrimSpace(c.Query("name")); name != "" {
+			snap.Board.Name = name
+		}
+		if snap.Board.Name == "" {
+			snap.Board.Name = "Imported board"
+		}
+		actor := currentUserID(c)
+		for i := range snap.Comments {
+			snap.Comments[i].AuthorID = actor
+		}
+		report := reportFor(snap, unmapped)
+		if c.Query("dryRun") == "true" {
+			report.DryRun = true
+			c.JSON(http.StatusOK, gin.H{"report": report})
+			return
+		}
+		b, _, err := snap.insertAsNew(context.TODO(), db, snap.Board.Name, actor)
+		if err != nil {
+			if errors.As(err, new(*requiredFieldError)) {
+				c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+				return
+			}
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		hub.emit(b.ID, EventBoardCreated, actor, b)
+		c.JSON(http.StatusOK, gin.H{"board": b, "report": report})
+	}
+
+	// POST /api/boards/import/trello — a Trello board JSON export
+	router.POST("/api/boards/import/trello", func(c *gin.Context) {
+		run(c, parseTrello)
+	})
+
+	// POST /api/boards/import/jira — a Jira issue CSV export
+	router.POST("/api/boards/import/jira", func(c *gin.Context) {
+		run(c, func(data []byte) (*boardSnapshot, []string, error) {
+			return parseJira(data, "Jira import")
+		})
+	})
+}
@@ -0,0 +1,28 @@
+package kanban
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestParseJiraRepeatedIDs(t *testing.T) {
+	csv := "Summary,Issue id,Status,Parent id\n" +
+		"First,10,To Do,\n" +
+		"Child,11,To Do,10\n" +
+		"First again,10,Done,\n" +
+		"No id,,Done,\n"
+	snap, skipped, err := parseJira([]byte(csv), "Jira")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var ids []string
+	for _, card := range snap.Cards {
+		ids = append(ids, card.ID)
+	}
+	if got, want := strings.Join(ids, " "), "10 11 row:4 row:5"; got != want {
+		t.Errorf("card ids = %s, want %s", got, want)
+	}
+	if len(skipped) != 1 || !strings.HasPrefix(skipped[0], "line 4: issue id 10 repeats line 2") {
+		t.Errorf("skipped = %q", skipped)
+	}
+}
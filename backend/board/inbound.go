@@ -0,0 +1,547 @@
+package kanban
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"html"
+	"io"
+	"mime"
+	"mime/multipart"
+	"mime/quotedprintable"
+	"net/http"
+	"net/mail"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"time"
+
+	"bell-backend/models"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// maxInboundMail caps the size of a raw message, attachments included.
+const maxInboundMail = 25 << 20
+
+// Inbox is a board's mail address: mail sent to <ID>@INBOUND_MAIL_DOMAIN
+// becomes a card in StatusID. The id is random so the address can't be
+// guessed; rotating it retires the old one.
+type Inbox struct {
+	ID        string    `bson:"_id" json:"key"`
+	BoardID   string    `bson:"boardId" json:"boardId"`
+	StatusID  string    `bson:"statusId" json:"statusId"`
+	Address   string    `bson:"-" json:"address"`
+	CreatedAt time.Time `bson:"createdAt" json:"createdAt"`
+	UpdatedAt time.Time `bson:"updatedAt" json:"updatedAt"`
+}
+
+func newInboxKey() string {
+	b := make([]byte, 8)
+	_, _ = rand.Read(b)
+	return "board-" + hex.EncodeToString(b)
+}
+
+// fillAddress sets Address from INBOUND_MAIL_DOMAIN; without a domain it
+// stays empty and the key has to be combined with the mail gateway's own.
+func (in *Inbox) fillAddress() {
+	if domain := os.Getenv("INBOUND_MAIL_DOMAIN"); domain != "" {
+		in.Address = in.ID + "@" + domain
+	}
+}
+
+// mailThread maps a Message-ID we have seen to the card it went to, so
+// replies (In-Reply-To, References) land on the same card and a message
+// delivered twice is only handled once. CardID is empty while the message
+// is still being handled.
+type mailThread struct {
+	ID        string    `bson:"_id"` // Message-ID without <>
+	CardID    string    `bson:"cardId"`
+	BoardID   string    `bson:"boardId"`
+	CreatedAt time.Time `bson:"createdAt"`
+}
+
+type mailFile struct {
+	Name string
+	Type string
+	Data []byte
+}
+
+// inboundMail is what we take from a message.
+type inboundMail struct {
+	MessageID  string
+	Refs       []string // In-Reply-To, then References newest first
+	From       *mail.Address
+	Subject    string
+	Date       time.Time
+	Text       string
+	HTML       string
+	Files      []mailFile
+	Recipients []string
+}
+
+var wordDecoder = &mime.WordDecoder{
+	CharsetReader: func(charset string, input io.Reader) (io.Reader, error) {
+		data, err := io.ReadAll(input)
+		if err != nil {
+			return nil, err
+		}
+		return strings.NewReader(decodeCharset(charset, data)), nil
+	},
+}
+
+// decodeCharset turns text into UTF-8. Latin-1 is converted; other
+// charsets are kept as far as they are valid UTF-8.
+func decodeCharset(charset string, data []byte) string {
+	switch strings.ToLower(charset) {
+	case "iso-8859-1", "latin1", "windows-1252":
+		runes := make([]rune, len(data))
+		for i, b := range data {
+			runes[i] = rune(b)
+		}
+		return string(runes)
+	}
+	return strings.ToValidUTF8(string(data), "�")
+}
+
+func messageIDs(header string) []string {
+	var ids []string
+	for _, f := range strings.Fields(header) {
+		if id := strings.Trim(f, "<>,"); id != "" {
+			ids = append(ids, id)
+		}
+	}
+	return ids
+}
+
+// parseMail reads a raw RFC 5322 message with its MIME parts.
+func parseMail(r io.Reader) (*inboundMail, error) {
+	msg, err := mail.ReadMessage(r)
+	if err != nil {
+		return nil, err
+	}
+	h := msg.Header
+	m := &inboundMail{}
+	if ids := messageIDs(h.Get("Message-Id")); len(ids) > 0 {
+		m.MessageID = ids[0]
+	}
+	m.Refs = messageIDs(h.Get("In-Reply-To"))
+	refs := messageIDs(h.Get("References"))
+	for i := len(refs) - 1; i >= 0; i-- {
+		m.Refs = append(m.Refs, refs[i])
+	}
+	if list, err := h.AddressList("From"); err == nil && len(list) > 0 {
+		m.From = list[0]
+	}
+	if m.Subject, err = wordDecoder.DecodeHeader(h.Get("Subject")); err != nil {
+		m.Subject = h.Get("Subject")
+	}
+	if m.Date, err = h.Date(); err != nil {
+		m.Date = time.Now()
+	}
+	for _, key := range []string{"To", "Cc", "Delivered-To", "X-Original-To", "Envelope-To"} {
+		if list, err := h.AddressList(key); err == nil {
+			for _, a := range list {
+				m.Recipients = append(m.Recipients, a.Address)
+			}
+		}
+	}
+	err = m.walk(h.Get("Content-Type"), h.Get("Content-Disposition"), h.Get("Content-Transfer-Encoding"), msg.Body, 0)
+	return m, err
+}
+
+// walk collects the text bodies and attachments of a part and its children.
+func (m *inboundMail) walk(contentType, disposition, encoding string, body io.Reader, depth int) error {
+	if depth > 10 {
+		return errors.New("message nests too deep")
+	}
+	mediaType, params, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		mediaType, params = "text/plain", map[string]string{}
+	}
+	if strings.HasPrefix(mediaType, "multipart/") {
+		mr := multipart.NewReader(body, params["boundary"])
+		for {
+			part, err := mr.NextPart()
+			if err == io.EOF {
+				return nil
+			}
+			if err != nil {
+				return err
+			}
+			// NextPart already undoes quoted-printable
+			if err := m.walk(part.Header.Get("Content-Type"), part.Header.Get("Content-Disposition"),
+				part.Header.Get("Content-Transfer-Encoding"), part, depth+1); err != nil {
+				return err
+			}
+		}
+	}
+
+	switch strings.ToLower(strings.TrimSpace(encoding)) {
+	case "base64":
+		body = base64.NewDecoder(base64.StdEncoding, body)
+	case "quoted-printable":
+		body = quotedprintable.NewReader(body)
+	}
+	data, err := io.ReadAll(body)
+	if err != nil {
+		return err
+	}
+
+	disp, dparams, _ := mime.ParseMediaType(disposition)
+	name := dparams["filename"]
+	if name == "" {
+		name = params["name"]
+	}
+	if decoded, err := wordDecoder.DecodeHeader(name); err == nil {
+		name = decoded
+	}
+	isText := mediaType == "text/plain" || mediaType == "text/html"
+	if disp == "attachment" || !isText {
+		if name == "" {
+			name = "attachment"
+			if exts, _ := mime.ExtensionsByType(mediaType); len(exts) > 0 {
+				name += exts[0]
+			}
+		}
+		m.Files = append(m.Files, mailFile{Name: name, Type: mediaType, Data: data})
+		return nil
+	}
+	text := decodeCharset(params["charset"], data)
+	switch {
+	case mediaType == "text/html" && m.HTML == "":
+		m.HTML = text
+	case mediaType == "text/plain" && m.Text == "":
+		m.Text = text
+	}
+	return nil
+}
+
+var (
+	htmlBreakRe  = regexp.MustCompile(`(?i)<br\s*/?>|</p>|</div>|</li>|</tr>|</h[1-6]>`)
+	htmlTagRe    = regexp.MustCompile(`(?s)<[^>]*>`)
+	htmlDropRe   = regexp.MustCompile(`(?is)<(style|script|head)[^>]*>.*?</(style|script|head)>`)
+	blankLinesRe = regexp.MustCompile(`\n{3,}`)
+	replyLeadRe  = regexp.MustCompile(`(?i)^(on .+ wrote:|-+\s*original message\s*-+|from: .+)$`)
+	subjectRe    = regexp.MustCompile(`(?i)^\s*((re|fwd?|aw|wg)\s*:\s*)+`)
+)
+
+// body returns the plain text of the message, converting HTML when that
+// is all there is.
+func (m *inboundMail) body() string {
+	text := m.Text
+	if strings.TrimSpace(text) == "" && m.HTML != "" {
+		text = htmlDropRe.ReplaceAllString(m.HTML, "")
+		text = htmlBreakRe.ReplaceAllString(text, "\n")
+		text = html.UnescapeString(htmlTagRe.ReplaceAllString(text, ""))
+	}
+	text = strings.ReplaceAll(text, "\r\n", "\n")
+	return strings.TrimSpace(blankLinesRe.ReplaceAllString(text, "\n\n"))
+}
+
+// stripQuoted cuts the quoted original off a reply: everything from an
+// "On ... wrote:" line or an "Original Message" divider, and ">" lines.
+func stripQuoted(text string) string {
+	var kept []string
+	for _, line := range strings.Split(text, "\n") {
+		trimmed := strings.TrimSpace(line)
+		if replyLeadRe.MatchString(trimmed) {
+			break
+		}
+		if strings.HasPrefix(trimmed, ">") {
+			continue
+		}
+		kept = append(kept, line)
+	}
+	return strings.TrimSpace(strings.Join(kept, "\n"))
+}
+
+// cardTitle is the subject without reply and forward prefixes.
+func cardTitle(subject string) string {
+	title := strings.TrimSpace(subjectRe.ReplaceAllString(subject, ""))
+	if title == "" {
+		return "(no subject)"
+	}
+	return title
+}
+
+func registerInboundMailRoutes(router *gin.Engine, db *mongo.Database, changes *changeLog) {
+	inboxColl := db.Collection("mail_inboxes")
+	threadColl := db.Collection("mail_threads")
+	cardColl := db.Collection("cards")
+	statusColl := db.Collection("statuses")
+	attachColl := db.Collection("attachments")
+	commentColl := db.Collection("comments")
+	usersColl := db.Collection("users")
+	_, _ = inboxColl.Indexes().CreateOne(context.TODO(), mongo.IndexModel{Keys: bson.D{{Key: "boardId", Value: 1}}})
+
+	loadInbox := func(c *gin.Context) (*Inbox, bool) {
+		var in Inbox
+		if err := inboxColl.FindOne(context.TODO(), bson.M{"boardId": c.Param("id")}).Decode(&in); err != nil {
+			c.JSON(http.StatusNotFound, gin.H{"error": "board has no mail address"})
+			return nil, false
+		}
+		in.fillAddress()
+		return &in, true
+	}
+
+	// --- INBOX SETTINGS ---
+	router.GET("/api/boards/:id/inbox", func(c *gin.Context) {
+		if in, ok := loadInbox(c); ok {
+			c.JSON(http.StatusOK, in)
+		}
+	})
+
+	// PUT /api/boards/:id/inbox {"statusId"} gives the board an address, or
+	// changes the column new mail goes to. statusId defaults to the first
+	// column.
+	router.PUT("/api/boards/:id/inbox", func(c *gin.Context) {
+		var body struct {
+			StatusID string `json:"statusId"`
+		}
+		if err := c.ShouldBindJSON(&body); err != nil && err != io.EOF {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		boardID := c.Param("id")
+		if n, _ := db.Collection("boards").CountDocuments(context.TODO(), bson.M{"_id": boardID, "deletedAt": nil}); n == 0 {
+			c.JSON(http.StatusNotFound, gin.H{"error": "board not found"})
+			return
+		}
+		filter := bson.M{"boardId": boardID, "deletedAt": nil, "archivedAt": nil}
+		if body.StatusID != "" {
+			filter["_id"] = body.StatusID
+		}
+		var st Status
+		if err := statusColl.FindOne(context.TODO(), filter).Decode(&st); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "statusId must be a column of this board"})
+			return
+		}
+		now := time.Now()
+		var in Inbox
+		if err := inboxColl.FindOne(context.TODO(), bson.M{"boardId": boardID}).Decode(&in); err != nil {
+			in = Inbox{ID: newInboxKey(), BoardID: boardID, CreatedAt: now}
+		}
+		in.StatusID, in.UpdatedAt = st.ID, now
+		if _, err := inboxColl.ReplaceOne(context.TODO(), bson.M{"_id": in.ID}, in, options.Replace().SetUpsert(true)); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		in.fillAddress()
+		c.JSON(http.StatusOK, in)
+	})
+
+	// POST /api/boards/:id/inbox/rotate replaces the address; mail to the old
+	// one bounces, replies to earlier mail still thread.
+	router.POST("/api/boards/:id/inbox/rotate", func(c *gin.Context) {
+		in, ok := loadInbox(c)
+		if !ok {
+			return
+		}
+		old := in.ID
+		in.ID, in.UpdatedAt = newInboxKey(), time.Now()
+		if _, err := inboxColl.InsertOne(context.TODO(), in); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		_, _ = inboxColl.DeleteOne(context.TODO(), bson.M{"_id": old})
+		in.fillAddress()
+		c.JSON(http.StatusOK, in)
+	})
+
+	router.DELETE("/api/boards/:id/inbox", func(c *gin.Context) {
+		res, err := inboxColl.DeleteMany(context.TODO(), bson.M{"boardId": c.Param("id")})
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		if res.DeletedCount == 0 {
+			c.JSON(http.StatusNotFound, gin.H{"error": "board has no mail address"})
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{"status": "deleted"})
+	})
+
+	// --- INBOUND MAIL ---
+	// POST /api/inbound-mail?to= — the raw MIME message as the body, posted
+	// by the mail gateway with the X-Inbound-Token header set to
+	// INBOUND_MAIL_TOKEN. ?to= is the envelope recipient when the gateway
+	// knows it; otherwise To, Cc and Delivered-To are searched for a board
+	// address. A reply to a message we have seen becomes a comment on its
+	// card; anything else a new card. From is only a claim, so the content
+	// is attributed to the sender as text; it is posted as the user with
+	// that address only when the gateway vouches for it in
+	// X-Inbound-Verified-Sender (after SPF/DKIM checks, say).
+	router.POST("/api/inbound-mail", func(c *gin.Context) {
+		token := os.Getenv("INBOUND_MAIL_TOKEN")
+		if token == "" {
+			c.JSON(http.StatusServiceUnavailable, gin.H{"error": "inbound mail is not configured"})
+			return
+		}
+		if subtle.ConstantTimeCompare([]byte(c.GetHeader("X-Inbound-Token")), []byte(token)) != 1 {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "bad inbound token"})
+			return
+		}
+		raw, err := io.ReadAll(http.MaxBytesReader(c.Writer, c.Request.Body, maxInboundMail))
+		if err != nil {
+			c.JSON(http.StatusRequestEntityTooLarge, gin.H{"error": fmt.Sprintf("message is larger than %d MB", maxInboundMail>>20)})
+			return
+		}
+		m, err := parseMail(bytes.NewReader(raw))
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "unreadable message: " + err.Error()})
+			return
+		}
+		ctx := context.TODO()
+
+		// claim the Message-ID before anything is created, so a message
+		// posted twice, even at once, is handled once; the claim is given
+		// back if nothing comes of it
+		release := func() {}
+		if m.MessageID != "" {
+			if _, err := threadColl.InsertOne(ctx, mailThread{ID: m.MessageID, CreatedAt: time.Now()}); err != nil {
+				if mongo.IsDuplicateKeyError(err) {
+					c.JSON(http.StatusOK, gin.H{"status": "duplicate"})
+				} else {
+					c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+				}
+				return
+			}
+			release = func() { _, _ = threadColl.DeleteOne(ctx, bson.M{"_id": m.MessageID}) }
+		}
+
+		// the sender, when the gateway verified them and they have an account
+		userID := ""
+		sender := "unknown sender"
+		if m.From != nil {
+			sender = m.From.Address
+			if m.From.Name != "" {
+				sender = fmt.Sprintf("%s <%s>", m.From.Name, m.From.Address)
+			}
+		}
+		if verified := strings.TrimSpace(c.GetHeader("X-Inbound-Verified-Sender")); verified != "" && m.From != nil && strings.EqualFold(verified, m.From.Address) {
+			var u models.User
+			pattern := "^" + regexp.QuoteMeta(m.From.Address) + "$"
+			if err := usersColl.FindOne(ctx, bson.M{"email": bson.M{"$regex": pattern, "$options": "i"}}).Decode(&u); err == nil {
+				userID = u.ID.Hex()
+			}
+		}
+
+		var card Card
+		var thread mailThread
+		replied := false
+		for _, ref := range m.Refs {
+			if threadColl.FindOne(ctx, bson.M{"_id": ref}).Decode(&thread) == nil &&
+				cardColl.FindOne(ctx, bson.M{"_id": thread.CardID, "deletedAt": nil}).Decode(&card) == nil {
+				replied = true
+				break
+			}
+		}
+
+		var inbox Inbox
+		if !replied {
+			recipients := append(c.QueryArray("to"), m.Recipients...)
+			found := false
+			for _, addr := range recipients {
+				local := strings.ToLower(addr)
+				if at := strings.LastIndex(local, "@"); at >= 0 {
+					local = local[:at]
+				}
+				if inboxColl.FindOne(ctx, bson.M{"_id": local}).Decode(&inbox) == nil {
+					found = true
+					break
+				}
+			}
+			if !found {
+				release()
+				c.JSON(http.StatusNotFound, gin.H{"error": "no board has this address"})
+				return
+			}
+		}
+
+		out := gin.H{}
+		if replied {
+			text := stripQuoted(m.body())
+			if userID == "" {
+				text = importedComment(sender, m.Date, text)
+			}
+			now := time.Now()
+			cm := Comment{ID: newID(), CardID: card.ID, BoardID: card.BoardID, AuthorID: userID, Body: text, CreatedAt: now, UpdatedAt: now}
+			if _, err := commentColl.InsertOne(ctx, cm); err != nil {
+				release()
+				c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+				return
+			}
+			out["status"], out["commentId"] = "commented", cm.ID
+		} else {
+			description := m.body()
+			if userID == "" {
+				description = fmt.Sprintf("*From %s*\n\n%s", sender, description)
+			}
+			now := time.Now()
+			card = Card{
+				ID:          newID(),
+				BoardID:     inbox.BoardID,
+				StatusID:    inbox.StatusID,
+				Title:       cardTitle(m.Subject),
+				Description: description,
+				CreatedBy:   userID,
+				CreatedAt:   now,
+				UpdatedAt:   now,
+			}
+			if err := createCardFields(ctx, db, &card); err != nil {
+				// mail can't fill in fields: the board must not require any
+				release()
+				c.JSON(http.StatusUnprocessableEntity, gin.H{"error": "board needs card fields mail can't give: " + err.Error()})
+				return
+			}
+			if _, err := cardColl.InsertOne(ctx, card); err != nil {
+				release()
+				c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+				return
+			}
+			changes.card(userID, nil, &card)
+			out["status"] = "created"
+		}
+		out["cardId"] = card.ID
+		if m.MessageID != "" {
+			_, _ = threadColl.UpdateOne(ctx, bson.M{"_id": m.MessageID}, bson.M{"$set": bson.M{"cardId": card.ID, "boardId": card.BoardID}})
+		}
+
+		// the message is handled once the card or comment exists: a retry
+		// would be a duplicate, so files that can't be kept are listed in
+		// the answer instead of failing it
+		var diff []ActivityChange
+		failed := []gin.H{}
+		for _, f := range m.Files {
+			att, err := saveFile(f.Name, f.Type, f.Data, userID)
+			if err != nil {
+				failed = append(failed, gin.H{"name": f.Name, "error": err.Error()})
+				continue
+			}
+			att.CardID, att.BoardID = card.ID, card.BoardID
+			if _, err := attachColl.InsertOne(ctx, att); err != nil {
+				_ = os.Remove(filepath.Join(uploadDir, path.Base(att.URL)))
+				failed = append(failed, gin.H{"name": f.Name, "error": err.Error()})
+				continue
+			}
+			diff = append(diff, ActivityChange{Field: "attachment", New: att.Name})
+		}
+		changes.cardAction(userID, &card, ActionUpdated, diff...)
+		out["attachments"] = len(diff)
+		if len(failed) > 0 {
+			out["failedAttachments"] = failed
+		}
+		c.JSON(http.StatusOK, out)
+	})
+}
@@ -2,58 +2,225 @@ package kanban
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"log"
 	"net/http"
 	"os"
 	"path/filepath"
+	"reflect"
+	"strconv"
+	"strings"
+	"sync/atomic"
 	"time"
 
+	"bell-backend/webhooks"
+
 	"github.com/gin-gonic/gin"
 	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
 )
 
 // === Models ===
 // Простые структуры: ID — string (unix nano), чтобы фронт мог работать с ними легко.
 type Board struct {
-	ID        string    `bson:"_id,omitempty" json:"_id"`
-	Name      string    `bson:"name" json:"name"`
-	CreatedAt time.Time `bson:"createdAt" json:"createdAt"`
-	UpdatedAt time.Time `bson:"updatedAt" json:"updatedAt"`
+	ID            string        `bson:"_id,omitempty" json:"_id"`
+	Name          string        `bson:"name" json:"name"`
+	BlockedPolicy string        `bson:"blockedPolicy,omitempty" json:"blockedPolicy,omitempty"` // moving blocked cards to done: "", "warn", "refuse"
+	LaneMode      string        `bson:"laneMode,omitempty" json:"laneMode,omitempty"`           // see swimlanes.go
+	DefaultViewID string        `bson:"defaultViewId,omitempty" json:"defaultViewId,omitempty"` // shared view the board opens with
+	Lanes         []Swimlane    `bson:"lanes,omitempty" json:"lanes,omitempty"`                 // manual lanes
+	Fields        []CustomField `bson:"fields,omitempty" json:"fields,omitempty"`               // custom card fields, see fields.go
+	ArchivedAt    *time.Time    `bson:"archivedAt,omitempty" json:"archivedAt,omitempty"`
+	DeletedAt     *time.Time    `bson:"deletedAt,omitempty" json:"deletedAt,omitempty"` // in trash
+	Version       int64         `bson:"version,omitempty" json:"version"`               // see version.go
+	CreatedAt     time.Time     `bson:"createdAt" json:"createdAt"`
+	UpdatedAt     time.Time     `bson:"updatedAt" json:"updatedAt"`
 }
 
 type Status struct {
-	ID        string    `bson:"_id,omitempty" json:"_id"`
-	Name      string    `bson:"name" json:"name"`
-	BoardID   string    `bson:"boardId" json:"boardId"`
-	CreatedAt time.Time `bson:"createdAt" json:"createdAt"`
-	UpdatedAt time.Time `bson:"updatedAt" json:"updatedAt"`
+	ID          string     `bson:"_id,omitempty" json:"_id"`
+	Name        string     `bson:"name" json:"name"`
+	BoardID     string     `bson:"boardId" json:"boardId"`
+	Category    string     `bson:"category,omitempty" json:"category,omitempty"` // todo / in_progress / done
+	ArchivedAt  *time.Time `bson:"archivedAt,omitempty" json:"archivedAt,omitempty"`
+	DeletedAt   *time.Time `bson:"deletedAt,omitempty" json:"deletedAt,omitempty"`
+	DeletedWith string     `bson:"deletedWith,omitempty" json:"deletedWith,omitempty"` // board id when trashed with its board
+	CreatedAt   time.Time  `bson:"createdAt" json:"createdAt"`
+	UpdatedAt   time.Time  `bson:"updatedAt" json:"updatedAt"`
 }
 
 type Card struct {
-	ID          string    `bson:"_id,omitempty" json:"_id"`
-	BoardID     string    `bson:"boardId" json:"boardId"`
-	StatusID    string    `bson:"statusId" json:"statusId"`
-	Title       string    `bson:"title" json:"title"`
-	Description string    `bson:"description" json:"description"`
-	Color       string    `bson:"color" json:"color"`
-	Image       string    `bson:"image,omitempty" json:"image,omitempty"` // "/uploads/..."
-	Tags        []string  `bson:"tags,omitempty" json:"tags,omitempty"`
-	DueDate     string    `bson:"dueDate,omitempty" json:"dueDate,omitempty"` // RFC3339 string or empty
-	CreatedAt   time.Time `bson:"createdAt" json:"createdAt"`
-	UpdatedAt   time.Time `bson:"updatedAt" json:"updatedAt"`
+	ID          string   `bson:"_id,omitempty" json:"_id"`
+	BoardID     string   `bson:"boardId" json:"boardId"`
+	StatusID    string   `bson:"statusId" json:"statusId"`
+	LaneID      string   `bson:"laneId,omitempty" json:"laneId,omitempty"`     // manual swimlane
+	Position    float64  `bson:"position,omitempty" json:"position,omitempty"` // order inside a column
+	Title       string   `bson:"title" json:"title"`
+	Description string   `bson:"description" json:"description"`
+	Color       string   `bson:"color" json:"color"`
+	Image       string   `bson:"image,omitempty" json:"image,omitempty"`   // "/uploads/..."
+	Tags        []string `bson:"tags,omitempty" json:"tags,omitempty"`     // names of Labels, kept by the server
+	Labels      []string `bson:"labels,omitempty" json:"labels,omitempty"` // label ids, see labels.go
+	Priority    string   `bson:"priority,omitempty" json:"priority,omitempty"`
+	ParentID    string   `bson:"parentId,omitempty" json:"parentId,omitempty"`   // set on cards converted from a checklist item
+	Assignees   []string `bson:"assignees,omitempty" json:"assignees,omitempty"` // user ids
+	SprintID    string   `bson:"sprintId,omitempty" json:"sprintId,omitempty"`   // see sprints.go
+	Points      float64  `bson:"points,omitempty" json:"points,omitempty"`       // estimate
+	// Custom field values by field id, see fields.go.
+	Fields map[string]interface{} `bson:"fields,omitempty" json:"fields,omitempty"`
+	// Set on cards created by a recurrence, see recurrence.go.
+	RecurrenceID string     `bson:"recurrenceId,omitempty" json:"recurrenceId,omitempty"`
+	Occurrence   *time.Time `bson:"occurrence,omitempty" json:"occurrence,omitempty"`
+	CreatedBy    string     `bson:"createdBy,omitempty" json:"createdBy,omitempty"`
+	CreatedAt    time.Time  `bson:"createdAt" json:"createdAt"`
+	UpdatedAt    time.Time  `bson:"updatedAt" json:"updatedAt"`
+	Version      int64      `bson:"version,omitempty" json:"version"` // see version.go
+
+	// Dates. JSON accepts RFC3339 or YYYY-MM-DD; Reminders are minutes
+	// before DueDate at which assignees get a "due soon" notification.
+	StartDate       *time.Time `bson:"startDate,omitempty" json:"startDate,omitempty"`
+	DueDate         *time.Time `bson:"dueDate,omitempty" json:"dueDate,omitempty"`
+	Reminders       []int      `bson:"reminders,omitempty" json:"reminders,omitempty"`
+	RemindersSent   []int      `bson:"remindersSent,omitempty" json:"-"`
+	OverdueNotified bool       `bson:"overdueNotified,omitempty" json:"-"`
+
+	// Archive and trash state, changed through the archive/restore endpoints.
+	ArchivedAt      *time.Time `bson:"archivedAt,omitempty" json:"archivedAt,omitempty"`
+	DeletedAt       *time.Time `bson:"deletedAt,omitempty" json:"deletedAt,omitempty"`
+	DeletedWith     string     `bson:"deletedWith,omitempty" json:"deletedWith,omitempty"`         // board id when trashed with its board
+	TrashedStatusID string     `bson:"trashedStatusId,omitempty" json:"trashedStatusId,omitempty"` // column to go back to when it is restored
+
+	// Checklists are edited through /api/cards/:id/checklists only.
+	Checklists []Checklist        `bson:"checklists,omitempty" json:"checklists,omitempty"`
+	Progress   *ChecklistProgress `bson:"-" json:"progress,omitempty"`
+	Warnings   []string           `bson:"-" json:"warnings,omitempty"`
+
+	cleared     []string // clearable fields a PUT body sent empty, see UnmarshalJSON
+	versionSent bool     // the body had "version"
+}
+
+var lastID int64
+
+// newID returns a unix-nano based id. Calls landing in the same nanosecond are
+// bumped forward so ids stay unique and sortable within one process.
+func newID() string {
+	for {
+		now := time.Now().UnixNano()
+		last := atomic.LoadInt64(&lastID)
+		if now <= last {
+			now = last + 1
+		}
+		if atomic.CompareAndSwapInt64(&lastID, last, now) {
+			return strconv.FormatInt(now, 10)
+		}
+	}
+}
+
+// cardQuery builds the filter and options of a card listing from the query
+// string: ?boardId=, ?tag=, the due date filters, ?q= (see query.go),
+// ?view= (a saved view, see views.go), ?field.<name>= (see fields.go),
+// ?archived= and ?sort= (dueDate, position or field.<name>; a leading "-"
+// sorts descending).
+func cardQuery(c *gin.Context, db *mongo.Database) (bson.M, *options.FindOptions, error) {
+	boardId := c.Query("boardId")
+	tag := c.Query("tag") // optional
+	sort := c.Query("sort")
+	filter := bson.M{}
+	var and []bson.M
+	if id := c.Query("view"); id != "" {
+		view, err := loadView(context.TODO(), db, id, currentUserID(c))
+		if err != nil {
+			return nil, nil, err
+		}
+		if boardId == "" {
+			boardId = view.BoardID
+		}
+		if sort == "" {
+			sort = view.Sort
+		}
+		if view.Query != "" {
+			compiled, err := compileQuery(context.TODO(), db, view.Query, currentUserID(c))
+			if err != nil {
+				return nil, nil, err
+			}
+			and = append(and, compiled)
+		}
+	}
+	if boardId != "" {
+		filter["boardId"] = boardId
+	}
+	if tag != "" {
+		// matched by label, so "#Bug" finds cards labelled "bug"
+		ids, err := labelIDs(context.TODO(), db, boardId, []string{tag})
+		if err != nil {
+			return nil, nil, err
+		}
+		filter["labels"] = bson.M{"$in": ids}
+	}
+	if err := applyDueFilters(c, db, filter); err != nil {
+		return nil, nil, err
+	}
+	if q := strings.TrimSpace(c.Query("q")); q != "" {
+		compiled, err := compileQuery(context.TODO(), db, q, currentUserID(c))
+		if err != nil {
+			return nil, nil, err
+		}
+		and = append(and, compiled)
+	}
+	dir := 1
+	if strings.HasPrefix(sort, "-") {
+		sort, dir = sort[1:], -1
+	}
+	var fieldSort string
+	if strings.HasPrefix(sort, "field.") || hasFieldFilters(c) {
+		if boardId == "" {
+			return nil, nil, errors.New("filtering or sorting on custom fields needs boardId")
+		}
+		b, err := loadFieldBoard(context.TODO(), db, boardId)
+		if err != nil {
+			return nil, nil, err
+		}
+		filters, err := fieldFilters(c, b)
+		if err != nil {
+			return nil, nil, err
+		}
+		and = append(and, filters...)
+		if strings.HasPrefix(sort, "field.") {
+			f := b.field(strings.TrimPrefix(sort, "field."))
+			if f == nil {
+				return nil, nil, fmt.Errorf("unknown custom field %q", strings.TrimPrefix(sort, "field."))
+			}
+			fieldSort = "fields." + f.ID
+		}
+	}
+	if len(and) > 0 {
+		filter["$and"] = and
+	}
+	opts := options.Find()
+	switch {
+	case sort == "dueDate":
+		opts.SetSort(bson.D{{Key: "dueDate", Value: dir}})
+	case sort == "position":
+		opts.SetSort(bson.D{{Key: "position", Value: dir}, {Key: "createdAt", Value: dir}})
+	case fieldSort != "":
+		opts.SetSort(bson.D{{Key: fieldSort, Value: dir}, {Key: "createdAt", Value: 1}})
+	}
+	return visible(c, filter), opts, nil
 }
 
 // RegisterKanbanRoutes registers all endpoints under /api/*
-// router: *gin.Engine, db: *mongo.Database
-func RegisterKanbanRoutes(router *gin.Engine, db *mongo.Database) {
+// router: *gin.Engine, db: *mongo.Database, hooks: outgoing webhooks (may be nil)
+func RegisterKanbanRoutes(router *gin.Engine, db *mongo.Database, hooks *webhooks.Dispatcher) {
 	boardColl := db.Collection("boards")
 	statusColl := db.Collection("statuses")
 	cardColl := db.Collection("cards")
+	hub := newEventHub(db)
+	hub.hooks = hooks
+	changes := newChangeLog(db, hub)
 
 	// Static uploads
-	uploadDir := "./uploads"
 	_ = os.MkdirAll(uploadDir, os.ModePerm)
 	router.Static("/uploads", uploadDir)
 
@@ -61,7 +228,8 @@ func RegisterKanbanRoutes(router *gin.Engine, db *mongo.Database) {
 	router.Use(func(c *gin.Context) {
 		c.Writer.Header().Set("Access-Control-Allow-Origin", "*")
 		c.Writer.Header().Set("Access-Control-Allow-Methods", "GET, POST, PUT, DELETE, OPTIONS")
-		c.Writer.Header().Set("Access-Control-Allow-Headers", "Content-Type, Authorization")
+		c.Writer.Header().Set("Access-Control-Allow-Headers", "Content-Type, Authorization, If-Match, If-None-Match")
+		c.Writer.Header().Set("Access-Control-Expose-Headers", "ETag")
 		if c.Request.Method == http.MethodOptions {
 			c.AbortWithStatus(http.StatusNoContent)
 			return
@@ -71,7 +239,7 @@ func RegisterKanbanRoutes(router *gin.Engine, db *mongo.Database) {
 
 	// --- BOARDS ---
 	router.GET("/api/boards", func(c *gin.Context) {
-		cur, err := boardColl.Find(context.TODO(), bson.M{})
+		cur, err := boardColl.Find(context.TODO(), visible(c, bson.M{}))
 		if err != nil {
 			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 			return
@@ -91,26 +259,113 @@ func RegisterKanbanRoutes(router *gin.Engine, db *mongo.Database) {
 			return
 		}
 		now := time.Now()
-		b.ID = fmt.Sprintf("%d", now.UnixNano())
+		b.ID = newID()
+		b.Version = 0
+		for i := range b.Lanes {
+			b.Lanes[i].ID = newID()
+			b.Lanes[i].Position = i
+		}
+		if err := checkFields(b.Fields); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		for i := range b.Fields {
+			b.Fields[i].ID = newID()
+			b.Fields[i].Position = i
+		}
 		b.CreatedAt = now
 		b.UpdatedAt = now
 		if _, err := boardColl.InsertOne(context.TODO(), b); err != nil {
 			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 			return
 		}
+		hub.emit(b.ID, EventBoardCreated, currentUserID(c), b)
 		c.JSON(http.StatusOK, b)
 	})
 
+	// PUT /api/boards/:id — conditional like PUT /api/cards/:id.
+	router.PUT("/api/boards/:id", func(c *gin.Context) {
+		id := c.Param("id")
+		var in struct {
+			Name          *string `json:"name"`
+			BlockedPolicy *string `json:"blockedPolicy"`
+			DefaultViewID *string `json:"defaultViewId"`
+			Version       *int64  `json:"version"`
+		}
+		if err := c.BindJSON(&in); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		pre, ok := writePrecondition(c, in.Version)
+		if !ok {
+			return
+		}
+		set := bson.M{"updatedAt": time.Now()}
+		if in.Name != nil {
+			set["name"] = *in.Name
+		}
+		if in.BlockedPolicy != nil {
+			switch *in.BlockedPolicy {
+			case BlockedAllow, BlockedWarn, BlockedRefuse:
+				set["blockedPolicy"] = *in.BlockedPolicy
+			default:
+				c.JSON(http.StatusBadRequest, gin.H{"error": "blockedPolicy must be \"\", \"warn\" or \"refuse\""})
+				return
+			}
+		}
+		update := bson.M{"$set": set}
+		if in.DefaultViewID != nil {
+			if *in.DefaultViewID == "" {
+				update["$unset"] = bson.M{"defaultViewId": ""}
+			} else {
+				view, err := loadView(context.TODO(), db, *in.DefaultViewID, currentUserID(c))
+				if err != nil || !view.Shared || (view.BoardID != "" && view.BoardID != id) {
+					c.JSON(http.StatusBadRequest, gin.H{"error": "defaultViewId must be a shared view of this board"})
+					return
+				}
+				set["defaultViewId"] = view.ID
+			}
+		}
+		res, err := boardColl.UpdateOne(context.TODO(), pre.filter(bson.M{"_id": id, "deletedAt": nil}), withVersion(update))
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		var out Board
+		if res.MatchedCount == 0 {
+			if err := boardColl.FindOne(context.TODO(), bson.M{"_id": id, "deletedAt": nil}).Decode(&out); err != nil {
+				c.JSON(http.StatusNotFound, gin.H{"error": "not found"})
+				return
+			}
+			pre.fail(c, out.Version, out)
+			return
+		}
+		_ = boardColl.FindOne(context.TODO(), bson.M{"_id": id}).Decode(&out)
+		hub.emit(id, EventBoardUpdated, currentUserID(c), out)
+		setETag(c, out.Version)
+		c.JSON(http.StatusOK, out)
+	})
+
+	// Deleting a board moves it to the trash together with its columns and
+	// cards; POST /api/boards/:id/restore brings them all back.
 	router.DELETE("/api/boards/:id", func(c *gin.Context) {
 		id := c.Param("id")
-		// optionally: delete statuses/cards referencing this board
-		if _, err := boardColl.DeleteOne(context.TODO(), bson.M{"_id": id}); err != nil {
+		now := time.Now()
+		res, err := boardColl.UpdateOne(context.TODO(), bson.M{"_id": id, "deletedAt": nil},
+			withVersion(bson.M{"$set": bson.M{"deletedAt": now}}))
+		if err != nil {
 			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 			return
 		}
-		// cascade: delete statuses and cards for that board
-		_, _ = statusColl.DeleteMany(context.TODO(), bson.M{"boardId": id})
-		_, _ = cardColl.DeleteMany(context.TODO(), bson.M{"boardId": id})
+		if res.MatchedCount == 0 {
+			c.JSON(http.StatusNotFound, gin.H{"error": "not found"})
+			return
+		}
+		// cascade: trash statuses and cards for that board
+		cascade := bson.M{"$set": bson.M{"deletedAt": now, "deletedWith": id}}
+		_, _ = statusColl.UpdateMany(context.TODO(), bson.M{"boardId": id, "deletedAt": nil}, cascade)
+		_, _ = cardColl.UpdateMany(context.TODO(), bson.M{"boardId": id, "deletedAt": nil}, withVersion(cascade))
+		hub.emit(id, EventBoardDeleted, currentUserID(c), gin.H{"_id": id})
 		c.JSON(http.StatusOK, gin.H{"status": "deleted"})
 	})
 
@@ -121,7 +376,7 @@ func RegisterKanbanRoutes(router *gin.Engine, db *mongo.Database) {
 		if boardId != "" {
 			filter["boardId"] = boardId
 		}
-		cur, err := statusColl.Find(context.TODO(), filter)
+		cur, err := statusColl.Find(context.TODO(), visible(c, filter))
 		if err != nil {
 			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 			return
@@ -140,41 +395,95 @@ func RegisterKanbanRoutes(router *gin.Engine, db *mongo.Database) {
 			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 			return
 		}
+		if !validCategory(s.Category) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "unknown status category"})
+			return
+		}
 		now := time.Now()
-		s.ID = fmt.Sprintf("%d", now.UnixNano())
+		s.ID = newID()
 		s.CreatedAt = now
 		s.UpdatedAt = now
 		if _, err := statusColl.InsertOne(context.TODO(), s); err != nil {
 			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 			return
 		}
+		hub.emit(s.BoardID, EventStatusCreated, currentUserID(c), s)
 		c.JSON(http.StatusOK, s)
 	})
 
+	router.PUT("/api/statuses/:id", func(c *gin.Context) {
+		id := c.Param("id")
+		var in struct {
+			Name     *string `json:"name"`
+			Category *string `json:"category"`
+		}
+		if err := c.BindJSON(&in); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		set := bson.M{"updatedAt": time.Now()}
+		if in.Name != nil {
+			set["name"] = *in.Name
+		}
+		if in.Category != nil {
+			if !validCategory(*in.Category) {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "unknown status category"})
+				return
+			}
+			set["category"] = *in.Category
+		}
+		res, err := statusColl.UpdateOne(context.TODO(), bson.M{"_id": id, "deletedAt": nil}, bson.M{"$set": set})
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		if res.MatchedCount == 0 {
+			c.JSON(http.StatusNotFound, gin.H{"error": "not found"})
+			return
+		}
+		var out Status
+		_ = statusColl.FindOne(context.TODO(), bson.M{"_id": id}).Decode(&out)
+		hub.emit(out.BoardID, EventStatusUpdated, currentUserID(c), out)
+		c.JSON(http.StatusOK, out)
+	})
+
 	router.DELETE("/api/statuses/:id", func(c *gin.Context) {
 		id := c.Param("id")
-		if _, err := statusColl.DeleteOne(context.TODO(), bson.M{"_id": id}); err != nil {
+		var st Status
+		if err := statusColl.FindOne(context.TODO(), bson.M{"_id": id, "deletedAt": nil}).Decode(&st); err != nil {
+			c.JSON(http.StatusNotFound, gin.H{"error": "not found"})
+			return
+		}
+		if _, err := statusColl.UpdateOne(context.TODO(), bson.M{"_id": id},
+			bson.M{"$set": bson.M{"deletedAt": time.Now()}}); err != nil {
 			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 			return
 		}
-		// optional: set cards with this status to empty statusId or delete them — here we set statusId=""
-		_, _ = cardColl.UpdateMany(context.TODO(), bson.M{"statusId": id}, bson.M{"$set": bson.M{"statusId": ""}})
+		hub.emit(st.BoardID, EventStatusDeleted, currentUserID(c), gin.H{"_id": id})
+		// cards of the column get statusId="" and remember it for a restore
+		var orphaned []Card
+		if cur, err := cardColl.Find(context.TODO(), bson.M{"statusId": id, "deletedAt": nil}); err == nil {
+			_ = cur.All(context.TODO(), &orphaned)
+		}
+		_, _ = cardColl.UpdateMany(context.TODO(), bson.M{"statusId": id, "deletedAt": nil},
+			withVersion(bson.M{"$set": bson.M{"statusId": "", "trashedStatusId": id}}))
+		for i := range orphaned {
+			after := orphaned[i]
+			after.StatusID = ""
+			after.TrashedStatusID = id
+			changes.card(currentUserID(c), &orphaned[i], &after)
+		}
 		c.JSON(http.StatusOK, gin.H{"status": "deleted"})
 	})
 
 	// --- CARDS ---
 	router.GET("/api/cards", func(c *gin.Context) {
-		boardId := c.Query("boardId")
-		tag := c.Query("tag") // optional
-		filter := bson.M{}
-		if boardId != "" {
-			filter["boardId"] = boardId
-		}
-		if tag != "" {
-			// tag stored without leading '#', but we'll match either way
-			filter["tags"] = tag
+		filter, opts, err := cardQuery(c, db)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
 		}
-		cur, err := cardColl.Find(context.TODO(), filter)
+		cur, err := cardColl.Find(context.TODO(), filter, opts)
 		if err != nil {
 			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 			return
@@ -184,6 +493,9 @@ func RegisterKanbanRoutes(router *gin.Engine, db *mongo.Database) {
 			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 			return
 		}
+		for i := range out {
+			out[i].fillProgress()
+		}
 		c.JSON(http.StatusOK, out)
 	})
 
@@ -194,16 +506,61 @@ func RegisterKanbanRoutes(router *gin.Engine, db *mongo.Database) {
 			return
 		}
 		now := time.Now()
-		card.ID = fmt.Sprintf("%d", now.UnixNano())
+		card.ID = newID()
+		card.CreatedBy = currentUserID(c)
 		card.CreatedAt = now
 		card.UpdatedAt = now
+		for i := range card.Checklists {
+			card.Checklists[i].ID = newID()
+			for j := range card.Checklists[i].Items {
+				card.Checklists[i].Items[j].ID = newID()
+			}
+		}
+		if err := resolveCardLabels(context.TODO(), db, &card, len(card.Labels) == 0); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		if err := createCardFields(context.TODO(), db, &card); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		if err := checkCardSprint(context.TODO(), db, nil, &card); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		card.Version = 0
 		if _, err := cardColl.InsertOne(context.TODO(), card); err != nil {
 			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 			return
 		}
+		changes.card(currentUserID(c), nil, &card)
+		// rules set off by the change may have written the card already
+		_ = cardColl.FindOne(context.TODO(), bson.M{"_id": card.ID}).Decode(&card)
+		card.fillProgress()
+		setETag(c, card.Version)
+		c.JSON(http.StatusOK, card)
+	})
+
+	// GET /api/cards/:id — with the version as ETag; If-None-Match with the
+	// current one gets 304.
+	router.GET("/api/cards/:id", func(c *gin.Context) {
+		var card Card
+		if err := cardColl.FindOne(context.TODO(), bson.M{"_id": c.Param("id")}).Decode(&card); err != nil {
+			c.JSON(http.StatusNotFound, gin.H{"error": "not found"})
+			return
+		}
+		setETag(c, card.Version)
+		if c.GetHeader("If-None-Match") == etag(card.Version) {
+			c.Status(http.StatusNotModified)
+			return
+		}
+		card.fillProgress()
 		c.JSON(http.StatusOK, card)
 	})
 
+	// PUT /api/cards/:id — with If-Match (or "version" in the body) the
+	// write only goes through if the card is still at that version, else
+	// the answer is 412 (409) with the current card.
 	router.PUT("/api/cards/:id", func(c *gin.Context) {
 		id := c.Param("id")
 		var update Card
@@ -211,50 +568,176 @@ func RegisterKanbanRoutes(router *gin.Engine, db *mongo.Database) {
 			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 			return
 		}
+		var sent *int64
+		if update.versionSent {
+			sent = &update.Version
+		}
+		pre, ok := writePrecondition(c, sent)
+		if !ok {
+			return
+		}
+		var before Card
+		if err := cardColl.FindOne(context.TODO(), bson.M{"_id": id, "deletedAt": nil}).Decode(&before); err != nil {
+			c.JSON(http.StatusNotFound, gin.H{"error": "not found"})
+			return
+		}
+		// stale: the client merges with the current card and tries again
+		stale := func() {
+			var current Card
+			_ = cardColl.FindOne(context.TODO(), bson.M{"_id": id}).Decode(&current)
+			current.fillProgress()
+			pre.fail(c, current.Version, current)
+		}
+		if !pre.holds(before.Version) {
+			stale()
+			return
+		}
+		warning, refuse := checkBlockedMove(context.TODO(), db, &before, update.StatusID)
+		if refuse {
+			c.JSON(http.StatusConflict, gin.H{"error": warning})
+			return
+		}
 		update.UpdatedAt = time.Now()
+		update.CreatedAt = before.CreatedAt
+		update.CreatedBy = before.CreatedBy
+		update.RemindersSent, update.OverdueNotified = nil, false
+		update.Checklists = nil
+		update.ArchivedAt, update.DeletedAt = nil, nil
+		update.DeletedWith, update.TrashedStatusID = "", ""
 		// Don't allow changing ID
-		_, err := cardColl.UpdateOne(context.TODO(), bson.M{"_id": id}, bson.M{"$set": update})
+		update.ID = ""
+		update.Version = 0 // bumped below
+		if err := updateCardLabels(context.TODO(), db, &before, &update); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		if err := updateCardFields(context.TODO(), db, &before, &update); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		if err := checkCardSprint(context.TODO(), db, &before, &update); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		set := bson.M{"$set": update}
+		if len(update.cleared) > 0 {
+			unset := bson.M{}
+			for _, field := range update.cleared {
+				unset[field] = ""
+			}
+			set["$unset"] = unset
+		}
+		res, err := cardColl.UpdateOne(context.TODO(), pre.filter(bson.M{"_id": id}), withVersion(set))
 		if err != nil {
 			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 			return
 		}
+		if res.MatchedCount == 0 {
+			stale()
+			return
+		}
 		// Return updated document
 		var out Card
 		_ = cardColl.FindOne(context.TODO(), bson.M{"_id": id}).Decode(&out)
+		if !reflect.DeepEqual(before.DueDate, out.DueDate) || !reflect.DeepEqual(before.Reminders, out.Reminders) {
+			// new schedule: reminders may fire again
+			_, _ = cardColl.UpdateOne(context.TODO(), bson.M{"_id": id},
+				bson.M{"$unset": bson.M{"remindersSent": "", "overdueNotified": ""}})
+		}
+		changes.card(currentUserID(c), &before, &out)
+		// as written by the rules the change set off, if any
+		_ = cardColl.FindOne(context.TODO(), bson.M{"_id": id}).Decode(&out)
+		out.fillProgress()
+		if warning != "" {
+			out.Warnings = append(out.Warnings, warning)
+		}
+		setETag(c, out.Version)
 		c.JSON(http.StatusOK, out)
 	})
 
+	// Deleting a card moves it to the trash.
 	router.DELETE("/api/cards/:id", func(c *gin.Context) {
 		id := c.Param("id")
-		res, err := cardColl.DeleteOne(context.TODO(), bson.M{"_id": id})
+		var before Card
+		if err := cardColl.FindOne(context.TODO(), bson.M{"_id": id, "deletedAt": nil}).Decode(&before); err != nil {
+			c.JSON(http.StatusNotFound, gin.H{"error": "not found"})
+			return
+		}
+		res, err := cardColl.UpdateOne(context.TODO(), bson.M{"_id": id, "deletedAt": nil},
+			withVersion(bson.M{"$set": bson.M{"deletedAt": time.Now()}}))
 		if err != nil {
 			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 			return
 		}
-		if res.DeletedCount == 0 {
+		if res.MatchedCount == 0 {
 			c.JSON(http.StatusNotFound, gin.H{"error": "not found"})
 			return
 		}
+		changes.card(currentUserID(c), &before, nil)
 		c.JSON(http.StatusOK, gin.H{"status": "deleted"})
 	})
 
-	// --- UPLOAD ---
-	router.POST("/api/upload", func(c *gin.Context) {
-		file, err := c.FormFile("file")
-		if err != nil {
-			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
-			return
-		}
-		// sanitize filename (use only base)
-		filename := fmt.Sprintf("%d_%s", time.Now().UnixNano(), filepath.Base(file.Filename))
-		savePath := filepath.Join(uploadDir, filename)
-		if err := c.SaveUploadedFile(file, savePath); err != nil {
-			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
-			return
-		}
-		// return relative path that frontend can prefix with server origin
-		c.JSON(http.StatusOK, gin.H{"url": "/uploads/" + filename})
-	})
+	// --- UPLOADS & ATTACHMENTS ---
+	registerAttachmentRoutes(router, db, changes)
+
+	// --- COMMENTS & NOTIFICATIONS ---
+	registerCommentRoutes(router, db)
+	registerNotificationRoutes(router, db)
+
+	// --- CHECKLISTS ---
+	registerChecklistRoutes(router, db, changes)
+
+	// --- ACTIVITY & LIVE EVENTS ---
+	registerActivityRoutes(router, db)
+	registerEventRoutes(router, hub)
+
+	// --- ARCHIVE & TRASH ---
+	registerTrashRoutes(router, db, changes)
+
+	// --- SWIMLANES ---
+	registerSwimlaneRoutes(router, db, changes)
+
+	// --- LINKS ---
+	registerLinkRoutes(router, db, changes)
+
+	// --- TEMPLATES & CLONING ---
+	registerTemplateRoutes(router, db, hub)
+
+	// --- EXPORT & IMPORT ---
+	registerExportRoutes(router, db, hub)
+	registerImporterRoutes(router, db, hub)
+	registerSpreadsheetRoutes(router, db)
+
+	// --- SEARCH ---
+	registerSearchRoutes(router, newMongoSearcher(db))
+
+	// --- SAVED VIEWS ---
+	registerViewRoutes(router, db)
+
+	// --- FLOW ANALYTICS ---
+	registerAnalyticsRoutes(router, db)
+
+	// --- SPRINTS ---
+	registerSprintRoutes(router, db, changes)
+
+	// --- RECURRING CARDS ---
+	registerRecurrenceRoutes(router, db)
+	startRecurrenceScheduler(db, changes)
+
+	// --- AUTOMATION ---
+	registerRuleRoutes(router, db, changes)
+
+	// --- INBOUND MAIL ---
+	registerInboundMailRoutes(router, db, changes)
+
+	// --- CUSTOM FIELDS ---
+	registerFieldRoutes(router, db, changes)
+
+	// --- LABELS ---
+	registerLabelRoutes(router, db, changes)
+
+	// --- DUE DATES ---
+	startDueScheduler(db)
 
 	// --- CLEANUP ---
 	router.GET("/api/cleanup", func(c *gin.Context) {
@@ -276,14 +759,15 @@ func RegisterKanbanRoutes(router *gin.Engine, db *mongo.Database) {
 	}()
 }
 
-// cleanupUploads deletes files in ./uploads that are not referenced by any card.image
+// cleanupUploads deletes files in ./uploads that are not referenced by any
+// card.image or card attachment, along with their upload records.
 func cleanupUploads(db *mongo.Database) int {
-	uploadDir := "./uploads"
 	files, err := os.ReadDir(uploadDir)
 	if err != nil {
 		return 0
 	}
 	cardColl := db.Collection("cards")
+	attachColl := db.Collection("attachments")
 	removed := 0
 	for _, f := range files {
 		if f.IsDir() {
@@ -291,13 +775,16 @@ func cleanupUploads(db *mongo.Database) int {
 		}
 		path := "/uploads/" + f.Name()
 		cnt, err := cardColl.CountDocuments(context.TODO(), bson.M{"image": path})
-		if err != nil {
+		if err != nil || cnt > 0 {
 			continue
 		}
-		if cnt == 0 {
-			_ = os.Remove(filepath.Join(uploadDir, f.Name()))
-			removed++
+		cnt, err = attachColl.CountDocuments(context.TODO(), bson.M{"url": path, "cardId": bson.M{"$ne": nil}})
+		if err != nil || cnt > 0 {
+			continue
 		}
+		_ = os.Remove(filepath.Join(uploadDir, f.Name()))
+		_, _ = attachColl.DeleteMany(context.TODO(), bson.M{"url": path})
+		removed++
 	}
 	return removed
 }
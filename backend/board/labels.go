@@ -0,0 +1,575 @@
+package kanban
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"log"
+	"net/http"
+	"reflect"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// Label is an entry of a board's label catalog. Cards point at labels by id
+// (Card.Labels); Card.Tags keeps their names in the same order, maintained
+// by the server, so name-based consumers (filters, rules, lanes, exports)
+// keep working.
+type Label struct {
+	ID          string    `bson:"_id,omitempty" json:"_id"`
+	BoardID     string    `bson:"boardId" json:"boardId"`
+	Name        string    `bson:"name" json:"name"`
+	Key         string    `bson:"key" json:"-"` // labelKey(Name), unique per board
+	Color       string    `bson:"color" json:"color"`
+	Description string    `bson:"description,omitempty" json:"description,omitempty"`
+	CreatedAt   time.Time `bson:"createdAt" json:"createdAt"`
+	UpdatedAt   time.Time `bson:"updatedAt" json:"updatedAt"`
+}
+
+// labelPalette is where labels created without a color get theirs from.
+// maxRelabelTries is how often a label change is retried on a card that
+// keeps being written meanwhile.
+const maxRelabelTries = 5
+
+var labelPalette = []string{
+	"#e5484d", "#f76b15", "#ffc53d", "#46a758", "#12a594",
+	"#0090ff", "#3e63dd", "#8e4ec6", "#d6409f", "#8d8d8d",
+}
+
+var hexColor = regexp.MustCompile(`^#[0-9a-fA-F]{6}$`)
+
+// labelKey is what two label names must share to be the same label: no
+// leading '#', no case, single spaces.
+func labelKey(name string) string {
+	name = strings.TrimLeft(strings.TrimSpace(name), "#")
+	return strings.ToLower(strings.Join(strings.Fields(name), " "))
+}
+
+// labelName tidies a name the way it is stored.
+func labelName(name string) string {
+	return strings.Join(strings.Fields(strings.TrimLeft(strings.TrimSpace(name), "#")), " ")
+}
+
+func defaultLabelColor(key string) string {
+	h := fnv.New32a()
+	h.Write([]byte(key))
+	return labelPalette[h.Sum32()%uint32(len(labelPalette))]
+}
+
+// ensureLabels returns the board's labels for names, in order and without
+// duplicates, creating the ones that don't exist yet.
+func ensureLabels(ctx context.Context, db *mongo.Database, boardID string, names []string) ([]Label, error) {
+	coll := db.Collection("labels")
+	var out []Label
+	seen := map[string]bool{}
+	for _, name := range names {
+		key := labelKey(name)
+		if key == "" || seen[key] {
+			continue
+		}
+		seen[key] = true
+		now := time.Now()
+		var l Label
+		err := coll.FindOneAndUpdate(ctx,
+			bson.M{"boardId": boardID, "key": key},
+			bson.M{"$setOnInsert": bson.M{
+				"_id": newID(), "boardId": boardID, "key": key, "name": labelName(name),
+				"color": defaultLabelColor(key), "createdAt": now, "updatedAt": now,
+			}},
+			options.FindOneAndUpdate().SetUpsert(true).SetReturnDocument(options.After)).Decode(&l)
+		if mongo.IsDuplicateKeyError(err) {
+			// lost a race with another insert of the same label
+			err = coll.FindOne(ctx, bson.M{"boardId": boardID, "key": key}).Decode(&l)
+		}
+		if err != nil {
+			return nil, err
+		}
+		out = append(out, l)
+	}
+	return out, nil
+}
+
+// setLabels points card at labels, keeping Tags in step.
+func (card *Card) setLabels(labels []Label) {
+	card.Labels, card.Tags = nil, nil
+	for _, l := range labels {
+		card.Labels = append(card.Labels, l.ID)
+		card.Tags = append(card.Tags, l.Name)
+	}
+}
+
+// resolveCardLabels makes card.Labels and card.Tags agree before the card is
+// written. With fromTags the tags decide (missing labels are created),
+// otherwise the label ids do and must belong to the card's board.
+func resolveCardLabels(ctx context.Context, db *mongo.Database, card *Card, fromTags bool) error {
+	if fromTags {
+		labels, err := ensureLabels(ctx, db, card.BoardID, card.Tags)
+		if err != nil {
+			return err
+		}
+		card.setLabels(labels)
+		return nil
+	}
+	if len(card.Labels) == 0 {
+		card.setLabels(nil)
+		return nil
+	}
+	cur, err := db.Collection("labels").Find(ctx, bson.M{"_id": bson.M{"$in": card.Labels}, "boardId": card.BoardID})
+	if err != nil {
+		return err
+	}
+	var found []Label
+	if err := cur.All(ctx, &found); err != nil {
+		return err
+	}
+	byID := map[string]Label{}
+	for _, l := range found {
+		byID[l.ID] = l
+	}
+	var labels []Label
+	seen := map[string]bool{}
+	for _, id := range card.Labels {
+		l, ok := byID[id]
+		if !ok {
+			return fmt.Errorf("label %s not found on this board", id)
+		}
+		if !seen[id] {
+			seen[id] = true
+			labels = append(labels, l)
+		}
+	}
+	card.setLabels(labels)
+	return nil
+}
+
+// updateCardLabels settles labels and tags for a PUT of update over before:
+// labels win over tags when both are sent, clearing either clears both, and
+// a card moved to another board takes its tags along as that board's labels.
+func updateCardLabels(ctx context.Context, db *mongo.Database, before, update *Card) error {
+	boardID := update.BoardID
+	if boardID == "" {
+		boardID = before.BoardID
+	}
+	for _, field := range update.cleared {
+		if field == "tags" || field == "labels" {
+			update.Tags, update.Labels = nil, nil
+			update.cleared = removeStrings(update.cleared, "tags", "labels")
+			update.cleared = append(update.cleared, "tags", "labels")
+			return nil
+		}
+	}
+	fromTags := update.Labels == nil
+	switch {
+	case update.Labels != nil || update.Tags != nil:
+	case boardID != before.BoardID && len(before.Tags) > 0:
+		update.Tags = before.Tags
+	default:
+		return nil
+	}
+	card := Card{BoardID: boardID, Tags: update.Tags, Labels: update.Labels}
+	if err := resolveCardLabels(ctx, db, &card, fromTags); err != nil {
+		return err
+	}
+	update.Tags, update.Labels = card.Tags, card.Labels
+	if len(card.Labels) == 0 {
+		update.cleared = append(update.cleared, "tags", "labels")
+	}
+	return nil
+}
+
+// labelIDs returns the ids of labels matching names (by key), on boardID
+// when it is set.
+func labelIDs(ctx context.Context, db *mongo.Database, boardID string, names []string) ([]string, error) {
+	keys := make([]string, len(names))
+	for i, n := range names {
+		keys[i] = labelKey(n)
+	}
+	filter := bson.M{"key": bson.M{"$in": keys}}
+	if boardID != "" {
+		filter["boardId"] = boardID
+	}
+	cur, err := db.Collection("labels").Find(ctx, filter, options.Find().SetProjection(bson.M{"_id": 1}))
+	if err != nil {
+		return nil, err
+	}
+	var found []struct {
+		ID string `bson:"_id"`
+	}
+	if err := cur.All(ctx, &found); err != nil {
+		return nil, err
+	}
+	ids := make([]string, len(found))
+	for i, f := range found {
+		ids[i] = f.ID
+	}
+	return ids, nil
+}
+
+// migrateTagsToLabels gives cards written before labels existed a label
+// for each tag, creating the board catalogs on the way.
+func migrateTagsToLabels(db *mongo.Database) {
+	ctx := context.TODO()
+	cardColl := db.Collection("cards")
+	cur, err := cardColl.Find(ctx,
+		bson.M{"tags.0": bson.M{"$exists": true}, "labels": bson.M{"$exists": false}},
+		options.Find().SetProjection(bson.M{"boardId": 1, "tags": 1}))
+	if err != nil {
+		log.Println("kanban: label migration:", err)
+		return
+	}
+	defer cur.Close(ctx)
+	migrated := 0
+	for cur.Next(ctx) {
+		var card Card
+		if err := cur.Decode(&card); err != nil {
+			continue
+		}
+		read := card.Tags
+		if err := resolveCardLabels(ctx, db, &card, true); err != nil {
+			log.Println("kanban: label migration:", err)
+			return
+		}
+		// this runs while requests are served: a card edited since it was
+		// read is left as it is, and one migrated here gets a new version
+		res, err := cardColl.UpdateOne(ctx,
+			bson.M{"_id": card.ID, "tags": read, "labels": bson.M{"$exists": false}},
+			withVersion(bson.M{"$set": bson.M{"labels": card.Labels, "tags": card.Tags}}))
+		if err == nil && res.ModifiedCount > 0 {
+			migrated++
+		}
+	}
+	if migrated > 0 {
+		log.Println("kanban: migrated tags to labels on cards:", migrated)
+	}
+}
+
+// labelInput is what clients send to create or change a label.
+type labelInput struct {
+	Name        *string `json:"name"`
+	Color       *string `json:"color"`
+	Description *string `json:"description"`
+}
+
+func (in *labelInput) apply(l *Label) error {
+	if in.Name != nil {
+		l.Name, l.Key = labelName(*in.Name), labelKey(*in.Name)
+	}
+	if l.Key == "" {
+		return errors.New("name is required")
+	}
+	if in.Color != nil {
+		l.Color = strings.TrimSpace(*in.Color)
+	}
+	if l.Color == "" {
+		l.Color = defaultLabelColor(l.Key)
+	}
+	if !hexColor.MatchString(l.Color) {
+		return errors.New("color must look like #rrggbb")
+	}
+	l.Color = strings.ToLower(l.Color)
+	if in.Description != nil {
+		l.Description = strings.TrimSpace(*in.Description)
+	}
+	return nil
+}
+
+func registerLabelRoutes(router *gin.Engine, db *mongo.Database, changes *changeLog) {
+	labelColl := db.Collection("labels")
+	cardColl := db.Collection("cards")
+	ruleColl := db.Collection("rules")
+	_, _ = labelColl.Indexes().CreateOne(context.TODO(), mongo.IndexModel{
+		Keys:    bson.D{{Key: "boardId", Value: 1}, {Key: "key", Value: 1}},
+		Options: options.Index().SetUnique(true),
+	})
+	_, _ = cardColl.Indexes().CreateOne(context.TODO(), mongo.IndexModel{Keys: bson.D{{Key: "labels", Value: 1}}})
+	go migrateTagsToLabels(db)
+
+	loadLabel := func(c *gin.Context) (*Label, bool) {
+		var l Label
+		if err := labelColl.FindOne(context.TODO(), bson.M{"_id": c.Param("id")}).Decode(&l); err != nil {
+			c.JSON(http.StatusNotFound, gin.H{"error": "label not found"})
+			return nil, false
+		}
+		return &l, true
+	}
+	// report re-reads the cards in before (their state ahead of an update
+	// that already ran) and records what changed on each.
+	report := func(c *gin.Context, before []Card) {
+		for i := range before {
+			var after Card
+			if cardColl.FindOne(context.TODO(), bson.M{"_id": before[i].ID}).Decode(&after) == nil {
+				changes.card(currentUserID(c), &before[i], &after)
+			}
+		}
+	}
+	cardsWith := func(labelID string) []Card {
+		var out []Card
+		if cur, err := cardColl.Find(context.TODO(), bson.M{"labels": labelID}); err == nil {
+			_ = cur.All(context.TODO(), &out)
+		}
+		return out
+	}
+	// relabel changes a card's labels and tags with edit and writes them
+	// over the version they were read at. A card written in between is read
+	// again and edited anew, so a tag change made meanwhile is kept. It
+	// returns the card as it was before the write.
+	relabel := func(ctx context.Context, card Card, edit func(*Card) error) (Card, error) {
+		for tries := 1; ; tries++ {
+			before := card
+			card.Labels = append([]string(nil), before.Labels...)
+			card.Tags = append([]string(nil), before.Tags...)
+			if err := edit(&card); err != nil {
+				return before, err
+			}
+			if reflect.DeepEqual(card.Labels, before.Labels) && reflect.DeepEqual(card.Tags, before.Tags) {
+				return before, nil
+			}
+			update := bson.M{"$set": bson.M{"labels": card.Labels, "tags": card.Tags}}
+			if len(card.Labels) == 0 {
+				update = bson.M{"$unset": bson.M{"labels": "", "tags": ""}}
+			}
+			read := &precondition{versions: []int64{before.Version}}
+			res, err := cardColl.UpdateOne(ctx, read.filter(bson.M{"_id": card.ID}), withVersion(update))
+			if err != nil || res.MatchedCount > 0 {
+				return before, err
+			}
+			if tries == maxRelabelTries {
+				return before, fmt.Errorf("card %s keeps changing, try again", card.ID)
+			}
+			if err := cardColl.FindOne(ctx, bson.M{"_id": card.ID}).Decode(&card); err != nil {
+				return before, err
+			}
+		}
+	}
+
+	// GET /api/boards/:id/labels — with the number of live cards using each.
+	router.GET("/api/boards/:id/labels", func(c *gin.Context) {
+		boardID := c.Param("id")
+		cur, err := labelColl.Find(context.TODO(), bson.M{"boardId": boardID},
+			options.Find().SetSort(bson.D{{Key: "key", Value: 1}}))
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		var labels []Label
+		if err := cur.All(context.TODO(), &labels); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		counts := map[string]int{}
+		agg, err := cardColl.Aggregate(context.TODO(), mongo.Pipeline{
+			{{Key: "$match", Value: bson.M{"boardId": boardID, "deletedAt": nil, "archivedAt": nil}}},
+			{{Key: "$unwind", Value: "$labels"}},
+			{{Key: "$group", Value: bson.M{"_id": "$labels", "n": bson.M{"$sum": 1}}}},
+		})
+		if err == nil {
+			var rows []struct {
+				ID string `bson:"_id"`
+				N  int    `bson:"n"`
+			}
+			if agg.All(context.TODO(), &rows) == nil {
+				for _, r := range rows {
+					counts[r.ID] = r.N
+				}
+			}
+		}
+		type labelWithCount struct {
+			Label `bson:",inline"`
+			Cards int `json:"cards"`
+		}
+		out := make([]labelWithCount, len(labels))
+		for i, l := range labels {
+			out[i] = labelWithCount{l, counts[l.ID]}
+		}
+		c.JSON(http.StatusOK, out)
+	})
+
+	// POST /api/boards/:id/labels {"name", "color", "description"}
+	router.POST("/api/boards/:id/labels", func(c *gin.Context) {
+		var in labelInput
+		if err := c.BindJSON(&in); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		if n, _ := db.Collection("boards").CountDocuments(context.TODO(), bson.M{"_id": c.Param("id"), "deletedAt": nil}); n == 0 {
+			c.JSON(http.StatusNotFound, gin.H{"error": "board not found"})
+			return
+		}
+		now := time.Now()
+		l := Label{ID: newID(), BoardID: c.Param("id"), CreatedAt: now, UpdatedAt: now}
+		if err := in.apply(&l); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		if _, err := labelColl.InsertOne(context.TODO(), l); err != nil {
+			if mongo.IsDuplicateKeyError(err) {
+				c.JSON(http.StatusConflict, gin.H{"error": fmt.Sprintf("label %q already exists on this board", l.Name)})
+				return
+			}
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(http.StatusOK, l)
+	})
+
+	// PUT /api/labels/:id changes the given fields. A rename is carried to
+	// the tags of every card with the label and to rules naming it; renaming
+	// onto another label of the board is refused, merge them instead.
+	router.PUT("/api/labels/:id", func(c *gin.Context) {
+		l, ok := loadLabel(c)
+		if !ok {
+			return
+		}
+		var in labelInput
+		if err := c.BindJSON(&in); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		oldName := l.Name
+		if err := in.apply(l); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		l.UpdatedAt = time.Now()
+		if _, err := labelColl.ReplaceOne(context.TODO(), bson.M{"_id": l.ID}, l); err != nil {
+			if mongo.IsDuplicateKeyError(err) {
+				c.JSON(http.StatusConflict, gin.H{"error": fmt.Sprintf("label %q already exists on this board; merge the labels instead", l.Name)})
+				return
+			}
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		if l.Name != oldName {
+			before := cardsWith(l.ID)
+			for i := range before {
+				var err error
+				before[i], err = relabel(context.TODO(), before[i], func(card *Card) error {
+					for j, id := range card.Labels {
+						if id == l.ID && j < len(card.Tags) {
+							card.Tags[j] = l.Name
+						}
+					}
+					return nil
+				})
+				if err != nil {
+					c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+					return
+				}
+			}
+			report(c, before)
+			renameRuleTag(context.TODO(), ruleColl, l.BoardID, oldName, l.Name)
+		}
+		c.JSON(http.StatusOK, l)
+	})
+
+	// POST /api/labels/:id/merge {"into"} moves every card from this label to
+	// into (another label of the same board) and deletes this one.
+	router.POST("/api/labels/:id/merge", func(c *gin.Context) {
+		from, ok := loadLabel(c)
+		if !ok {
+			return
+		}
+		var in struct {
+			Into string `json:"into"`
+		}
+		if err := c.BindJSON(&in); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		var into Label
+		if err := labelColl.FindOne(context.TODO(), bson.M{"_id": in.Into, "boardId": from.BoardID}).Decode(&into); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "target label not found on this board"})
+			return
+		}
+		if into.ID == from.ID {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "cannot merge a label into itself"})
+			return
+		}
+		before := cardsWith(from.ID)
+		for i := range before {
+			var err error
+			before[i], err = relabel(context.TODO(), before[i], func(card *Card) error {
+				var labels []string
+				for _, id := range card.Labels {
+					if id == from.ID {
+						id = into.ID
+					}
+					if !containsString(labels, id) {
+						labels = append(labels, id)
+					}
+				}
+				card.Labels = labels
+				return resolveCardLabels(context.TODO(), db, card, false)
+			})
+			if err != nil {
+				c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+				return
+			}
+		}
+		if _, err := labelColl.DeleteOne(context.TODO(), bson.M{"_id": from.ID}); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		report(c, before)
+		renameRuleTag(context.TODO(), ruleColl, from.BoardID, from.Name, into.Name)
+		c.JSON(http.StatusOK, gin.H{"label": into, "cards": len(before)})
+	})
+
+	// DELETE /api/labels/:id takes the label off every card, then drops it.
+	router.DELETE("/api/labels/:id", func(c *gin.Context) {
+		l, ok := loadLabel(c)
+		if !ok {
+			return
+		}
+		before := cardsWith(l.ID)
+		for i := range before {
+			var err error
+			before[i], err = relabel(context.TODO(), before[i], func(card *Card) error {
+				card.Labels = removeStrings(card.Labels, l.ID)
+				return resolveCardLabels(context.TODO(), db, card, false)
+			})
+			if err != nil {
+				c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+				return
+			}
+		}
+		if _, err := labelColl.DeleteOne(context.TODO(), bson.M{"_id": l.ID}); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		report(c, before)
+		c.JSON(http.StatusOK, gin.H{"status": "deleted", "cards": len(before)})
+	})
+}
+
+// renameRuleTag points the board's rules that name a label by oldName at
+// newName, in triggers and in tag/untag actions.
+func renameRuleTag(ctx context.Context, rules *mongo.Collection, boardID, oldName, newName string) {
+	var found []Rule
+	if cur, err := rules.Find(ctx, bson.M{"boardId": boardID}); err == nil {
+		_ = cur.All(ctx, &found)
+	}
+	old := labelKey(oldName)
+	for _, r := range found {
+		changed := false
+		if r.Trigger.Tag != "" && labelKey(r.Trigger.Tag) == old {
+			r.Trigger.Tag, changed = newName, true
+		}
+		for i, act := range r.Actions {
+			if act.Tag != "" && labelKey(act.Tag) == old {
+				r.Actions[i].Tag, changed = newName, true
+			}
+		}
+		if changed {
+			_, _ = rules.UpdateOne(ctx, bson.M{"_id": r.ID},
+				bson.M{"$set": bson.M{"trigger": r.Trigger, "actions": r.Actions}})
+		}
+	}
+}
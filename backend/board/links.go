@@ -0,0 +1,348 @@
+package kanban
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// Link types as stored. Every link reads "From <type> To"; the reverse
+// names below are accepted on input and shown when looking from To.
+const (
+	LinkBlocks     = "blocks"
+	LinkRelates    = "relates_to"
+	LinkDuplicates = "duplicates"
+	LinkParentOf   = "parent_of"
+)
+
+var reverseLink = map[string]string{
+	LinkBlocks:     "blocked_by",
+	LinkRelates:    LinkRelates,
+	LinkDuplicates: "duplicated_by",
+	LinkParentOf:   "child_of",
+}
+
+// Status categories. Moving a blocked card into a CategoryDone column is
+// checked against the board's BlockedPolicy.
+const (
+	CategoryTodo       = "todo"
+	CategoryInProgress = "in_progress"
+	CategoryDone       = "done"
+)
+
+func validCategory(category string) bool {
+	switch category {
+	case "", CategoryTodo, CategoryInProgress, CategoryDone:
+		return true
+	}
+	return false
+}
+
+// Board.BlockedPolicy values.
+const (
+	BlockedAllow  = ""
+	BlockedWarn   = "warn"
+	BlockedRefuse = "refuse"
+)
+
+// CardLink is a typed edge between two cards, possibly on different boards.
+type CardLink struct {
+	ID          string    `bson:"_id,omitempty" json:"_id"`
+	Type        string    `bson:"type" json:"type"`
+	FromID      string    `bson:"fromId" json:"fromId"`
+	ToID        string    `bson:"toId" json:"toId"`
+	FromBoardID string    `bson:"fromBoardId" json:"fromBoardId"`
+	ToBoardID   string    `bson:"toBoardId" json:"toBoardId"`
+	CreatedBy   string    `bson:"createdBy,omitempty" json:"createdBy,omitempty"`
+	CreatedAt   time.Time `bson:"createdAt" json:"createdAt"`
+}
+
+// LinkView is a link as seen from one card.
+type LinkView struct {
+	ID   string `json:"_id"`
+	Type string `json:"type"`
+	Card *Card  `json:"card"`
+}
+
+// normalizeLink turns (from, type, to) into the stored direction.
+func normalizeLink(from, linkType, to string) (string, string, string, error) {
+	linkType = strings.ToLower(strings.ReplaceAll(linkType, "-", "_"))
+	if _, ok := reverseLink[linkType]; ok {
+		return from, linkType, to, nil
+	}
+	for stored, rev := range reverseLink {
+		if rev == linkType {
+			return to, stored, from, nil
+		}
+	}
+	return "", "", "", fmt.Errorf("unknown link type %q", linkType)
+}
+
+// reaches reports whether target can be reached from start by following
+// links of linkType forward.
+func reaches(ctx context.Context, coll *mongo.Collection, linkType, start, target string) (bool, error) {
+	seen := map[string]bool{start: true}
+	frontier := []string{start}
+	for len(frontier) > 0 {
+		cur, err := coll.Find(ctx, bson.M{"type": linkType, "fromId": bson.M{"$in": frontier}})
+		if err != nil {
+			return false, err
+		}
+		var links []CardLink
+		if err := cur.All(ctx, &links); err != nil {
+			return false, err
+		}
+		frontier = frontier[:0]
+		for _, l := range links {
+			if l.ToID == target {
+				return true, nil
+			}
+			if !seen[l.ToID] {
+				seen[l.ToID] = true
+				frontier = append(frontier, l.ToID)
+			}
+		}
+	}
+	return false, nil
+}
+
+// openBlockers returns the live cards blocking cardID that are not in a
+// done column yet.
+func openBlockers(ctx context.Context, db *mongo.Database, cardID string) ([]Card, error) {
+	cur, err := db.Collection("card_links").Find(ctx, bson.M{"type": LinkBlocks, "toId": cardID})
+	if err != nil {
+		return nil, err
+	}
+	var links []CardLink
+	if err := cur.All(ctx, &links); err != nil {
+		return nil, err
+	}
+	if len(links) == 0 {
+		return nil, nil
+	}
+	ids := make([]string, len(links))
+	for i, l := range links {
+		ids[i] = l.FromID
+	}
+	cur, err = db.Collection("cards").Find(ctx, bson.M{"_id": bson.M{"$in": ids}, "deletedAt": nil})
+	if err != nil {
+		return nil, err
+	}
+	var blockers []Card
+	if err := cur.All(ctx, &blockers); err != nil {
+		return nil, err
+	}
+	var statusIDs []string
+	for _, b := range blockers {
+		statusIDs = append(statusIDs, b.StatusID)
+	}
+	done := map[string]bool{}
+	cur, err = db.Collection("statuses").Find(ctx, bson.M{"_id": bson.M{"$in": statusIDs}, "category": CategoryDone})
+	if err != nil {
+		return nil, err
+	}
+	var doneStatuses []Status
+	if err := cur.All(ctx, &doneStatuses); err != nil {
+		return nil, err
+	}
+	for _, st := range doneStatuses {
+		done[st.ID] = true
+	}
+	open := blockers[:0]
+	for _, b := range blockers {
+		if !done[b.StatusID] {
+			open = append(open, b)
+		}
+	}
+	return open, nil
+}
+
+// checkBlockedMove applies the board's BlockedPolicy to moving card into
+// statusID. It returns a message when the card still has open blockers and
+// the target is a done column; refuse is true if the move must not happen.
+func checkBlockedMove(ctx context.Context, db *mongo.Database, card *Card, statusID string) (msg string, refuse bool) {
+	if statusID == "" || statusID == card.StatusID {
+		return "", false
+	}
+	var board Board
+	if err := db.Collection("boards").FindOne(ctx, bson.M{"_id": card.BoardID}).Decode(&board); err != nil {
+		return "", false
+	}
+	if board.BlockedPolicy == BlockedAllow {
+		return "", false
+	}
+	var st Status
+	if err := db.Collection("statuses").FindOne(ctx, bson.M{"_id": statusID}).Decode(&st); err != nil || st.Category != CategoryDone {
+		return "", false
+	}
+	blockers, err := openBlockers(ctx, db, card.ID)
+	if err != nil || len(blockers) == 0 {
+		return "", false
+	}
+	titles := make([]string, len(blockers))
+	for i, b := range blockers {
+		titles[i] = b.Title
+	}
+	msg = "card is blocked by: " + strings.Join(titles, ", ")
+	return msg, board.BlockedPolicy == BlockedRefuse
+}
+
+func registerLinkRoutes(router *gin.Engine, db *mongo.Database, changes *changeLog) {
+	cardColl := db.Collection("cards")
+	linkColl := db.Collection("card_links")
+	_, _ = linkColl.Indexes().CreateMany(context.TODO(), []mongo.IndexModel{
+		{Keys: bson.D{{Key: "fromId", Value: 1}, {Key: "type", Value: 1}}},
+		{Keys: bson.D{{Key: "toId", Value: 1}, {Key: "type", Value: 1}}},
+	})
+
+	router.GET("/api/cards/:id/links", func(c *gin.Context) {
+		id := c.Param("id")
+		cur, err := linkColl.Find(context.TODO(), bson.M{"$or": []bson.M{{"fromId": id}, {"toId": id}}})
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		var links []CardLink
+		if err := cur.All(context.TODO(), &links); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		var otherIDs []string
+		for _, l := range links {
+			if l.FromID == id {
+				otherIDs = append(otherIDs, l.ToID)
+			} else {
+				otherIDs = append(otherIDs, l.FromID)
+			}
+		}
+		others := map[string]*Card{}
+		if len(otherIDs) > 0 {
+			var cards []Card
+			if cur, err := cardColl.Find(context.TODO(), bson.M{"_id": bson.M{"$in": otherIDs}, "deletedAt": nil}); err == nil {
+				_ = cur.All(context.TODO(), &cards)
+			}
+			for i := range cards {
+				others[cards[i].ID] = &cards[i]
+			}
+		}
+		out := []LinkView{}
+		for _, l := range links {
+			view := LinkView{ID: l.ID, Type: l.Type, Card: others[l.ToID]}
+			if l.ToID == id {
+				view.Type, view.Card = reverseLink[l.Type], others[l.FromID]
+			}
+			if view.Card == nil {
+				continue // other side is in the trash
+			}
+			out = append(out, view)
+		}
+		c.JSON(http.StatusOK, out)
+	})
+
+	// POST /api/cards/:id/links {"type": "blocks"|"blocked_by"|..., "cardId": "..."}
+	router.POST("/api/cards/:id/links", func(c *gin.Context) {
+		var in struct {
+			Type   string `json:"type"`
+			CardID string `json:"cardId"`
+		}
+		if err := c.BindJSON(&in); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		from, linkType, to, err := normalizeLink(c.Param("id"), in.Type, in.CardID)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		if from == to {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "a card can't link to itself"})
+			return
+		}
+		var fromCard, toCard Card
+		if err := cardColl.FindOne(context.TODO(), bson.M{"_id": from, "deletedAt": nil}).Decode(&fromCard); err != nil {
+			c.JSON(http.StatusNotFound, gin.H{"error": "card not found"})
+			return
+		}
+		if err := cardColl.FindOne(context.TODO(), bson.M{"_id": to, "deletedAt": nil}).Decode(&toCard); err != nil {
+			c.JSON(http.StatusNotFound, gin.H{"error": "card not found"})
+			return
+		}
+		dup := bson.M{"type": linkType, "fromId": from, "toId": to}
+		if linkType == LinkRelates {
+			dup = bson.M{"type": linkType, "$or": []bson.M{{"fromId": from, "toId": to}, {"fromId": to, "toId": from}}}
+		}
+		if cnt, _ := linkColl.CountDocuments(context.TODO(), dup); cnt > 0 {
+			c.JSON(http.StatusConflict, gin.H{"error": "link already exists"})
+			return
+		}
+		if linkType == LinkBlocks || linkType == LinkParentOf {
+			cycle, err := reaches(context.TODO(), linkColl, linkType, to, from)
+			if err != nil {
+				c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+				return
+			}
+			if cycle {
+				c.JSON(http.StatusConflict, gin.H{"error": "link would create a cycle"})
+				return
+			}
+		}
+		link := CardLink{
+			ID:          newID(),
+			Type:        linkType,
+			FromID:      from,
+			ToID:        to,
+			FromBoardID: fromCard.BoardID,
+			ToBoardID:   toCard.BoardID,
+			CreatedBy:   currentUserID(c),
+			CreatedAt:   time.Now(),
+		}
+		if _, err := linkColl.InsertOne(context.TODO(), link); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		changes.cardAction(currentUserID(c), &fromCard, ActionLinked, ActivityChange{Field: "link." + linkType, New: to})
+		changes.cardAction(currentUserID(c), &toCard, ActionLinked, ActivityChange{Field: "link." + reverseLink[linkType], New: from})
+		c.JSON(http.StatusOK, link)
+	})
+
+	router.DELETE("/api/links/:id", func(c *gin.Context) {
+		var link CardLink
+		if err := linkColl.FindOne(context.TODO(), bson.M{"_id": c.Param("id")}).Decode(&link); err != nil {
+			c.JSON(http.StatusNotFound, gin.H{"error": "not found"})
+			return
+		}
+		if _, err := linkColl.DeleteOne(context.TODO(), bson.M{"_id": link.ID}); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		for _, side := range []struct{ id, field, other string }{
+			{link.FromID, "link." + link.Type, link.ToID},
+			{link.ToID, "link." + reverseLink[link.Type], link.FromID},
+		} {
+			var card Card
+			if err := cardColl.FindOne(context.TODO(), bson.M{"_id": side.id}).Decode(&card); err == nil {
+				changes.cardAction(currentUserID(c), &card, ActionUnlinked, ActivityChange{Field: side.field, Old: side.other})
+			}
+		}
+		c.JSON(http.StatusOK, gin.H{"status": "deleted"})
+	})
+
+	// GET /api/cards/:id/blockers — open blockers of a card
+	router.GET("/api/cards/:id/blockers", func(c *gin.Context) {
+		blockers, err := openBlockers(context.TODO(), db, c.Param("id"))
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		if blockers == nil {
+			blockers = []Card{}
+		}
+		c.JSON(http.StatusOK, blockers)
+	})
+}
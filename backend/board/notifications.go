@@ -0,0 +1,98 @@
+package kanban
+
+import (
+	"context"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// Notification is a per-user inbox entry (mentions, reminders, ...).
+type Notification struct {
+	ID        string    `bson:"_id,omitempty" json:"_id"`
+	UserID    string    `bson:"userId" json:"userId"`
+	Type      string    `bson:"type" json:"type"` // "mention", ...
+	BoardID   string    `bson:"boardId,omitempty" json:"boardId,omitempty"`
+	CardID    string    `bson:"cardId,omitempty" json:"cardId,omitempty"`
+	CommentID string    `bson:"commentId,omitempty" json:"commentId,omitempty"`
+	ActorID   string    `bson:"actorId,omitempty" json:"actorId,omitempty"`
+	Text      string    `bson:"text" json:"text"`
+	Read      bool      `bson:"read" json:"read"`
+	CreatedAt time.Time `bson:"createdAt" json:"createdAt"`
+}
+
+// notify stores one notification per recipient, skipping the actor themselves.
+func notify(ctx context.Context, coll *mongo.Collection, recipients []string, n Notification) error {
+	var docs []interface{}
+	for _, userID := range recipients {
+		if userID == "" || userID == n.ActorID {
+			continue
+		}
+		doc := n
+		doc.ID = newID()
+		doc.UserID = userID
+		doc.CreatedAt = time.Now()
+		docs = append(docs, doc)
+	}
+	if len(docs) == 0 {
+		return nil
+	}
+	_, err := coll.InsertMany(ctx, docs)
+	return err
+}
+
+func registerNotificationRoutes(router *gin.Engine, db *mongo.Database) {
+	notifColl := db.Collection("notifications")
+
+	api := router.Group("/api/notifications")
+	api.Use(requireUser())
+
+	api.GET("", func(c *gin.Context) {
+		filter := bson.M{"userId": currentUserID(c)}
+		if c.Query("unread") == "true" {
+			filter["read"] = false
+		}
+		opts := options.Find().SetSort(bson.D{{Key: "createdAt", Value: -1}}).SetLimit(100)
+		cur, err := notifColl.Find(context.TODO(), filter, opts)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		out := []Notification{}
+		if err := cur.All(context.TODO(), &out); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(http.StatusOK, out)
+	})
+
+	api.POST("/:id/read", func(c *gin.Context) {
+		res, err := notifColl.UpdateOne(context.TODO(),
+			bson.M{"_id": c.Param("id"), "userId": currentUserID(c)},
+			bson.M{"$set": bson.M{"read": true}})
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		if res.MatchedCount == 0 {
+			c.JSON(http.StatusNotFound, gin.H{"error": "not found"})
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{"status": "read"})
+	})
+
+	api.POST("/read-all", func(c *gin.Context) {
+		res, err := notifColl.UpdateMany(context.TODO(),
+			bson.M{"userId": currentUserID(c), "read": false},
+			bson.M{"$set": bson.M{"read": true}})
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{"updated": res.ModifiedCount})
+	})
+}
@@ -0,0 +1,496 @@
+package kanban
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// The card filter language used by GET /api/cards?q=. A query is a list of
+// terms that must all hold:
+//
+//	status:"In Progress" assignee:me tag:bug,feature due<7d -tag:wontfix
+//
+// A term is field:value, or field<value / field>value (also <= and >=) for
+// dates; a leading "-" negates it and "a,b" matches any of the values.
+// Values with spaces are quoted. Bare words match title or description.
+// Dates are YYYY-MM-DD, RFC3339, "now", "today", or an offset from now such
+// as 7d, -2w or 12h.
+
+// QueryError is a syntax or lookup error at a byte offset of the query.
+type QueryError struct {
+	Pos int
+	Msg string
+}
+
+func (e *QueryError) Error() string {
+	return fmt.Sprintf("query error at %d: %s", e.Pos, e.Msg)
+}
+
+// queryTerm is one parsed term. Field is "" for bare words.
+type queryTerm struct {
+	Pos    int
+	Neg    bool
+	Field  string
+	Op     string // ":", "<", "<=", ">", ">="
+	Values []string
+}
+
+// parseQuery splits a query into terms.
+func parseQuery(query string) ([]queryTerm, error) {
+	var terms []queryTerm
+	i := 0
+	for {
+		for i < len(query) && isSpace(query[i]) {
+			i++
+		}
+		if i >= len(query) {
+			return terms, nil
+		}
+		t := queryTerm{Pos: i}
+		if query[i] == '-' {
+			t.Neg = true
+			i++
+			if i >= len(query) || isSpace(query[i]) {
+				return nil, &QueryError{t.Pos, `"-" must be followed by a term`}
+			}
+		}
+
+		j := i
+		for j < len(query) && (query[j] >= 'a' && query[j] <= 'z' || query[j] >= 'A' && query[j] <= 'Z') {
+			j++
+		}
+		if j > i && j < len(query) && strings.IndexByte(":<>", query[j]) >= 0 {
+			t.Field = strings.ToLower(query[i:j])
+			t.Op = query[j : j+1]
+			j++
+			if (t.Op == "<" || t.Op == ">") && j < len(query) && query[j] == '=' {
+				t.Op += "="
+				j++
+			}
+			i = j
+		}
+
+		// values: quoted or bare, separated by commas
+		for {
+			start := i
+			var v string
+			if i < len(query) && query[i] == '"' {
+				var b strings.Builder
+				i++
+				closed := false
+				for i < len(query) {
+					ch := query[i]
+					if ch == '\\' && i+1 < len(query) {
+						b.WriteByte(query[i+1])
+						i += 2
+						continue
+					}
+					i++
+					if ch == '"' {
+						closed = true
+						break
+					}
+					b.WriteByte(ch)
+				}
+				if !closed {
+					return nil, &QueryError{start, "unterminated quote"}
+				}
+				v = b.String()
+			} else {
+				for i < len(query) && !isSpace(query[i]) && (t.Field == "" || query[i] != ',') {
+					if query[i] == '"' {
+						return nil, &QueryError{i, `unexpected quote; quote the whole value`}
+					}
+					i++
+				}
+				v = query[start:i]
+			}
+			if v == "" {
+				if t.Field != "" {
+					return nil, &QueryError{start, fmt.Sprintf("missing value after %s%s", t.Field, t.Op)}
+				}
+				return nil, &QueryError{start, "empty term"}
+			}
+			t.Values = append(t.Values, v)
+			if t.Field != "" && i < len(query) && query[i] == ',' {
+				i++
+				continue
+			}
+			break
+		}
+		if i < len(query) && !isSpace(query[i]) {
+			return nil, &QueryError{i, fmt.Sprintf("unexpected %q; separate terms with spaces", query[i])}
+		}
+		terms = append(terms, t)
+	}
+}
+
+var relativeRe = regexp.MustCompile(`^([+-]?\d+)([hdw])$`)
+
+// queryDate resolves a date value relative to now.
+func queryDate(v string, now time.Time) (time.Time, error) {
+	switch strings.ToLower(v) {
+	case "now":
+		return now, nil
+	case "today":
+		y, m, d := now.Date()
+		return time.Date(y, m, d, 0, 0, 0, 0, now.Location()), nil
+	}
+	if m := relativeRe.FindStringSubmatch(strings.ToLower(v)); m != nil {
+		n, err := strconv.Atoi(m[1])
+		if err != nil {
+			return time.Time{}, err
+		}
+		unit := map[string]time.Duration{"h": time.Hour, "d": 24 * time.Hour, "w": 7 * 24 * time.Hour}[m[2]]
+		return now.Add(time.Duration(n) * unit), nil
+	}
+	return parseDate(v)
+}
+
+// queryCompiler turns terms into a Mongo filter, looking names up in db.
+type queryCompiler struct {
+	ctx    context.Context
+	db     *mongo.Database
+	userID string // for "me"
+	now    time.Time
+}
+
+type fieldCompiler func(qc *queryCompiler, t queryTerm) (bson.M, error)
+
+var dateFields = map[string]string{
+	"due":     "dueDate",
+	"start":   "startDate",
+	"created": "createdAt",
+	"updated": "updatedAt",
+}
+
+var queryFields = map[string]fieldCompiler{
+	"status":   (*queryCompiler).status,
+	"category": (*queryCompiler).category,
+	"board":    (*queryCompiler).board,
+	"assignee": (*queryCompiler).assignee,
+	"tag":      (*queryCompiler).label,
+	"priority": func(qc *queryCompiler, t queryTerm) (bson.M, error) { return qc.plain("priority", t) },
+	"lane":     (*queryCompiler).lane,
+	"title":    (*queryCompiler).title,
+	"is":       (*queryCompiler).is,
+	"due":      (*queryCompiler).date,
+	"start":    (*queryCompiler).date,
+	"created":  (*queryCompiler).date,
+	"updated":  (*queryCompiler).date,
+}
+
+func knownFields() string {
+	names := make([]string, 0, len(queryFields))
+	for name := range queryFields {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return strings.Join(names, ", ")
+}
+
+// compileQuery parses and compiles query; userID stands in for "me".
+func compileQuery(ctx context.Context, db *mongo.Database, query, userID string) (bson.M, error) {
+	terms, err := parseQuery(query)
+	if err != nil {
+		return nil, err
+	}
+	qc := &queryCompiler{ctx: ctx, db: db, userID: userID, now: time.Now()}
+	var and []bson.M
+	for _, t := range terms {
+		var clause bson.M
+		if t.Field == "" {
+			clause = textClause(t.Values[0])
+		} else {
+			compile, ok := queryFields[t.Field]
+			if !ok {
+				return nil, &QueryError{t.Pos, fmt.Sprintf("unknown field %q (known: %s)", t.Field, knownFields())}
+			}
+			if _, isDate := dateFields[t.Field]; !isDate && t.Op != ":" {
+				return nil, &QueryError{t.Pos, fmt.Sprintf("%s only supports \":\"", t.Field)}
+			}
+			if clause, err = compile(qc, t); err != nil {
+				return nil, err
+			}
+		}
+		if t.Neg {
+			clause = bson.M{"$nor": bson.A{clause}}
+		}
+		and = append(and, clause)
+	}
+	if len(and) == 0 {
+		return bson.M{}, nil
+	}
+	return bson.M{"$and": and}, nil
+}
+
+// quoteRegex is regexp.QuoteMeta that also escapes NUL, which BSON regexes
+// can't hold.
+func quoteRegex(s string) string {
+	return strings.ReplaceAll(regexp.QuoteMeta(s), "\x00", `\x00`)
+}
+
+func textClause(word string) bson.M {
+	re := primitive.Regex{Pattern: quoteRegex(word), Options: "i"}
+	return bson.M{"$or": bson.A{bson.M{"title": re}, bson.M{"description": re}}}
+}
+
+// anyOf matches field against the values; "none" matches a missing field.
+func anyOf(field string, values []string) bson.M {
+	var or bson.A
+	var in bson.A
+	for _, v := range values {
+		if strings.EqualFold(v, "none") {
+			or = append(or, bson.M{field: bson.M{"$in": bson.A{nil, "", bson.A{}}}})
+			continue
+		}
+		in = append(in, v)
+	}
+	if len(in) > 0 {
+		or = append(or, bson.M{field: bson.M{"$in": in}})
+	}
+	if len(or) == 1 {
+		return or[0].(bson.M)
+	}
+	return bson.M{"$or": or}
+}
+
+func (qc *queryCompiler) plain(field string, t queryTerm) (bson.M, error) {
+	return anyOf(field, t.Values), nil
+}
+
+func (qc *queryCompiler) title(t queryTerm) (bson.M, error) {
+	var or bson.A
+	for _, v := range t.Values {
+		or = append(or, bson.M{"title": primitive.Regex{Pattern: quoteRegex(v), Options: "i"}})
+	}
+	return bson.M{"$or": or}, nil
+}
+
+// lookup finds the ids of live documents in coll whose name is one of
+// values (case-insensitive), or whose id is.
+func (qc *queryCompiler) lookup(coll string, t queryTerm, what string) ([]string, error) {
+	var ids []string
+	for _, v := range t.Values {
+		if strings.EqualFold(v, "none") {
+			ids = append(ids, "")
+			continue
+		}
+		filter := bson.M{
+			"deletedAt": nil,
+			"$or": bson.A{
+				bson.M{"_id": v},
+				bson.M{"name": primitive.Regex{Pattern: "^" + quoteRegex(v) + "$", Options: "i"}},
+			},
+		}
+		var found []struct {
+			ID string `bson:"_id"`
+		}
+		cur, err := qc.db.Collection(coll).Find(qc.ctx, filter)
+		if err != nil {
+			return nil, err
+		}
+		if err := cur.All(qc.ctx, &found); err != nil {
+			return nil, err
+		}
+		if len(found) == 0 {
+			return nil, &QueryError{t.Pos, fmt.Sprintf("no %s named %q", what, v)}
+		}
+		for _, f := range found {
+			ids = append(ids, f.ID)
+		}
+	}
+	return ids, nil
+}
+
+// label matches labels by name on any board; a name no board uses matches
+// nothing.
+func (qc *queryCompiler) label(t queryTerm) (bson.M, error) {
+	var names []string
+	values := []string{}
+	for _, v := range t.Values {
+		if strings.EqualFold(v, "none") {
+			values = append(values, v)
+		} else {
+			names = append(names, v)
+		}
+	}
+	if len(names) > 0 {
+		ids, err := labelIDs(qc.ctx, qc.db, "", names)
+		if err != nil {
+			return nil, err
+		}
+		if len(ids) == 0 {
+			ids = []string{""} // never a card's label
+		}
+		values = append(values, ids...)
+	}
+	return anyOf("labels", values), nil
+}
+
+func (qc *queryCompiler) status(t queryTerm) (bson.M, error) {
+	ids, err := qc.lookup("statuses", t, "column")
+	if err != nil {
+		return nil, err
+	}
+	return anyOf("statusId", ids), nil
+}
+
+// lane matches manual lanes by name or id.
+func (qc *queryCompiler) lane(t queryTerm) (bson.M, error) {
+	var ids []string
+	for _, v := range t.Values {
+		if strings.EqualFold(v, "none") {
+			ids = append(ids, v)
+			continue
+		}
+		var boards []Board
+		cur, err := qc.db.Collection("boards").Find(qc.ctx, bson.M{"deletedAt": nil, "lanes": bson.M{"$elemMatch": bson.M{"$or": bson.A{
+			bson.M{"id": v},
+			bson.M{"name": primitive.Regex{Pattern: "^" + quoteRegex(v) + "$", Options: "i"}},
+		}}}})
+		if err != nil {
+			return nil, err
+		}
+		if err := cur.All(qc.ctx, &boards); err != nil {
+			return nil, err
+		}
+		n := len(ids)
+		for _, b := range boards {
+			for _, l := range b.Lanes {
+				if l.ID == v || strings.EqualFold(l.Name, v) {
+					ids = append(ids, l.ID)
+				}
+			}
+		}
+		if len(ids) == n {
+			return nil, &QueryError{t.Pos, fmt.Sprintf("no lane named %q", v)}
+		}
+	}
+	return anyOf("laneId", ids), nil
+}
+
+func (qc *queryCompiler) board(t queryTerm) (bson.M, error) {
+	ids, err := qc.lookup("boards", t, "board")
+	if err != nil {
+		return nil, err
+	}
+	return bson.M{"boardId": bson.M{"$in": ids}}, nil
+}
+
+// category:done matches cards in any column of that category.
+func (qc *queryCompiler) category(t queryTerm) (bson.M, error) {
+	for _, v := range t.Values {
+		if !validCategory(v) {
+			return nil, &QueryError{t.Pos, fmt.Sprintf("unknown category %q (use %s, %s or %s)", v, CategoryTodo, CategoryInProgress, CategoryDone)}
+		}
+	}
+	var found []struct {
+		ID string `bson:"_id"`
+	}
+	cur, err := qc.db.Collection("statuses").Find(qc.ctx, bson.M{"category": bson.M{"$in": t.Values}, "deletedAt": nil})
+	if err != nil {
+		return nil, err
+	}
+	if err := cur.All(qc.ctx, &found); err != nil {
+		return nil, err
+	}
+	ids := bson.A{}
+	for _, f := range found {
+		ids = append(ids, f.ID)
+	}
+	return bson.M{"statusId": bson.M{"$in": ids}}, nil
+}
+
+// assignee takes "me", "none", a user id, or a handle as used in mentions.
+func (qc *queryCompiler) assignee(t queryTerm) (bson.M, error) {
+	var ids []string
+	for _, v := range t.Values {
+		switch {
+		case strings.EqualFold(v, "me"):
+			if qc.userID == "" {
+				return nil, &QueryError{t.Pos, `"me" needs a signed-in user`}
+			}
+			ids = append(ids, qc.userID)
+		case strings.EqualFold(v, "none"):
+			ids = append(ids, v)
+		case primitive.IsValidObjectID(v):
+			ids = append(ids, v)
+		default:
+			found := resolveMentions(qc.ctx, qc.db.Collection("users"), []string{strings.ToLower(strings.TrimPrefix(v, "@"))})
+			if len(found) == 0 {
+				return nil, &QueryError{t.Pos, fmt.Sprintf("no user matches %q", v)}
+			}
+			ids = append(ids, found...)
+		}
+	}
+	return anyOf("assignees", ids), nil
+}
+
+// is:overdue (past due and not done), is:assigned, is:unassigned and
+// is:subtask.
+func (qc *queryCompiler) is(t queryTerm) (bson.M, error) {
+	var or bson.A
+	for _, v := range t.Values {
+		switch strings.ToLower(v) {
+		case "overdue":
+			done, err := doneStatusIDs(qc.ctx, qc.db, "")
+			if err != nil {
+				return nil, err
+			}
+			or = append(or, bson.M{"dueDate": bson.M{"$lt": qc.now}, "statusId": bson.M{"$nin": done}})
+		case "assigned":
+			or = append(or, bson.M{"assignees.0": bson.M{"$exists": true}})
+		case "unassigned":
+			or = append(or, bson.M{"assignees.0": bson.M{"$exists": false}})
+		case "subtask":
+			or = append(or, bson.M{"parentId": bson.M{"$nin": bson.A{nil, ""}}})
+		default:
+			return nil, &QueryError{t.Pos, fmt.Sprintf("unknown is:%s (use overdue, assigned, unassigned or subtask)", v)}
+		}
+	}
+	if len(or) == 1 {
+		return or[0].(bson.M), nil
+	}
+	return bson.M{"$or": or}, nil
+}
+
+func (qc *queryCompiler) date(t queryTerm) (bson.M, error) {
+	field := dateFields[t.Field]
+	if len(t.Values) > 1 {
+		return nil, &QueryError{t.Pos, fmt.Sprintf("%s takes a single date", t.Field)}
+	}
+	v := t.Values[0]
+	if t.Op == ":" && strings.EqualFold(v, "none") {
+		return bson.M{field: nil}, nil
+	}
+	at, err := queryDate(v, qc.now)
+	if err != nil {
+		return nil, &QueryError{t.Pos, fmt.Sprintf("bad date %q: use YYYY-MM-DD, RFC3339, today, now or an offset like 7d, -2w, 12h", v)}
+	}
+	switch t.Op {
+	case "<":
+		return bson.M{field: bson.M{"$lt": at}}, nil
+	case "<=":
+		return bson.M{field: bson.M{"$lte": at}}, nil
+	case ">":
+		return bson.M{field: bson.M{"$gt": at}}, nil
+	case ">=":
+		return bson.M{field: bson.M{"$gte": at}}, nil
+	}
+	// field:date is the whole day of that date
+	y, m, d := at.Date()
+	day := time.Date(y, m, d, 0, 0, 0, 0, at.Location())
+	return bson.M{field: bson.M{"$gte": day, "$lt": day.AddDate(0, 0, 1)}}, nil
+}
+
+func isSpace(b byte) bool { return b == ' ' || b == '\t' || b == '\n' || b == '\r' }
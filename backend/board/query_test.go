@@ -0,0 +1,120 @@
+package kanban
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// offlineDB is a database nothing listens behind: lookups fail at once
+// instead of waiting for a server.
+func offlineDB(t testing.TB) *mongo.Database {
+	t.Helper()
+	client, err := mongo.Connect(context.Background(), options.Client().
+		ApplyURI("mongodb://127.0.0.1:1").
+		SetServerSelectionTimeout(time.Millisecond).
+		SetConnectTimeout(time.Millisecond))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = client.Disconnect(context.Background()) })
+	return client.Database("offline")
+}
+
+// formatTerms writes terms back as a query, quoting every value.
+func formatTerms(terms []queryTerm) string {
+	quote := strings.NewReplacer(`\`, `\\`, `"`, `\"`)
+	parts := make([]string, len(terms))
+	for i, t := range terms {
+		var b strings.Builder
+		if t.Neg {
+			b.WriteByte('-')
+		}
+		b.WriteString(t.Field + t.Op)
+		for j, v := range t.Values {
+			if j > 0 {
+				b.WriteByte(',')
+			}
+			b.WriteString(`"` + quote.Replace(v) + `"`)
+		}
+		parts[i] = b.String()
+	}
+	return strings.Join(parts, " ")
+}
+
+func FuzzParseQuery(f *testing.F) {
+	for _, seed := range []string{
+		``,
+		`status:"In Progress" assignee:me tag:bug due<7d -tag:wontfix`,
+		`title:"say \"hi\"" "two words" -"not this"`,
+		`tag:bug,feature,"won't fix" priority:high,none`,
+		`due>=2024-01-31 created<-2w updated>today start<=now`,
+		`(tag:bug OR tag:feature) -(status:done)`,
+		`is:overdue,assigned -is:subtask category:done`,
+		`- -- -tag: tag:, tag:a, :x <y "open`,
+		`lane:Срочно tag:ошибка "日本語" título:á 🙂 assignee:@jörg`,
+		`status:"a\\" tag:"\"" x\"y`,
+		"tag:a\tb\nc\r\"d\"",
+		"nul\x00 title:\"\x00\"",
+		`board:` + strings.Repeat("a,", 50) + `z`,
+	} {
+		f.Add(seed)
+	}
+	db := offlineDB(f)
+	f.Fuzz(func(t *testing.T, query string) {
+		terms, err := parseQuery(query)
+		if err != nil {
+			var qe *QueryError
+			if !errors.As(err, &qe) || qe.Pos < 0 || qe.Pos > len(query) {
+				t.Fatalf("parseQuery(%q): error %v has no position in the query", query, err)
+			}
+			return
+		}
+		for _, term := range terms {
+			if term.Pos < 0 || term.Pos >= len(query) {
+				t.Fatalf("parseQuery(%q): term at %d", query, term.Pos)
+			}
+			if len(term.Values) == 0 || (term.Field == "") != (term.Op == "") {
+				t.Fatalf("parseQuery(%q): malformed term %+v", query, term)
+			}
+			for _, v := range term.Values {
+				if v == "" {
+					t.Fatalf("parseQuery(%q): empty value in %+v", query, term)
+				}
+			}
+		}
+
+		// what was accepted reads back the same once written out again
+		again, err := parseQuery(formatTerms(terms))
+		if err != nil {
+			t.Fatalf("parseQuery(%q) = %+v, which does not parse back: %v", query, terms, err)
+		}
+		for i := range again {
+			again[i].Pos = terms[i].Pos
+		}
+		if !reflect.DeepEqual(again, terms) {
+			t.Fatalf("parseQuery(%q) = %+v, read back as %+v", query, terms, again)
+		}
+
+		// and compiles to a filter, or a positioned error; lookups fail
+		// here for want of a database
+		filter, err := compileQuery(context.Background(), db, query, "")
+		if err != nil {
+			var qe *QueryError
+			if errors.As(err, &qe) && (qe.Pos < 0 || qe.Pos >= len(query)) {
+				t.Fatalf("compileQuery(%q): error %v is outside the query", query, err)
+			}
+			return
+		}
+		if _, err := bson.Marshal(filter); err != nil {
+			t.Fatalf("compileQuery(%q) = %v, which is not a Mongo document: %v", query, filter, err)
+		}
+	})
+}
@@ -0,0 +1,561 @@
+package kanban
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// rrule is the subset of RFC 5545 recurrence rules we support:
+//
+//	FREQ=DAILY|WEEKLY|MONTHLY   required
+//	INTERVAL=n                  every n days, weeks or months (default 1)
+//	BYDAY=MO,WE,FR              weekly only; defaults to the start's weekday
+//	BYMONTHDAY=1,15,-1          monthly only; negative counts from the month's
+//	                            end; defaults to the start's day
+//	COUNT=n or UNTIL=YYYYMMDD[THHMMSSZ]  optional end, not both
+//
+// Months without the wanted day are skipped, as RFC 5545 says.
+type rrule struct {
+	freq       string
+	interval   int
+	byDay      []time.Weekday
+	byMonthDay []int
+	count      int
+	until      *time.Time
+}
+
+var rruleDays = map[string]time.Weekday{
+	"MO": time.Monday, "TU": time.Tuesday, "WE": time.Wednesday, "TH": time.Thursday,
+	"FR": time.Friday, "SA": time.Saturday, "SU": time.Sunday,
+}
+
+// maxRulePeriods bounds how many days, weeks or months a rule is walked, so
+// a rule that can never match (BYMONTHDAY=31 every 12 months from April)
+// doesn't spin.
+const maxRulePeriods = 50000
+
+func parseRRule(s string) (*rrule, error) {
+	r := &rrule{interval: 1}
+	s = strings.TrimPrefix(strings.TrimSpace(s), "RRULE:")
+	if s == "" {
+		return nil, errors.New("rule is required")
+	}
+	for _, part := range strings.Split(s, ";") {
+		key, value, ok := strings.Cut(part, "=")
+		if !ok || value == "" {
+			return nil, fmt.Errorf("bad rule part %q", part)
+		}
+		switch strings.ToUpper(key) {
+		case "FREQ":
+			r.freq = strings.ToUpper(value)
+			switch r.freq {
+			case "DAILY", "WEEKLY", "MONTHLY":
+			default:
+				return nil, fmt.Errorf("FREQ must be DAILY, WEEKLY or MONTHLY, not %q", value)
+			}
+		case "INTERVAL":
+			n, err := strconv.Atoi(value)
+			if err != nil || n < 1 || n > 1000 {
+				return nil, fmt.Errorf("INTERVAL must be 1..1000, not %q", value)
+			}
+			r.interval = n
+		case "BYDAY":
+			for _, d := range strings.Split(strings.ToUpper(value), ",") {
+				wd, ok := rruleDays[d]
+				if !ok {
+					return nil, fmt.Errorf("unknown BYDAY %q", d)
+				}
+				r.byDay = append(r.byDay, wd)
+			}
+		case "BYMONTHDAY":
+			for _, d := range strings.Split(value, ",") {
+				n, err := strconv.Atoi(d)
+				if err != nil || n == 0 || n < -31 || n > 31 {
+					return nil, fmt.Errorf("BYMONTHDAY must be 1..31 or -31..-1, not %q", d)
+				}
+				r.byMonthDay = append(r.byMonthDay, n)
+			}
+		case "COUNT":
+			n, err := strconv.Atoi(value)
+			if err != nil || n < 1 {
+				return nil, fmt.Errorf("COUNT must be a positive number, not %q", value)
+			}
+			r.count = n
+		case "UNTIL":
+			var t time.Time
+			var err error
+			if strings.Contains(value, "T") {
+				t, err = time.Parse("20060102T150405Z", value)
+			} else {
+				t, err = time.Parse("20060102", value)
+				t = t.Add(24*time.Hour - time.Second) // the whole day counts
+			}
+			if err != nil {
+				return nil, fmt.Errorf("UNTIL must be YYYYMMDD or YYYYMMDDTHHMMSSZ, not %q", value)
+			}
+			r.until = &t
+		default:
+			return nil, fmt.Errorf("unsupported rule part %q", key)
+		}
+	}
+	switch {
+	case r.freq == "":
+		return nil, errors.New("FREQ is required")
+	case r.count > 0 && r.until != nil:
+		return nil, errors.New("COUNT and UNTIL can't be combined")
+	case len(r.byDay) > 0 && r.freq != "WEEKLY":
+		return nil, errors.New("BYDAY is only supported with FREQ=WEEKLY")
+	case len(r.byMonthDay) > 0 && r.freq != "MONTHLY":
+		return nil, errors.New("BYMONTHDAY is only supported with FREQ=MONTHLY")
+	}
+	return r, nil
+}
+
+// each calls fn with every occurrence from start on, in order, until fn
+// returns false or the rule ends. Occurrences keep start's wall-clock time in
+// start's location, across daylight saving changes.
+func (r *rrule) each(start time.Time, fn func(time.Time) bool) {
+	y, m, d := start.Date()
+	hh, mm, ss := start.Clock()
+	loc := start.Location()
+	at := func(y int, m time.Month, d int) time.Time {
+		return time.Date(y, m, d, hh, mm, ss, 0, loc)
+	}
+
+	seen := 0
+	emit := func(t time.Time) bool {
+		if t.Before(start) {
+			return true
+		}
+		if r.until != nil && t.After(*r.until) {
+			return false
+		}
+		seen++
+		if !fn(t) {
+			return false
+		}
+		return r.count == 0 || seen < r.count
+	}
+
+	for period := 0; period < maxRulePeriods; period++ {
+		var candidates []time.Time
+		switch r.freq {
+		case "DAILY":
+			candidates = []time.Time{at(y, m, d+period*r.interval)}
+		case "WEEKLY":
+			days := r.byDay
+			if len(days) == 0 {
+				days = []time.Weekday{start.Weekday()}
+			}
+			// weeks start on Monday
+			monday := d - (int(start.Weekday())+6)%7 + period*7*r.interval
+			for _, wd := range days {
+				candidates = append(candidates, at(y, m, monday+(int(wd)+6)%7))
+			}
+		case "MONTHLY":
+			first := time.Date(y, m+time.Month(period*r.interval), 1, 0, 0, 0, 0, loc)
+			length := first.AddDate(0, 1, -1).Day()
+			days := r.byMonthDay
+			if len(days) == 0 {
+				days = []int{d}
+			}
+			for _, md := range days {
+				if md < 0 {
+					md = length + md + 1
+				}
+				if md < 1 || md > length {
+					continue
+				}
+				candidates = append(candidates, at(first.Year(), first.Month(), md))
+			}
+		}
+		sort.Slice(candidates, func(i, j int) bool { return candidates[i].Before(candidates[j]) })
+		for i, t := range candidates {
+			if i > 0 && t.Equal(candidates[i-1]) {
+				continue
+			}
+			if !emit(t) {
+				return
+			}
+		}
+	}
+}
+
+// after returns the first occurrence strictly after t, or nil when the rule
+// has ended.
+func (r *rrule) after(start, t time.Time) *time.Time {
+	var next *time.Time
+	r.each(start, func(o time.Time) bool {
+		if o.After(t) {
+			next = &o
+			return false
+		}
+		return true
+	})
+	return next
+}
+
+// upcoming returns up to n occurrences strictly after t.
+func (r *rrule) upcoming(start, t time.Time, n int) []time.Time {
+	out := []time.Time{}
+	r.each(start, func(o time.Time) bool {
+		if o.After(t) {
+			out = append(out, o)
+		}
+		return len(out) < n
+	})
+	return out
+}
+
+// Recurrence creates a copy of a template card in a column on a schedule.
+// The template is any card of the board; an archived one stays out of the
+// way. Instances carry RecurrenceID and Occurrence, which are unique together,
+// so an occurrence is created once however many replicas run the scheduler.
+type Recurrence struct {
+	ID             string     `bson:"_id,omitempty" json:"_id"`
+	BoardID        string     `bson:"boardId" json:"boardId"`
+	TemplateCardID string     `bson:"templateCardId" json:"templateCardId"`
+	StatusID       string     `bson:"statusId" json:"statusId"`                     // column instances are created in
+	Rule           string     `bson:"rule" json:"rule"`                             // see rrule
+	Start          time.Time  `bson:"start" json:"start"`                           // first possible occurrence, sets the time of day
+	Timezone       string     `bson:"timezone" json:"timezone"`                     // IANA name the rule is read in
+	DueAfter       int        `bson:"dueAfter,omitempty" json:"dueAfter,omitempty"` // minutes from occurrence to the instance's due date
+	Paused         bool       `bson:"paused" json:"paused"`
+	NextRun        *time.Time `bson:"nextRun,omitempty" json:"nextRun,omitempty"` // nil once the rule has ended
+	LastRun        *time.Time `bson:"lastRun,omitempty" json:"lastRun,omitempty"`
+	CreatedBy      string     `bson:"createdBy,omitempty" json:"createdBy,omitempty"`
+	CreatedAt      time.Time  `bson:"createdAt" json:"createdAt"`
+	UpdatedAt      time.Time  `bson:"updatedAt" json:"updatedAt"`
+
+	Upcoming []time.Time `bson:"-" json:"upcoming,omitempty"`
+}
+
+// compile parses the rule and returns it with the start in the recurrence's
+// time zone.
+func (rec *Recurrence) compile() (*rrule, time.Time, error) {
+	r, err := parseRRule(rec.Rule)
+	if err != nil {
+		return nil, time.Time{}, err
+	}
+	loc, err := time.LoadLocation(rec.Timezone)
+	if err != nil {
+		return nil, time.Time{}, fmt.Errorf("unknown timezone %q", rec.Timezone)
+	}
+	return r, rec.Start.In(loc), nil
+}
+
+// schedule sets NextRun to the first occurrence after t.
+func (rec *Recurrence) schedule(t time.Time) error {
+	r, start, err := rec.compile()
+	if err != nil {
+		return err
+	}
+	rec.NextRun = r.after(start, t)
+	return nil
+}
+
+// fillUpcoming lists the next n run times for the API.
+func (rec *Recurrence) fillUpcoming(n int) {
+	r, start, err := rec.compile()
+	if err != nil || rec.NextRun == nil || rec.Paused {
+		rec.Upcoming = nil
+		return
+	}
+	rec.Upcoming = append([]time.Time{*rec.NextRun}, r.upcoming(start, *rec.NextRun, n-1)...)
+}
+
+// instance builds the card for one occurrence from the template.
+func (rec *Recurrence) instance(tmpl *Card, occurrence time.Time) Card {
+	now := time.Now()
+	card := Card{
+		ID:           newID(),
+		BoardID:      rec.BoardID,
+		StatusID:     rec.StatusID,
+		LaneID:       tmpl.LaneID,
+		Title:        tmpl.Title,
+		Description:  tmpl.Description,
+		Color:        tmpl.Color,
+		Tags:         tmpl.Tags,
+		Labels:       tmpl.Labels,
+		Priority:     tmpl.Priority,
+		Assignees:    tmpl.Assignees,
+		Points:       tmpl.Points,
+		Fields:       tmpl.Fields,
+		Reminders:    tmpl.Reminders,
+		RecurrenceID: rec.ID,
+		Occurrence:   &occurrence,
+		CreatedBy:    rec.CreatedBy,
+		CreatedAt:    now,
+		UpdatedAt:    now,
+	}
+	if rec.DueAfter > 0 {
+		due := occurrence.Add(time.Duration(rec.DueAfter) * time.Minute)
+		card.DueDate = &due
+	}
+	for _, cl := range tmpl.Checklists {
+		copied := Checklist{ID: newID(), Name: cl.Name}
+		for _, item := range cl.Items {
+			copied.Items = append(copied.Items, ChecklistItem{ID: newID(), Text: item.Text, AssigneeID: item.AssigneeID})
+		}
+		card.Checklists = append(card.Checklists, copied)
+	}
+	return card
+}
+
+// runRecurrences creates the cards of every recurrence that is due. After
+// downtime only the latest missed occurrence is created. The card is
+// inserted before NextRun moves on: a crash in between is retried on the
+// next tick and the unique index turns the retry into a no-op, and the move
+// itself only applies if NextRun is still what was read.
+func runRecurrences(db *mongo.Database, changes *changeLog, now time.Time) {
+	ctx := context.TODO()
+	recColl := db.Collection("recurrences")
+	cardColl := db.Collection("cards")
+
+	var due []Recurrence
+	cur, err := recColl.Find(ctx, bson.M{"paused": false, "nextRun": bson.M{"$lte": now}})
+	if err != nil {
+		log.Println("kanban: recurrences:", err)
+		return
+	}
+	if err := cur.All(ctx, &due); err != nil {
+		log.Println("kanban: recurrences:", err)
+		return
+	}
+	for i := range due {
+		rec := &due[i]
+		r, start, err := rec.compile()
+		if err != nil {
+			log.Printf("kanban: recurrence %s: %v", rec.ID, err)
+			continue
+		}
+		occurrence := *rec.NextRun
+		for next := r.after(start, occurrence); next != nil && !next.After(now); next = r.after(start, *next) {
+			occurrence = *next
+		}
+
+		var tmpl Card
+		err = cardColl.FindOne(ctx, bson.M{"_id": rec.TemplateCardID, "deletedAt": nil}).Decode(&tmpl)
+		switch {
+		case err == nil:
+			card := rec.instance(&tmpl, occurrence)
+			if err := createCardFields(ctx, db, &card); err != nil {
+				// the template no longer fits the board: skip this occurrence
+				log.Printf("kanban: recurrence %s: %v", rec.ID, err)
+			} else if _, err := cardColl.InsertOne(ctx, card); err == nil {
+				changes.card("", nil, &card)
+			} else if !mongo.IsDuplicateKeyError(err) {
+				log.Printf("kanban: recurrence %s: %v", rec.ID, err)
+				continue
+			}
+		case errors.Is(err, mongo.ErrNoDocuments):
+			// template trashed or purged: skip this occurrence
+		default:
+			log.Printf("kanban: recurrence %s: %v", rec.ID, err)
+			continue
+		}
+
+		update := bson.M{"$set": bson.M{"lastRun": occurrence, "updatedAt": now}}
+		if next := r.after(start, occurrence); next != nil {
+			update["$set"].(bson.M)["nextRun"] = *next
+		} else {
+			update["$unset"] = bson.M{"nextRun": ""}
+		}
+		_, _ = recColl.UpdateOne(ctx, bson.M{"_id": rec.ID, "nextRun": rec.NextRun}, update)
+	}
+}
+
+// startRecurrenceScheduler creates due recurring cards every minute.
+func startRecurrenceScheduler(db *mongo.Database, changes *changeLog) {
+	go func() {
+		ticker := time.NewTicker(time.Minute)
+		defer ticker.Stop()
+		for {
+			<-ticker.C
+			runRecurrences(db, changes, time.Now())
+		}
+	}()
+}
+
+func registerRecurrenceRoutes(router *gin.Engine, db *mongo.Database) {
+	recColl := db.Collection("recurrences")
+	cardColl := db.Collection("cards")
+	_, _ = recColl.Indexes().CreateOne(context.TODO(), mongo.IndexModel{
+		Keys: bson.D{{Key: "paused", Value: 1}, {Key: "nextRun", Value: 1}},
+	})
+	_, _ = cardColl.Indexes().CreateOne(context.TODO(), mongo.IndexModel{
+		Keys: bson.D{{Key: "recurrenceId", Value: 1}, {Key: "occurrence", Value: 1}},
+		Options: options.Index().SetUnique(true).
+			SetPartialFilterExpression(bson.M{"recurrenceId": bson.M{"$exists": true}}),
+	})
+
+	upcomingCount := func(c *gin.Context) int {
+		n, _ := strconv.Atoi(c.DefaultQuery("upcoming", "5"))
+		if n < 1 || n > 100 {
+			n = 5
+		}
+		return n
+	}
+	// check validates a recurrence against its template card and column.
+	check := func(rec *Recurrence) (int, error) {
+		if rec.Timezone == "" {
+			rec.Timezone = "UTC"
+		}
+		if rec.DueAfter < 0 {
+			return http.StatusBadRequest, errors.New("dueAfter must be minutes after the occurrence")
+		}
+		if _, _, err := rec.compile(); err != nil {
+			return http.StatusBadRequest, err
+		}
+		var tmpl Card
+		if err := cardColl.FindOne(context.TODO(), bson.M{"_id": rec.TemplateCardID, "deletedAt": nil}).Decode(&tmpl); err != nil {
+			return http.StatusBadRequest, errors.New("template card not found")
+		}
+		rec.BoardID = tmpl.BoardID
+		if rec.StatusID == "" {
+			rec.StatusID = tmpl.StatusID
+		}
+		if n, _ := db.Collection("statuses").CountDocuments(context.TODO(), bson.M{"_id": rec.StatusID, "boardId": rec.BoardID, "deletedAt": nil}); n == 0 {
+			return http.StatusBadRequest, errors.New("statusId must be a column of the template's board")
+		}
+		return 0, nil
+	}
+
+	router.GET("/api/boards/:id/recurrences", func(c *gin.Context) {
+		cur, err := recColl.Find(context.TODO(), bson.M{"boardId": c.Param("id")}, options.Find().SetSort(bson.D{{Key: "_id", Value: 1}}))
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		out := []Recurrence{}
+		if err := cur.All(context.TODO(), &out); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		n := upcomingCount(c)
+		for i := range out {
+			out[i].fillUpcoming(n)
+		}
+		c.JSON(http.StatusOK, out)
+	})
+
+	// GET /api/recurrences/:id?upcoming=5 — with the next run times.
+	router.GET("/api/recurrences/:id", func(c *gin.Context) {
+		var rec Recurrence
+		if err := recColl.FindOne(context.TODO(), bson.M{"_id": c.Param("id")}).Decode(&rec); err != nil {
+			c.JSON(http.StatusNotFound, gin.H{"error": "recurrence not found"})
+			return
+		}
+		rec.fillUpcoming(upcomingCount(c))
+		c.JSON(http.StatusOK, rec)
+	})
+
+	// POST /api/recurrences {"templateCardId", "statusId", "rule", "start",
+	// "timezone", "dueAfter"} — statusId defaults to the template's column,
+	// start to now.
+	router.POST("/api/recurrences", func(c *gin.Context) {
+		var rec Recurrence
+		if err := c.BindJSON(&rec); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		now := time.Now()
+		if rec.Start.IsZero() {
+			rec.Start = now
+		}
+		rec.Start = rec.Start.Truncate(time.Second)
+		if status, err := check(&rec); err != nil {
+			c.JSON(status, gin.H{"error": err.Error()})
+			return
+		}
+		rec.ID = newID()
+		rec.LastRun = nil
+		rec.CreatedBy = currentUserID(c)
+		rec.CreatedAt, rec.UpdatedAt = now, now
+		// the start itself is the first occurrence when it is still ahead
+		if err := rec.schedule(now.Add(-time.Nanosecond)); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		if _, err := recColl.InsertOne(context.TODO(), rec); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		rec.fillUpcoming(upcomingCount(c))
+		c.JSON(http.StatusOK, rec)
+	})
+
+	// PUT /api/recurrences/:id replaces the settings and reschedules from
+	// now; setting "paused" stops runs without losing the rule.
+	router.PUT("/api/recurrences/:id", func(c *gin.Context) {
+		var before Recurrence
+		if err := recColl.FindOne(context.TODO(), bson.M{"_id": c.Param("id")}).Decode(&before); err != nil {
+			c.JSON(http.StatusNotFound, gin.H{"error": "recurrence not found"})
+			return
+		}
+		var rec Recurrence
+		if err := c.BindJSON(&rec); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		if rec.TemplateCardID == "" {
+			rec.TemplateCardID = before.TemplateCardID
+		}
+		if rec.Start.IsZero() {
+			rec.Start = before.Start
+		}
+		rec.Start = rec.Start.Truncate(time.Second)
+		if status, err := check(&rec); err != nil {
+			c.JSON(status, gin.H{"error": err.Error()})
+			return
+		}
+		if rec.BoardID != before.BoardID {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "the template must stay on the same board"})
+			return
+		}
+		now := time.Now()
+		rec.ID, rec.CreatedBy, rec.CreatedAt = before.ID, before.CreatedBy, before.CreatedAt
+		rec.LastRun, rec.UpdatedAt = before.LastRun, now
+		// never run an occurrence twice: continue after the last one made
+		from := now.Add(-time.Nanosecond)
+		if rec.LastRun != nil && rec.LastRun.After(from) {
+			from = *rec.LastRun
+		}
+		if err := rec.schedule(from); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		if _, err := recColl.ReplaceOne(context.TODO(), bson.M{"_id": rec.ID}, rec); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		rec.fillUpcoming(upcomingCount(c))
+		c.JSON(http.StatusOK, rec)
+	})
+
+	// DELETE /api/recurrences/:id stops the schedule; cards already created stay.
+	router.DELETE("/api/recurrences/:id", func(c *gin.Context) {
+		res, err := recColl.DeleteOne(context.TODO(), bson.M{"_id": c.Param("id")})
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		if res.DeletedCount == 0 {
+			c.JSON(http.StatusNotFound, gin.H{"error": "recurrence not found"})
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{"status": "deleted"})
+	})
+}
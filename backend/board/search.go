@@ -0,0 +1,308 @@
+package kanban
+
+import (
+	"context"
+	"html"
+	"net/http"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// maxSearchHits caps how many matches per collection are ranked for one
+// query; pages beyond that are not reachable.
+const maxSearchHits = 1000
+
+// SearchQuery is one page of a card search.
+type SearchQuery struct {
+	Text     string
+	BoardID  string // optional
+	Archived bool   // include archived cards
+	Page     int
+	Limit    int
+}
+
+// SearchHit is a matching card with its relevance and highlighted excerpts
+// from the title, description or comments that matched.
+type SearchHit struct {
+	Card     Card     `json:"card"`
+	Score    float64  `json:"score"`
+	Snippets []string `json:"snippets"`
+}
+
+// Searcher runs card searches. The Mongo text index implementation is the
+// only one for now; another engine only has to satisfy this.
+type Searcher interface {
+	Search(ctx context.Context, q SearchQuery) (hits []SearchHit, total int, err error)
+}
+
+// mongoSearcher ranks with $text over cards (title weighted above
+// description) and comments; a comment match adds half its score to its card.
+type mongoSearcher struct {
+	cards    *mongo.Collection
+	comments *mongo.Collection
+}
+
+func newMongoSearcher(db *mongo.Database) *mongoSearcher {
+	s := &mongoSearcher{cards: db.Collection("cards"), comments: db.Collection("comments")}
+	_, _ = s.cards.Indexes().CreateOne(context.TODO(), mongo.IndexModel{
+		Keys:    bson.D{{Key: "title", Value: "text"}, {Key: "description", Value: "text"}},
+		Options: options.Index().SetName("card_text").SetWeights(bson.M{"title": 10, "description": 3}),
+	})
+	_, _ = s.comments.Indexes().CreateOne(context.TODO(), mongo.IndexModel{
+		Keys:    bson.D{{Key: "body", Value: "text"}},
+		Options: options.Index().SetName("comment_text"),
+	})
+	return s
+}
+
+type scored struct {
+	ID    string  `bson:"_id"`
+	Score float64 `bson:"score"`
+}
+
+func (s *mongoSearcher) Search(ctx context.Context, q SearchQuery) ([]SearchHit, int, error) {
+	byScore := bson.M{"score": bson.M{"$meta": "textScore"}}
+	ranked := options.Find().
+		SetProjection(byScore).
+		SetSort(byScore).
+		SetLimit(maxSearchHits)
+
+	live := func(filter bson.M) bson.M {
+		filter["deletedAt"] = nil
+		if !q.Archived {
+			filter["archivedAt"] = nil
+		}
+		if q.BoardID != "" {
+			filter["boardId"] = q.BoardID
+		}
+		return filter
+	}
+
+	scores := map[string]float64{}
+	var cardHits []scored
+	cur, err := s.cards.Find(ctx, live(bson.M{"$text": bson.M{"$search": q.Text}}), ranked)
+	if err != nil {
+		return nil, 0, err
+	}
+	if err := cur.All(ctx, &cardHits); err != nil {
+		return nil, 0, err
+	}
+	for _, h := range cardHits {
+		scores[h.ID] = h.Score
+	}
+
+	var commentHits []struct {
+		CardID string  `bson:"cardId"`
+		Body   string  `bson:"body"`
+		Score  float64 `bson:"score"`
+	}
+	commentFilter := bson.M{"$text": bson.M{"$search": q.Text}, "deleted": bson.M{"$ne": true}}
+	if q.BoardID != "" {
+		commentFilter["boardId"] = q.BoardID
+	}
+	cur, err = s.comments.Find(ctx, commentFilter, options.Find().
+		SetProjection(bson.M{"score": bson.M{"$meta": "textScore"}, "cardId": 1, "body": 1}).
+		SetSort(byScore).
+		SetLimit(maxSearchHits))
+	if err != nil {
+		return nil, 0, err
+	}
+	if err := cur.All(ctx, &commentHits); err != nil {
+		return nil, 0, err
+	}
+	commentBodies := map[string][]string{}
+	for _, h := range commentHits {
+		scores[h.CardID] += h.Score / 2
+		commentBodies[h.CardID] = append(commentBodies[h.CardID], h.Body)
+	}
+
+	ids := make([]string, 0, len(scores))
+	for id := range scores {
+		ids = append(ids, id)
+	}
+	// comment matches can point at archived or trashed cards; keep live ones
+	var alive []scored
+	cur, err = s.cards.Find(ctx, live(bson.M{"_id": bson.M{"$in": ids}}), options.Find().SetProjection(bson.M{"_id": 1}))
+	if err != nil {
+		return nil, 0, err
+	}
+	if err := cur.All(ctx, &alive); err != nil {
+		return nil, 0, err
+	}
+	ids = ids[:0]
+	for _, a := range alive {
+		ids = append(ids, a.ID)
+	}
+	sort.Slice(ids, func(i, j int) bool {
+		if scores[ids[i]] != scores[ids[j]] {
+			return scores[ids[i]] > scores[ids[j]]
+		}
+		return ids[i] > ids[j] // newer first on ties
+	})
+
+	total := len(ids)
+	from := total // pages past the end are empty; checked so it can't overflow
+	if q.Page >= 1 && q.Limit > 0 && q.Page-1 <= total/q.Limit {
+		from = (q.Page - 1) * q.Limit
+		if from > total {
+			from = total
+		}
+	}
+	to := from + q.Limit
+	if to > total {
+		to = total
+	}
+	page := ids[from:to]
+	var cards []Card
+	if len(page) > 0 {
+		cur, err = s.cards.Find(ctx, bson.M{"_id": bson.M{"$in": page}})
+		if err != nil {
+			return nil, 0, err
+		}
+		if err := cur.All(ctx, &cards); err != nil {
+			return nil, 0, err
+		}
+	}
+	byID := map[string]Card{}
+	for _, card := range cards {
+		byID[card.ID] = card
+	}
+
+	terms := searchTerms(q.Text)
+	hits := make([]SearchHit, 0, len(page))
+	for _, id := range page {
+		card, ok := byID[id]
+		if !ok {
+			continue
+		}
+		card.fillProgress()
+		hit := SearchHit{Card: card, Score: scores[id], Snippets: []string{}}
+		for _, text := range append([]string{card.Title, card.Description}, commentBodies[id]...) {
+			if sn := snippet(text, terms); sn != "" {
+				hit.Snippets = append(hit.Snippets, sn)
+			}
+		}
+		hits = append(hits, hit)
+	}
+	return hits, total, nil
+}
+
+var searchWordRe = regexp.MustCompile(`-?"[^"]*"|\S+`)
+
+// searchTerms returns the words to highlight for a $text query: negated
+// words are skipped, phrases are kept whole, and common English endings are
+// trimmed so "running" still marks "run".
+func searchTerms(query string) []string {
+	var terms []string
+	for _, w := range searchWordRe.FindAllString(query, -1) {
+		if strings.HasPrefix(w, "-") {
+			continue
+		}
+		if strings.HasPrefix(w, `"`) {
+			if phrase := strings.Trim(w, `"`); phrase != "" {
+				terms = append(terms, phrase)
+			}
+			continue
+		}
+		w = strings.ToLower(strings.Trim(w, ".,;:!?()[]{}'"))
+		for _, suffix := range []string{"ing", "ed", "es", "s"} {
+			if len(w)-len(suffix) >= 3 && strings.HasSuffix(w, suffix) {
+				w = strings.TrimSuffix(w, suffix)
+				break
+			}
+		}
+		if w != "" {
+			terms = append(terms, w)
+		}
+	}
+	return terms
+}
+
+// snippet cuts up to snippetWidth runes around the first match in text and
+// wraps every match in <mark>; the rest is HTML-escaped. Empty when nothing
+// matches.
+func snippet(text string, terms []string) string {
+	const snippetWidth = 160
+	if text == "" || len(terms) == 0 {
+		return ""
+	}
+	quoted := make([]string, len(terms))
+	for i, t := range terms {
+		quoted[i] = regexp.QuoteMeta(t)
+	}
+	re, err := regexp.Compile(`(?i)(` + strings.Join(quoted, "|") + `)[\p{L}\p{N}]*`)
+	if err != nil {
+		return ""
+	}
+	first := re.FindStringIndex(text)
+	if first == nil {
+		return ""
+	}
+
+	runes := []rune(text)
+	at := len([]rune(text[:first[0]]))
+	start := at - snippetWidth/3
+	if start < 0 {
+		start = 0
+	}
+	end := start + snippetWidth
+	if end > len(runes) {
+		end = len(runes)
+	}
+	window := string(runes[start:end])
+
+	var b strings.Builder
+	if start > 0 {
+		b.WriteString("…")
+	}
+	last := 0
+	for _, m := range re.FindAllStringIndex(window, -1) {
+		b.WriteString(html.EscapeString(window[last:m[0]]))
+		b.WriteString("<mark>" + html.EscapeString(window[m[0]:m[1]]) + "</mark>")
+		last = m[1]
+	}
+	b.WriteString(html.EscapeString(window[last:]))
+	if end < len(runes) {
+		b.WriteString("…")
+	}
+	return b.String()
+}
+
+func registerSearchRoutes(router *gin.Engine, searcher Searcher) {
+	// GET /api/search?q=&boardId=&page=1&limit=20 — best matches first.
+	// ?archived=all includes archived cards.
+	router.GET("/api/search", func(c *gin.Context) {
+		q := SearchQuery{
+			Text:     strings.TrimSpace(c.Query("q")),
+			BoardID:  c.Query("boardId"),
+			Archived: c.Query("archived") == "all",
+		}
+		if q.Text == "" {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "q is required"})
+			return
+		}
+		q.Page, _ = strconv.Atoi(c.DefaultQuery("page", "1"))
+		q.Limit, _ = strconv.Atoi(c.DefaultQuery("limit", "20"))
+		if q.Page < 1 {
+			q.Page = 1
+		} else if q.Page > maxPage {
+			q.Page = maxPage
+		}
+		if q.Limit < 1 || q.Limit > 100 {
+			q.Limit = 20
+		}
+		hits, total, err := searcher.Search(context.TODO(), q)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{"items": hits, "total": total, "page": q.Page, "limit": q.Limit})
+	})
+}
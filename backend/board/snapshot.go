@@ -0,0 +1,289 @@
+package kanban
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// boardSnapshot is a board with everything that hangs off it. It is what
+// clones and templates copy from; ids inside are those of the source and
+// get replaced on insert.
+type boardSnapshot struct {
+	Board       Board        `bson:"board" json:"board"`
+	Statuses    []Status     `bson:"statuses" json:"statuses"`
+	Labels      []Label      `bson:"labels,omitempty" json:"labels,omitempty"`
+	Cards       []Card       `bson:"cards,omitempty" json:"cards,omitempty"`
+	Links       []CardLink   `bson:"links,omitempty" json:"links,omitempty"`
+	Attachments []Attachment `bson:"attachments,omitempty" json:"attachments,omitempty"`
+	Comments    []Comment    `bson:"comments,omitempty" json:"comments,omitempty"` // only filled by exports and importers
+}
+
+// loadSnapshot reads a live board. Cards (with the links between them and
+// their attachments) are only read when withCards is set; archived and
+// trashed documents are left out.
+func loadSnapshot(ctx context.Context, db *mongo.Database, boardID string, withCards bool) (*boardSnapshot, error) {
+	live := bson.M{"boardId": boardID, "deletedAt": nil, "archivedAt": nil}
+	byID := options.Find().SetSort(bson.D{{Key: "_id", Value: 1}})
+
+	snap := &boardSnapshot{}
+	if err := db.Collection("boards").FindOne(ctx, bson.M{"_id": boardID, "deletedAt": nil}).Decode(&snap.Board); err != nil {
+		return nil, err
+	}
+	cur, err := db.Collection("statuses").Find(ctx, live, byID)
+	if err != nil {
+		return nil, err
+	}
+	if err := cur.All(ctx, &snap.Statuses); err != nil {
+		return nil, err
+	}
+	cur, err = db.Collection("labels").Find(ctx, bson.M{"boardId": boardID}, byID)
+	if err != nil {
+		return nil, err
+	}
+	if err := cur.All(ctx, &snap.Labels); err != nil {
+		return nil, err
+	}
+	if !withCards {
+		return snap, nil
+	}
+	cur, err = db.Collection("cards").Find(ctx, live, byID)
+	if err != nil {
+		return nil, err
+	}
+	if err := cur.All(ctx, &snap.Cards); err != nil {
+		return nil, err
+	}
+	ids := make([]string, len(snap.Cards))
+	for i, card := range snap.Cards {
+		ids[i] = card.ID
+	}
+	if len(ids) == 0 {
+		return snap, nil
+	}
+	cur, err = db.Collection("card_links").Find(ctx, bson.M{"fromId": bson.M{"$in": ids}, "toId": bson.M{"$in": ids}})
+	if err != nil {
+		return nil, err
+	}
+	if err := cur.All(ctx, &snap.Links); err != nil {
+		return nil, err
+	}
+	cur, err = db.Collection("attachments").Find(ctx, bson.M{"cardId": bson.M{"$in": ids}}, byID)
+	if err != nil {
+		return nil, err
+	}
+	if err := cur.All(ctx, &snap.Attachments); err != nil {
+		return nil, err
+	}
+	return snap, nil
+}
+
+// insertAsNew writes the snapshot as a new board called name, giving every
+// document a fresh id and remapping the references between them. References
+// that point outside the snapshot are dropped. It returns the new board and
+// the old-to-new id map. A card without a required field fails it with a
+// *requiredFieldError before anything is written. If a write fails, what
+// was written is removed again so no half-created board is left behind.
+func (snap *boardSnapshot) insertAsNew(ctx context.Context, db *mongo.Database, name, actorID string) (*Board, map[string]string, error) {
+	now := time.Now()
+	ids := map[string]string{}
+	remap := func(old string) string {
+		if old == "" {
+			return ""
+		}
+		return ids[old]
+	}
+
+	b := snap.Board
+	ids[b.ID] = newID()
+	b.ID = ids[snap.Board.ID]
+	b.Name = name
+	b.DefaultViewID = "" // views stay with the source board
+	b.ArchivedAt, b.DeletedAt = nil, nil
+	b.Version = 0
+	b.CreatedAt, b.UpdatedAt = now, now
+	b.Lanes = append([]Swimlane(nil), snap.Board.Lanes...)
+	for i := range b.Lanes {
+		ids[b.Lanes[i].ID] = newID()
+		b.Lanes[i].ID = ids[snap.Board.Lanes[i].ID]
+	}
+	b.Fields = append([]CustomField(nil), snap.Board.Fields...)
+	fields := map[string]*CustomField{}
+	for i := range b.Fields {
+		ids[b.Fields[i].ID] = newID()
+		b.Fields[i].ID = ids[snap.Board.Fields[i].ID]
+		fields[b.Fields[i].ID] = &b.Fields[i]
+	}
+
+	statuses := make([]interface{}, len(snap.Statuses))
+	for i, st := range snap.Statuses {
+		ids[st.ID] = newID()
+		st.ID, st.BoardID = ids[st.ID], b.ID
+		st.ArchivedAt, st.DeletedAt, st.DeletedWith = nil, nil, ""
+		st.CreatedAt, st.UpdatedAt = now, now
+		statuses[i] = st
+	}
+	// Labels are matched by name, so repeats collapse into one; cards that
+	// only carry tags (importers) get labels made for them.
+	var newLabels []*Label
+	byKey := map[string]*Label{}
+	label := func(name, color, description string) *Label {
+		key := labelKey(name)
+		if l, ok := byKey[key]; ok {
+			return l
+		}
+		if !hexColor.MatchString(color) {
+			color = defaultLabelColor(key)
+		}
+		l := &Label{ID: newID(), BoardID: b.ID, Name: labelName(name), Key: key, Color: strings.ToLower(color),
+			Description: description, CreatedAt: now, UpdatedAt: now}
+		byKey[key] = l
+		newLabels = append(newLabels, l)
+		return l
+	}
+	oldLabels := map[string]*Label{}
+	for _, l := range snap.Labels {
+		if labelKey(l.Name) != "" {
+			oldLabels[l.ID] = label(l.Name, l.Color, l.Description)
+		}
+	}
+	for _, card := range snap.Cards {
+		ids[card.ID] = newID()
+	}
+	cards := make([]interface{}, len(snap.Cards))
+	for i, card := range snap.Cards {
+		card.ID, card.BoardID = ids[card.ID], b.ID
+		card.StatusID = remap(card.StatusID)
+		card.LaneID = remap(card.LaneID)
+		card.ParentID = remap(card.ParentID)
+		var cardLabels []Label
+		seen := map[string]bool{}
+		add := func(l *Label) {
+			if !seen[l.ID] {
+				seen[l.ID] = true
+				cardLabels = append(cardLabels, *l)
+			}
+		}
+		for _, id := range card.Labels {
+			if l, ok := oldLabels[id]; ok {
+				add(l)
+			}
+		}
+		if len(cardLabels) == 0 {
+			for _, t := range card.Tags {
+				if labelKey(t) != "" {
+					add(label(t, "", ""))
+				}
+			}
+		}
+		card.setLabels(cardLabels)
+		values := map[string]interface{}{}
+		for id, v := range card.Fields {
+			// values come back from JSON untyped; ones that no longer fit are dropped
+			if f := fields[remap(id)]; f != nil {
+				if clean, err := f.value(v); err == nil && clean != nil {
+					values[f.ID] = clean
+				}
+			}
+		}
+		card.Fields = nil
+		if len(values) > 0 {
+			card.Fields = values
+		}
+		if err := checkRequiredFields(&b, card.Fields); err != nil {
+			return nil, nil, fmt.Errorf("card %q: %w", card.Title, err)
+		}
+		card.SprintID = "" // sprints and recurrences are not copied
+		card.RecurrenceID, card.Occurrence = "", nil
+		card.CreatedBy = actorID
+		card.Version = 0
+		card.CreatedAt, card.UpdatedAt = now, now
+		card.ArchivedAt, card.DeletedAt, card.DeletedWith, card.TrashedStatusID = nil, nil, "", ""
+		card.RemindersSent, card.OverdueNotified = nil, false
+		card.Checklists = append([]Checklist(nil), card.Checklists...)
+		for j := range card.Checklists {
+			cl := &card.Checklists[j]
+			cl.ID = newID()
+			cl.Items = append([]ChecklistItem(nil), cl.Items...)
+			for k := range cl.Items {
+				cl.Items[k].ID = newID()
+				cl.Items[k].CardID = remap(cl.Items[k].CardID)
+			}
+		}
+		cards[i] = card
+	}
+	labels := make([]interface{}, len(newLabels))
+	for i, l := range newLabels {
+		labels[i] = *l
+	}
+	var links []interface{}
+	for _, l := range snap.Links {
+		from, to := remap(l.FromID), remap(l.ToID)
+		if from == "" || to == "" {
+			continue
+		}
+		l.ID, l.FromID, l.ToID = newID(), from, to
+		l.FromBoardID, l.ToBoardID = b.ID, b.ID
+		l.CreatedBy, l.CreatedAt = actorID, now
+		links = append(links, l)
+	}
+	var attachments []interface{}
+	for _, att := range snap.Attachments {
+		cardID := remap(att.CardID)
+		if cardID == "" {
+			continue
+		}
+		// the file is shared with the source; cleanupUploads counts both
+		att.ID, att.CardID, att.BoardID = newID(), cardID, b.ID
+		attachments = append(attachments, att)
+	}
+	for _, cm := range snap.Comments {
+		ids[cm.ID] = newID()
+	}
+	var comments []interface{}
+	for _, cm := range snap.Comments {
+		cardID := remap(cm.CardID)
+		if cardID == "" {
+			continue
+		}
+		cm.ID, cm.CardID, cm.BoardID = ids[cm.ID], cardID, b.ID
+		cm.ParentID = remap(cm.ParentID)
+		cm.Replies = nil
+		comments = append(comments, cm)
+	}
+
+	steps := []struct {
+		coll string
+		docs []interface{}
+		key  string // field holding the board id
+	}{
+		{"statuses", statuses, "boardId"},
+		{"labels", labels, "boardId"},
+		{"cards", cards, "boardId"},
+		{"card_links", links, "fromBoardId"},
+		{"attachments", attachments, "boardId"},
+		{"comments", comments, "boardId"},
+	}
+	if _, err := db.Collection("boards").InsertOne(ctx, b); err != nil {
+		return nil, nil, err
+	}
+	for i, step := range steps {
+		if len(step.docs) == 0 {
+			continue
+		}
+		if _, err := db.Collection(step.coll).InsertMany(ctx, step.docs); err != nil {
+			// InsertMany is ordered, so the failing step may have written some
+			for _, done := range steps[:i+1] {
+				_, _ = db.Collection(done.coll).DeleteMany(ctx, bson.M{done.key: b.ID})
+			}
+			_, _ = db.Collection("boards").DeleteOne(ctx, bson.M{"_id": b.ID})
+			return nil, nil, err
+		}
+	}
+	return &b, ids, nil
+}
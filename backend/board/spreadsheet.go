@@ -0,0 +1,404 @@
+package kanban
+
+import (
+	"archive/zip"
+	"bufio"
+	"context"
+	"encoding/csv"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"bell-backend/models"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// sheetWriter writes a table row by row. Cells are strings, or float64 for
+// numbers.
+type sheetWriter interface {
+	row(cells []interface{}) error
+	close() error
+}
+
+type csvSheet struct{ w *csv.Writer }
+
+func (s *csvSheet) row(cells []interface{}) error {
+	rec := make([]string, len(cells))
+	for i, v := range cells {
+		if f, ok := v.(float64); ok {
+			rec[i] = strconv.FormatFloat(f, 'f', -1, 64)
+		} else {
+			rec[i] = v.(string)
+		}
+	}
+	if err := s.w.Write(rec); err != nil {
+		return err
+	}
+	s.w.Flush()
+	return s.w.Error()
+}
+
+func (s *csvSheet) close() error {
+	s.w.Flush()
+	return s.w.Error()
+}
+
+// xlsxSheet writes a single-sheet workbook with inline strings, so rows can
+// go out as they come without a shared string table.
+type xlsxSheet struct {
+	zw   *zip.Writer
+	w    *bufio.Writer
+	rows int
+}
+
+var xlsxParts = []struct{ name, body string }{
+	{"[Content_Types].xml", `<?xml version="1.0" encoding="UTF-8" standalone="yes"?>
+<Types xmlns="http://schemas.openxmlformats.org/package/2006/content-types"><Default Extension="rels" ContentType="application/vnd.openxmlformats-package.relationships+xml"/><Default Extension="xml" ContentType="application/xml"/><Override PartName="/xl/workbook.xml" ContentType="application/vnd.openxmlformats-officedocument.spreadsheetml.sheet.main+xml"/><Override PartName="/xl/worksheets/sheet1.xml" ContentType="application/vnd.openxmlformats-officedocument.spreadsheetml.worksheet+xml"/></Types>`},
+	{"_rels/.rels", `<?xml version="1.0" encoding="UTF-8" standalone="yes"?>
+<Relationships xmlns="http://schemas.openxmlformats.org/package/2006/relationships"><Relationship Id="rId1" Type="http://schemas.openxmlformats.org/officeDocument/2006/relationships/officeDocument" Target="xl/workbook.xml"/></Relationships>`},
+	{"xl/workbook.xml", `<?xml version="1.0" encoding="UTF-8" standalone="yes"?>
+<workbook xmlns="http://schemas.openxmlformats.org/spreadsheetml/2006/main" xmlns:r="http://schemas.openxmlformats.org/officeDocument/2006/relationships"><sheets><sheet name="Cards" sheetId="1" r:id="rId1"/></sheets></workbook>`},
+	{"xl/_rels/workbook.xml.rels", `<?xml version="1.0" encoding="UTF-8" standalone="yes"?>
+<Relationships xmlns="http://schemas.openxmlformats.org/package/2006/relationships"><Relationship Id="rId1" Type="http://schemas.openxmlformats.org/officeDocument/2006/relationships/worksheet" Target="worksheets/sheet1.xml"/></Relationships>`},
+}
+
+func newXLSXSheet(w io.Writer) (*xlsxSheet, error) {
+	zw := zip.NewWriter(w)
+	for _, part := range xlsxParts {
+		pw, err := zw.Create(part.name)
+		if err != nil {
+			return nil, err
+		}
+		if _, err := io.WriteString(pw, part.body); err != nil {
+			return nil, err
+		}
+	}
+	sw, err := zw.Create("xl/worksheets/sheet1.xml")
+	if err != nil {
+		return nil, err
+	}
+	s := &xlsxSheet{zw: zw, w: bufio.NewWriter(sw)}
+	_, err = s.w.WriteString(`<?xml version="1.0" encoding="UTF-8" standalone="yes"?>
+<worksheet xmlns="http://schemas.openxmlformats.org/spreadsheetml/2006/main"><sheetData>`)
+	return s, err
+}
+
+// columnName turns 0, 1, ... 26 into A, B, ... AA.
+func columnName(i int) string {
+	name := ""
+	for i++; i > 0; i = (i - 1) / 26 {
+		name = string(rune('A'+(i-1)%26)) + name
+	}
+	return name
+}
+
+func (s *xlsxSheet) row(cells []interface{}) error {
+	s.rows++
+	fmt.Fprintf(s.w, `<row r="%d">`, s.rows)
+	for i, v := range cells {
+		ref := columnName(i) + strconv.Itoa(s.rows)
+		if f, ok := v.(float64); ok {
+			fmt.Fprintf(s.w, `<c r="%s"><v>%s</v></c>`, ref, strconv.FormatFloat(f, 'f', -1, 64))
+			continue
+		}
+		fmt.Fprintf(s.w, `<c r="%s" t="inlineStr"><is><t xml:space="preserve">`, ref)
+		if err := xml.EscapeText(s.w, []byte(v.(string))); err != nil {
+			return err
+		}
+		s.w.WriteString(`</t></is></c>`)
+	}
+	_, err := s.w.WriteString(`</row>`)
+	return err
+}
+
+func (s *xlsxSheet) close() error {
+	if _, err := s.w.WriteString(`</sheetData></worksheet>`); err != nil {
+		return err
+	}
+	if err := s.w.Flush(); err != nil {
+		return err
+	}
+	return s.zw.Close()
+}
+
+// sheetLookup resolves ids to names for exports.
+type sheetLookup struct {
+	boards   map[string]string
+	lanes    map[string]string
+	statuses map[string]string
+	users    map[string]string
+	fields   map[string][]CustomField // by board id
+}
+
+func loadSheetLookup(ctx context.Context, db *mongo.Database) (*sheetLookup, error) {
+	l := &sheetLookup{boards: map[string]string{}, lanes: map[string]string{}, statuses: map[string]string{}, users: map[string]string{}, fields: map[string][]CustomField{}}
+	var boards []Board
+	cur, err := db.Collection("boards").Find(ctx, bson.M{})
+	if err != nil {
+		return nil, err
+	}
+	if err := cur.All(ctx, &boards); err != nil {
+		return nil, err
+	}
+	for _, b := range boards {
+		l.boards[b.ID] = b.Name
+		l.fields[b.ID] = b.Fields
+		for _, lane := range b.Lanes {
+			l.lanes[lane.ID] = lane.Name
+		}
+	}
+	var statuses []Status
+	if cur, err = db.Collection("statuses").Find(ctx, bson.M{}); err != nil {
+		return nil, err
+	}
+	if err := cur.All(ctx, &statuses); err != nil {
+		return nil, err
+	}
+	for _, st := range statuses {
+		l.statuses[st.ID] = st.Name
+	}
+	var users []models.User
+	if cur, err = db.Collection("users").Find(ctx, bson.M{}); err != nil {
+		return nil, err
+	}
+	if err := cur.All(ctx, &users); err != nil {
+		return nil, err
+	}
+	for _, u := range users {
+		l.users[u.ID.Hex()] = u.Name
+	}
+	return l, nil
+}
+
+// loadSheetFields reads only the custom fields, for ?resolve=false.
+func loadSheetFields(ctx context.Context, db *mongo.Database) (map[string][]CustomField, error) {
+	var boards []Board
+	cur, err := db.Collection("boards").Find(ctx, bson.M{}, options.Find().SetProjection(bson.M{"fields": 1}))
+	if err != nil {
+		return nil, err
+	}
+	if err := cur.All(ctx, &boards); err != nil {
+		return nil, err
+	}
+	fields := map[string][]CustomField{}
+	for _, b := range boards {
+		fields[b.ID] = b.Fields
+	}
+	return fields, nil
+}
+
+func nameOf(names map[string]string, id string) string {
+	if n, ok := names[id]; ok && n != "" {
+		return n
+	}
+	return id
+}
+
+// sheetCard is a card on its way into a spreadsheet row.
+type sheetCard struct {
+	*Card
+	names *sheetLookup
+	loc   *time.Location
+}
+
+func (r sheetCard) date(t *time.Time) string {
+	if t == nil || t.IsZero() {
+		return ""
+	}
+	return t.In(r.loc).Format("2006-01-02 15:04")
+}
+
+// field is the value of the custom field called name on the card's board.
+func (r sheetCard) field(name string) interface{} {
+	for _, f := range r.names.fields[r.BoardID] {
+		if !strings.EqualFold(f.Name, name) {
+			continue
+		}
+		v, err := f.value(r.Fields[f.ID])
+		if err != nil || v == nil {
+			return ""
+		}
+		switch x := v.(type) {
+		case float64:
+			return x
+		case time.Time:
+			return r.date(&x)
+		case []string:
+			return strings.Join(x, ", ")
+		}
+		if f.Type == FieldUser {
+			return nameOf(r.names.users, v.(string))
+		}
+		return v.(string)
+	}
+	return ""
+}
+
+// cardColumns are the exportable columns. "status", "board", "lane",
+// "assignees" and "createdBy" show names unless ?resolve=false; the *Id
+// columns always show ids.
+var cardColumns = map[string]func(r sheetCard) interface{}{
+	"id":          func(r sheetCard) interface{} { return r.ID },
+	"title":       func(r sheetCard) interface{} { return r.Title },
+	"description": func(r sheetCard) interface{} { return r.Description },
+	"boardId":     func(r sheetCard) interface{} { return r.BoardID },
+	"board":       func(r sheetCard) interface{} { return nameOf(r.names.boards, r.BoardID) },
+	"statusId":    func(r sheetCard) interface{} { return r.StatusID },
+	"status":      func(r sheetCard) interface{} { return nameOf(r.names.statuses, r.StatusID) },
+	"lane":        func(r sheetCard) interface{} { return nameOf(r.names.lanes, r.LaneID) },
+	"position":    func(r sheetCard) interface{} { return r.Position },
+	"color":       func(r sheetCard) interface{} { return r.Color },
+	"tags":        func(r sheetCard) interface{} { return strings.Join(r.Tags, ", ") },
+	"priority":    func(r sheetCard) interface{} { return r.Priority },
+	"parentId":    func(r sheetCard) interface{} { return r.ParentID },
+	"sprintId":    func(r sheetCard) interface{} { return r.SprintID },
+	"points":      func(r sheetCard) interface{} { return r.Points },
+	"assignees": func(r sheetCard) interface{} {
+		names := make([]string, len(r.Assignees))
+		for i, id := range r.Assignees {
+			names[i] = nameOf(r.names.users, id)
+		}
+		return strings.Join(names, ", ")
+	},
+	"createdBy": func(r sheetCard) interface{} { return nameOf(r.names.users, r.CreatedBy) },
+	"startDate": func(r sheetCard) interface{} { return r.date(r.StartDate) },
+	"dueDate":   func(r sheetCard) interface{} { return r.date(r.DueDate) },
+	"createdAt": func(r sheetCard) interface{} { return r.date(&r.CreatedAt) },
+	"updatedAt": func(r sheetCard) interface{} { return r.date(&r.UpdatedAt) },
+	"progress": func(r sheetCard) interface{} {
+		if r.Progress == nil || r.Progress.Total == 0 {
+			return ""
+		}
+		return float64(r.Progress.Done) / float64(r.Progress.Total)
+	},
+}
+
+// cardColumn returns the column called name: one of cardColumns, or
+// "field.<name>" for a custom field.
+func cardColumn(name string) func(r sheetCard) interface{} {
+	if col := cardColumns[name]; col != nil {
+		return col
+	}
+	if field := strings.TrimPrefix(name, "field."); field != name && field != "" {
+		return func(r sheetCard) interface{} { return r.field(field) }
+	}
+	return nil
+}
+
+var defaultCardColumns = []string{"title", "status", "tags", "priority", "assignees", "dueDate", "createdAt"}
+
+func registerSpreadsheetRoutes(router *gin.Engine, db *mongo.Database) {
+	cardColl := db.Collection("cards")
+
+	// GET /api/cards/export?format=csv|xlsx — takes every GET /api/cards
+	// filter, plus ?columns=a,b,c (field.<name> for a custom field),
+	// ?resolve=false and ?tz=Europe/Berlin for the dates. Rows are written
+	// as the cursor delivers them.
+	router.GET("/api/cards/export", func(c *gin.Context) {
+		format := c.DefaultQuery("format", "csv")
+		if format != "csv" && format != "xlsx" {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "format must be csv or xlsx"})
+			return
+		}
+		columns := append([]string(nil), defaultCardColumns...)
+		if v := c.Query("columns"); v != "" {
+			columns = strings.Split(v, ",")
+		}
+		cells := make([]func(r sheetCard) interface{}, len(columns))
+		withFields := false
+		for i, col := range columns {
+			columns[i] = strings.TrimSpace(col)
+			if cells[i] = cardColumn(columns[i]); cells[i] == nil {
+				c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("unknown column %q", columns[i])})
+				return
+			}
+			withFields = withFields || strings.HasPrefix(columns[i], "field.")
+		}
+		loc := time.UTC
+		if tz := c.Query("tz"); tz != "" {
+			var err error
+			if loc, err = time.LoadLocation(tz); err != nil {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "unknown time zone " + tz})
+				return
+			}
+		}
+		lookup := &sheetLookup{}
+		if c.Query("resolve") != "false" {
+			var err error
+			if lookup, err = loadSheetLookup(context.TODO(), db); err != nil {
+				c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+				return
+			}
+		}
+		if lookup.fields == nil && withFields {
+			var err error
+			if lookup.fields, err = loadSheetFields(context.TODO(), db); err != nil {
+				c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+				return
+			}
+		}
+		filter, opts, err := cardQuery(c, db)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		cur, err := cardColl.Find(context.TODO(), filter, opts)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		defer cur.Close(context.TODO())
+
+		filename := "cards-" + time.Now().In(loc).Format("2006-01-02") + "." + format
+		c.Header("Content-Disposition", fmt.Sprintf(`attachment; filename="%s"`, filename))
+		var sheet sheetWriter
+		if format == "xlsx" {
+			c.Header("Content-Type", "application/vnd.openxmlformats-officedocument.spreadsheetml.sheet")
+			c.Status(http.StatusOK)
+			if sheet, err = newXLSXSheet(c.Writer); err != nil {
+				_ = c.Error(err)
+				return
+			}
+		} else {
+			c.Header("Content-Type", "text/csv; charset=utf-8")
+			c.Status(http.StatusOK)
+			sheet = &csvSheet{w: csv.NewWriter(c.Writer)}
+		}
+
+		header := make([]interface{}, len(columns))
+		for i, col := range columns {
+			header[i] = col
+		}
+		if err := sheet.row(header); err != nil {
+			_ = c.Error(err)
+			return
+		}
+		for cur.Next(context.TODO()) {
+			var card Card
+			if err := cur.Decode(&card); err != nil {
+				_ = c.Error(err)
+				return
+			}
+			card.fillProgress()
+			row := make([]interface{}, len(columns))
+			for i, cell := range cells {
+				row[i] = cell(sheetCard{&card, lookup, loc})
+			}
+			if err := sheet.row(row); err != nil {
+				_ = c.Error(err) // client went away
+				return
+			}
+		}
+		if err := sheet.close(); err != nil {
+			_ = c.Error(err)
+		}
+	})
+}
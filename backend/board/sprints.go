@@ -0,0 +1,727 @@
+package kanban
+
+import (
+	"context"
+	"errors"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// Sprint states.
+const (
+	SprintPlanned = "planned"
+	SprintActive  = "active"
+	SprintClosed  = "closed"
+)
+
+// Sprint is an iteration on a board. Cards join through Card.SprintID and
+// are estimated with Card.Points. A board has at most one active sprint.
+type Sprint struct {
+	ID        string     `bson:"_id,omitempty" json:"_id"`
+	BoardID   string     `bson:"boardId" json:"boardId"`
+	Name      string     `bson:"name" json:"name"`
+	Goal      string     `bson:"goal,omitempty" json:"goal,omitempty"`
+	StartDate time.Time  `bson:"startDate" json:"startDate"`
+	EndDate   time.Time  `bson:"endDate" json:"endDate"`
+	State     string     `bson:"state" json:"state"`
+	StartedAt *time.Time `bson:"startedAt,omitempty" json:"startedAt,omitempty"`
+	ClosedAt  *time.Time `bson:"closedAt,omitempty" json:"closedAt,omitempty"`
+	// Points in the sprint when it started, and done when it closed.
+	Committed float64   `bson:"committed" json:"committed"`
+	Completed float64   `bson:"completed" json:"completed"`
+	CreatedAt time.Time `bson:"createdAt" json:"createdAt"`
+	UpdatedAt time.Time `bson:"updatedAt" json:"updatedAt"`
+}
+
+// sprintInput is what clients send when creating or editing a sprint.
+type sprintInput struct {
+	Name      *string `json:"name"`
+	Goal      *string `json:"goal"`
+	StartDate *string `json:"startDate"`
+	EndDate   *string `json:"endDate"`
+}
+
+// apply copies the given fields onto s and checks the dates.
+func (in *sprintInput) apply(s *Sprint) error {
+	if in.Name != nil {
+		s.Name = strings.TrimSpace(*in.Name)
+	}
+	if in.Goal != nil {
+		s.Goal = *in.Goal
+	}
+	for _, d := range []struct {
+		in  *string
+		out *time.Time
+	}{{in.StartDate, &s.StartDate}, {in.EndDate, &s.EndDate}} {
+		if d.in == nil {
+			continue
+		}
+		t, err := parseDate(*d.in)
+		if err != nil {
+			return err
+		}
+		*d.out = t
+	}
+	if s.Name == "" {
+		return errors.New("name is required")
+	}
+	if s.StartDate.IsZero() || s.EndDate.IsZero() {
+		return errors.New("startDate and endDate are required")
+	}
+	if !s.EndDate.After(s.StartDate) {
+		return errors.New("endDate must be after startDate")
+	}
+	return nil
+}
+
+// checkCardSprint checks the sprint a card is created with (before nil) or
+// put into by a PUT: it must be a sprint of the card's board that isn't
+// closed. A card moving to another board leaves its sprint unless the body
+// names one there.
+func checkCardSprint(ctx context.Context, db *mongo.Database, before, card *Card) error {
+	boardID, oldBoard, oldSprint := card.BoardID, card.BoardID, ""
+	if before != nil {
+		oldBoard, oldSprint = before.BoardID, before.SprintID
+		if boardID == "" {
+			boardID = before.BoardID
+		}
+	}
+	if card.SprintID == "" {
+		if boardID != oldBoard && oldSprint != "" && !containsString(card.cleared, "sprintId") {
+			card.cleared = append(card.cleared, "sprintId")
+		}
+		return nil
+	}
+	if card.SprintID == oldSprint && boardID == oldBoard {
+		return nil
+	}
+	n, err := db.Collection("sprints").CountDocuments(ctx, bson.M{"_id": card.SprintID, "boardId": boardID, "state": bson.M{"$ne": SprintClosed}})
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return errors.New("sprintId must be a planned or active sprint of the card's board")
+	}
+	return nil
+}
+
+// doneStatuses returns the ids of a board's done columns, trashed ones
+// included so history keeps counting.
+func doneStatuses(ctx context.Context, db *mongo.Database, boardID string) map[string]bool {
+	done := map[string]bool{}
+	var statuses []Status
+	if cur, err := db.Collection("statuses").Find(ctx, bson.M{"boardId": boardID, "category": CategoryDone}); err == nil {
+		_ = cur.All(ctx, &statuses)
+	}
+	for _, st := range statuses {
+		done[st.ID] = true
+	}
+	return done
+}
+
+// BurndownDay is one day of a sprint: the points in it (scope), the points
+// done and those left, plus the ideal remaining line.
+type BurndownDay struct {
+	Date      string  `json:"date"`
+	Scope     float64 `json:"scope"`
+	Completed float64 `json:"completed"`
+	Remaining float64 `json:"remaining"`
+	Ideal     float64 `json:"ideal"`
+}
+
+// fieldHistory is a card field over time: its value before the first
+// recorded change (the current value if it never changed), then each change.
+type fieldHistory struct {
+	initial interface{}
+	changes []struct {
+		at  time.Time
+		val interface{}
+	}
+}
+
+// at returns the value the field had at t.
+func (h *fieldHistory) at(t time.Time) interface{} {
+	v := h.initial
+	for _, ch := range h.changes {
+		if ch.at.After(t) {
+			break
+		}
+		v = ch.val
+	}
+	return v
+}
+
+// sprintBurndown rebuilds a sprint day by day from card activity (sprint
+// membership and points) and status transitions (done or not).
+func sprintBurndown(ctx context.Context, db *mongo.Database, sp *Sprint) ([]BurndownDay, error) {
+	activityColl := db.Collection("card_activity")
+	// every card that is or ever was in the sprint
+	ids := map[string]bool{}
+	var current []Card
+	cur, err := db.Collection("cards").Find(ctx, bson.M{"sprintId": sp.ID})
+	if err != nil {
+		return nil, err
+	}
+	if err := cur.All(ctx, &current); err != nil {
+		return nil, err
+	}
+	for _, card := range current {
+		ids[card.ID] = true
+	}
+	var touched []CardActivity
+	cur, err = activityColl.Find(ctx, bson.M{"changes": bson.M{"$elemMatch": bson.M{
+		"field": "sprintId",
+		"$or":   bson.A{bson.M{"old": sp.ID}, bson.M{"new": sp.ID}},
+	}}})
+	if err != nil {
+		return nil, err
+	}
+	if err := cur.All(ctx, &touched); err != nil {
+		return nil, err
+	}
+	for _, a := range touched {
+		ids[a.CardID] = true
+	}
+	cardIDs := make([]string, 0, len(ids))
+	for id := range ids {
+		cardIDs = append(cardIDs, id)
+	}
+
+	var cards []Card
+	if cur, err = db.Collection("cards").Find(ctx, bson.M{"_id": bson.M{"$in": cardIDs}}); err != nil {
+		return nil, err
+	}
+	if err := cur.All(ctx, &cards); err != nil {
+		return nil, err
+	}
+	var entries []CardActivity
+	cur, err = activityColl.Find(ctx, bson.M{"cardId": bson.M{"$in": cardIDs}, "changes.field": bson.M{"$in": bson.A{"sprintId", "points"}}},
+		options.Find().SetSort(bson.D{{Key: "createdAt", Value: 1}}))
+	if err != nil {
+		return nil, err
+	}
+	if err := cur.All(ctx, &entries); err != nil {
+		return nil, err
+	}
+	var transitions []StatusTransition
+	cur, err = db.Collection("status_transitions").Find(ctx, bson.M{"cardId": bson.M{"$in": cardIDs}},
+		options.Find().SetSort(bson.D{{Key: "at", Value: 1}, {Key: "_id", Value: 1}}))
+	if err != nil {
+		return nil, err
+	}
+	if err := cur.All(ctx, &transitions); err != nil {
+		return nil, err
+	}
+	statusAt := map[string][]cardStep{}
+	for _, t := range transitions {
+		statusAt[t.CardID] = append(statusAt[t.CardID], cardStep{To: t.To, At: t.At})
+	}
+
+	type history struct {
+		created time.Time
+		sprint  fieldHistory
+		points  fieldHistory
+	}
+	histories := map[string]*history{}
+	for _, card := range cards {
+		histories[card.ID] = &history{
+			created: card.CreatedAt,
+			sprint:  fieldHistory{initial: card.SprintID},
+			points:  fieldHistory{initial: card.Points},
+		}
+	}
+	for _, e := range entries {
+		h := histories[e.CardID]
+		if h == nil {
+			continue
+		}
+		for _, ch := range e.Changes {
+			var fh *fieldHistory
+			switch ch.Field {
+			case "sprintId":
+				fh = &h.sprint
+			case "points":
+				fh = &h.points
+			default:
+				continue
+			}
+			if len(fh.changes) == 0 {
+				fh.initial = ch.Old // the value before the first recorded change
+			}
+			fh.changes = append(fh.changes, struct {
+				at  time.Time
+				val interface{}
+			}{e.CreatedAt, ch.New})
+		}
+	}
+
+	done := doneStatuses(ctx, db, sp.BoardID)
+	y, m, d := sp.StartDate.UTC().Date()
+	first := time.Date(y, m, d, 0, 0, 0, 0, time.UTC)
+	last := sp.EndDate
+	if sp.ClosedAt != nil && sp.ClosedAt.Before(last) {
+		last = *sp.ClosedAt
+	}
+	now := time.Now()
+	var days []BurndownDay
+	for day := first; day.Before(last); day = day.AddDate(0, 0, 1) {
+		at := day.AddDate(0, 0, 1)
+		if at.After(now) {
+			if day.After(now) {
+				break
+			}
+			at = now
+		}
+		bd := BurndownDay{Date: day.Format("2006-01-02")}
+		for id, h := range histories {
+			if h.created.After(at) {
+				continue
+			}
+			if sid, _ := h.sprint.at(at).(string); sid != sp.ID {
+				continue
+			}
+			points, _ := h.points.at(at).(float64)
+			bd.Scope += points
+			status := ""
+			for _, st := range statusAt[id] {
+				if st.At.After(at) {
+					break
+				}
+				status = st.To
+			}
+			if done[status] {
+				bd.Completed += points
+			}
+		}
+		bd.Remaining = bd.Scope - bd.Completed
+		days = append(days, bd)
+	}
+
+	// ideal line: from the first day's scope down to zero at the end date
+	total := math.Ceil(sp.EndDate.Sub(first).Hours() / 24)
+	for i := range days {
+		left := 1 - float64(i+1)/total
+		if left < 0 {
+			left = 0
+		}
+		days[i].Ideal = math.Round(days[0].Scope*left*100) / 100
+	}
+	return days, nil
+}
+
+func registerSprintRoutes(router *gin.Engine, db *mongo.Database, changes *changeLog) {
+	sprintColl := db.Collection("sprints")
+	cardColl := db.Collection("cards")
+	_, _ = sprintColl.Indexes().CreateMany(context.TODO(), []mongo.IndexModel{
+		{Keys: bson.D{{Key: "boardId", Value: 1}, {Key: "startDate", Value: 1}}},
+		{
+			// at most one active sprint per board, even with concurrent starts
+			Keys: bson.D{{Key: "boardId", Value: 1}},
+			Options: options.Index().SetUnique(true).
+				SetPartialFilterExpression(bson.M{"state": SprintActive}),
+		},
+	})
+
+	loadSprint := func(c *gin.Context) (*Sprint, bool) {
+		var sp Sprint
+		if err := sprintColl.FindOne(context.TODO(), bson.M{"_id": c.Param("id")}).Decode(&sp); err != nil {
+			c.JSON(http.StatusNotFound, gin.H{"error": "sprint not found"})
+			return nil, false
+		}
+		return &sp, true
+	}
+	// sprintCards returns the live cards of a sprint.
+	sprintCards := func(sprintID string) ([]Card, error) {
+		cur, err := cardColl.Find(context.TODO(), bson.M{"sprintId": sprintID, "deletedAt": nil, "archivedAt": nil})
+		if err != nil {
+			return nil, err
+		}
+		var cards []Card
+		err = cur.All(context.TODO(), &cards)
+		return cards, err
+	}
+	// setSprint moves a card into sprintID ("" takes it out) and reports it.
+	setSprint := func(c *gin.Context, card *Card, sprintID string) error {
+		update := bson.M{"$set": bson.M{"sprintId": sprintID, "updatedAt": time.Now()}}
+		if sprintID == "" {
+			update = bson.M{"$unset": bson.M{"sprintId": ""}, "$set": bson.M{"updatedAt": time.Now()}}
+		}
+		if _, err := cardColl.UpdateOne(context.TODO(), bson.M{"_id": card.ID}, withVersion(update)); err != nil {
+			return err
+		}
+		after := *card
+		after.SprintID = sprintID
+		after.Version++
+		changes.card(currentUserID(c), card, &after)
+		return nil
+	}
+
+	router.GET("/api/boards/:id/sprints", func(c *gin.Context) {
+		filter := bson.M{"boardId": c.Param("id")}
+		if state := c.Query("state"); state != "" {
+			filter["state"] = state
+		}
+		cur, err := sprintColl.Find(context.TODO(), filter, options.Find().SetSort(bson.D{{Key: "startDate", Value: 1}}))
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		out := []Sprint{}
+		if err := cur.All(context.TODO(), &out); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(http.StatusOK, out)
+	})
+
+	router.POST("/api/boards/:id/sprints", func(c *gin.Context) {
+		var in sprintInput
+		if err := c.BindJSON(&in); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		if n, _ := db.Collection("boards").CountDocuments(context.TODO(), bson.M{"_id": c.Param("id"), "deletedAt": nil}); n == 0 {
+			c.JSON(http.StatusNotFound, gin.H{"error": "board not found"})
+			return
+		}
+		now := time.Now()
+		sp := Sprint{ID: newID(), BoardID: c.Param("id"), State: SprintPlanned, CreatedAt: now, UpdatedAt: now}
+		if err := in.apply(&sp); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		if _, err := sprintColl.InsertOne(context.TODO(), sp); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(http.StatusOK, sp)
+	})
+
+	router.PUT("/api/sprints/:id", func(c *gin.Context) {
+		sp, ok := loadSprint(c)
+		if !ok {
+			return
+		}
+		var in sprintInput
+		if err := c.BindJSON(&in); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		if sp.State == SprintClosed && (in.StartDate != nil || in.EndDate != nil) {
+			c.JSON(http.StatusConflict, gin.H{"error": "dates of a closed sprint can't change"})
+			return
+		}
+		if err := in.apply(sp); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		sp.UpdatedAt = time.Now()
+		// only what the client edits: state belongs to start and close
+		_, err := sprintColl.UpdateOne(context.TODO(), bson.M{"_id": sp.ID}, bson.M{"$set": bson.M{
+			"name":      sp.Name,
+			"goal":      sp.Goal,
+			"startDate": sp.StartDate,
+			"endDate":   sp.EndDate,
+			"updatedAt": sp.UpdatedAt,
+		}})
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(http.StatusOK, sp)
+	})
+
+	// Only planned sprints can be deleted; their cards go back to the backlog.
+	router.DELETE("/api/sprints/:id", func(c *gin.Context) {
+		sp, ok := loadSprint(c)
+		if !ok {
+			return
+		}
+		if sp.State != SprintPlanned {
+			c.JSON(http.StatusConflict, gin.H{"error": "only planned sprints can be deleted"})
+			return
+		}
+		cards, err := sprintCards(sp.ID)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		for i := range cards {
+			if err := setSprint(c, &cards[i], ""); err != nil {
+				c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+				return
+			}
+		}
+		if _, err := sprintColl.DeleteOne(context.TODO(), bson.M{"_id": sp.ID}); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{"status": "deleted", "cards": len(cards)})
+	})
+
+	// POST /api/sprints/:id/cards {"add": [cardId...], "remove": [cardId...]}
+	router.POST("/api/sprints/:id/cards", func(c *gin.Context) {
+		sp, ok := loadSprint(c)
+		if !ok {
+			return
+		}
+		if sp.State == SprintClosed {
+			c.JSON(http.StatusConflict, gin.H{"error": "sprint is closed"})
+			return
+		}
+		var in struct {
+			Add    []string `json:"add"`
+			Remove []string `json:"remove"`
+		}
+		if err := c.BindJSON(&in); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		for _, step := range []struct {
+			ids    []string
+			filter bson.M
+			target string
+		}{
+			{in.Add, bson.M{"boardId": sp.BoardID}, sp.ID},
+			{in.Remove, bson.M{"sprintId": sp.ID}, ""},
+		} {
+			if len(step.ids) == 0 {
+				continue
+			}
+			step.filter["_id"] = bson.M{"$in": step.ids}
+			step.filter["deletedAt"] = nil
+			cur, err := cardColl.Find(context.TODO(), step.filter)
+			if err != nil {
+				c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+				return
+			}
+			var cards []Card
+			if err := cur.All(context.TODO(), &cards); err != nil {
+				c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+				return
+			}
+			if len(cards) != len(step.ids) {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "some cards are not on this board or not in this sprint"})
+				return
+			}
+			for i := range cards {
+				if cards[i].SprintID == step.target {
+					continue
+				}
+				if err := setSprint(c, &cards[i], step.target); err != nil {
+					c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+					return
+				}
+			}
+		}
+		cards, err := sprintCards(sp.ID)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(http.StatusOK, cards)
+	})
+
+	// POST /api/sprints/:id/start records the committed points.
+	router.POST("/api/sprints/:id/start", func(c *gin.Context) {
+		sp, ok := loadSprint(c)
+		if !ok {
+			return
+		}
+		if sp.State != SprintPlanned {
+			c.JSON(http.StatusConflict, gin.H{"error": "sprint is not planned"})
+			return
+		}
+		cards, err := sprintCards(sp.ID)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		now := time.Now()
+		sp.State, sp.StartedAt, sp.UpdatedAt, sp.Committed = SprintActive, &now, now, 0
+		for _, card := range cards {
+			sp.Committed += card.Points
+		}
+		// the unique index on active sprints settles concurrent starts
+		res, err := sprintColl.UpdateOne(context.TODO(), bson.M{"_id": sp.ID, "state": SprintPlanned}, bson.M{"$set": bson.M{
+			"state":     sp.State,
+			"startedAt": now,
+			"updatedAt": now,
+			"committed": sp.Committed,
+		}})
+		if mongo.IsDuplicateKeyError(err) {
+			c.JSON(http.StatusConflict, gin.H{"error": "the board already has an active sprint"})
+			return
+		}
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		if res.MatchedCount == 0 {
+			c.JSON(http.StatusConflict, gin.H{"error": "sprint is not planned"})
+			return
+		}
+		c.JSON(http.StatusOK, sp)
+	})
+
+	// POST /api/sprints/:id/close {"nextSprintId"} — unfinished cards roll
+	// over to nextSprintId, else to the next planned sprint, else to a new
+	// sprint of the same length.
+	router.POST("/api/sprints/:id/close", func(c *gin.Context) {
+		sp, ok := loadSprint(c)
+		if !ok {
+			return
+		}
+		if sp.State != SprintActive {
+			c.JSON(http.StatusConflict, gin.H{"error": "sprint is not active"})
+			return
+		}
+		var in struct {
+			NextSprintID string `json:"nextSprintId"`
+		}
+		_ = c.ShouldBindJSON(&in) // body is optional
+		var next Sprint
+		nextFilter := bson.M{"boardId": sp.BoardID, "state": SprintPlanned}
+		if in.NextSprintID != "" {
+			nextFilter["_id"] = in.NextSprintID
+			if err := sprintColl.FindOne(context.TODO(), nextFilter).Decode(&next); err != nil {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "nextSprintId must be a planned sprint of this board"})
+				return
+			}
+		}
+
+		// Claim the close first, so of concurrent closes only one rolls over.
+		now := time.Now()
+		res, err := sprintColl.UpdateOne(context.TODO(), bson.M{"_id": sp.ID, "state": SprintActive}, bson.M{"$set": bson.M{
+			"state":     SprintClosed,
+			"closedAt":  now,
+			"updatedAt": now,
+		}})
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		if res.MatchedCount == 0 {
+			c.JSON(http.StatusConflict, gin.H{"error": "sprint is not active"})
+			return
+		}
+		sp.State, sp.ClosedAt, sp.UpdatedAt = SprintClosed, &now, now
+
+		cards, err := sprintCards(sp.ID)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		done := doneStatuses(context.TODO(), db, sp.BoardID)
+		var open []Card
+		sp.Completed = 0
+		for _, card := range cards {
+			if done[card.StatusID] {
+				sp.Completed += card.Points
+			} else {
+				open = append(open, card)
+			}
+		}
+		if _, err := sprintColl.UpdateOne(context.TODO(), bson.M{"_id": sp.ID}, bson.M{"$set": bson.M{"completed": sp.Completed}}); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+
+		if len(open) > 0 {
+			if next.ID == "" {
+				err := sprintColl.FindOne(context.TODO(), nextFilter, options.FindOne().SetSort(bson.D{{Key: "startDate", Value: 1}})).Decode(&next)
+				if err != nil && err != mongo.ErrNoDocuments {
+					c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+					return
+				}
+			}
+			if next.ID == "" {
+				length := sp.EndDate.Sub(sp.StartDate)
+				next = Sprint{
+					ID:        newID(),
+					BoardID:   sp.BoardID,
+					Name:      sp.Name + " (continued)",
+					StartDate: sp.EndDate,
+					EndDate:   sp.EndDate.Add(length),
+					State:     SprintPlanned,
+					CreatedAt: now,
+					UpdatedAt: now,
+				}
+				if _, err := sprintColl.InsertOne(context.TODO(), next); err != nil {
+					c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+					return
+				}
+			}
+			for i := range open {
+				if err := setSprint(c, &open[i], next.ID); err != nil {
+					c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+					return
+				}
+			}
+		}
+
+		out := gin.H{"sprint": sp, "rolledOver": len(open)}
+		if len(open) > 0 {
+			out["next"] = next
+		}
+		c.JSON(http.StatusOK, out)
+	})
+
+	// GET /api/sprints/:id/burndown — one entry per sprint day so far;
+	// remaining is the burndown, completed against scope the burnup.
+	router.GET("/api/sprints/:id/burndown", func(c *gin.Context) {
+		sp, ok := loadSprint(c)
+		if !ok {
+			return
+		}
+		days, err := sprintBurndown(context.TODO(), db, sp)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		if days == nil {
+			days = []BurndownDay{}
+		}
+		c.JSON(http.StatusOK, gin.H{"sprint": sp, "days": days})
+	})
+
+	// GET /api/boards/:id/velocity?count=6 — committed and completed points
+	// of the last closed sprints, oldest first, and their average.
+	router.GET("/api/boards/:id/velocity", func(c *gin.Context) {
+		count, _ := strconv.Atoi(c.DefaultQuery("count", "6"))
+		if count < 1 || count > 50 {
+			count = 6
+		}
+		cur, err := sprintColl.Find(context.TODO(),
+			bson.M{"boardId": c.Param("id"), "state": SprintClosed},
+			options.Find().SetSort(bson.D{{Key: "closedAt", Value: -1}}).SetLimit(int64(count)))
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		sprints := []Sprint{}
+		if err := cur.All(context.TODO(), &sprints); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		sort.Slice(sprints, func(i, j int) bool { return sprints[i].ClosedAt.Before(*sprints[j].ClosedAt) })
+		var sum float64
+		for _, sp := range sprints {
+			sum += sp.Completed
+		}
+		avg := 0.0
+		if len(sprints) > 0 {
+			avg = math.Round(sum/float64(len(sprints))*100) / 100
+		}
+		c.JSON(http.StatusOK, gin.H{"sprints": sprints, "average": avg})
+	})
+}
@@ -0,0 +1,498 @@
+package kanban
+
+import (
+	"context"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// Lane modes of a board. With LaneManual cards carry the id of one of the
+// board's Lanes; the other modes derive lanes from a card field, and lane
+// ids then look like "assignee:<userId>", "tag:<tag>" or "priority:<p>".
+const (
+	LaneNone     = ""
+	LaneManual   = "manual"
+	LaneAssignee = "assignee"
+	LaneTag      = "tag"
+	LanePriority = "priority"
+)
+
+// noLane collects cards that don't fit any lane.
+const noLane = "none"
+
+type Swimlane struct {
+	ID       string `bson:"id" json:"id"`
+	Name     string `bson:"name" json:"name"`
+	Position int    `bson:"position" json:"position"`
+}
+
+// LaneCell is the cards of one lane × status intersection.
+type LaneCell struct {
+	StatusID string `json:"statusId"`
+	Cards    []Card `json:"cards"`
+}
+
+// LaneRow is one lane of a grouped board with a cell per status.
+type LaneRow struct {
+	Swimlane
+	Cells []LaneCell `json:"cells"`
+}
+
+// laneOf returns the lane id a card falls into under the board's mode.
+// Cards with several tags or assignees go into the lane of the first one.
+func laneOf(b *Board, card *Card) string {
+	switch b.LaneMode {
+	case LaneManual:
+		for _, l := range b.Lanes {
+			if l.ID == card.LaneID {
+				return l.ID
+			}
+		}
+	case LaneAssignee:
+		if len(card.Assignees) > 0 {
+			return LaneAssignee + ":" + card.Assignees[0]
+		}
+	case LaneTag:
+		if len(card.Tags) > 0 {
+			return LaneTag + ":" + card.Tags[0]
+		}
+	case LanePriority:
+		if card.Priority != "" {
+			return LanePriority + ":" + card.Priority
+		}
+	}
+	return noLane
+}
+
+// lanesFor lists the lanes of a board: the manual ones in order, or the
+// distinct derived values found on cards. The catch-all lane comes last.
+func lanesFor(b *Board, cards []Card) []Swimlane {
+	var lanes []Swimlane
+	if b.LaneMode == LaneManual {
+		lanes = append(lanes, b.Lanes...)
+		sort.SliceStable(lanes, func(i, j int) bool { return lanes[i].Position < lanes[j].Position })
+	} else if b.LaneMode != LaneNone {
+		seen := map[string]bool{}
+		for i := range cards {
+			id := laneOf(b, &cards[i])
+			if id == noLane || seen[id] {
+				continue
+			}
+			seen[id] = true
+			lanes = append(lanes, Swimlane{ID: id, Name: strings.SplitN(id, ":", 2)[1]})
+		}
+		sort.Slice(lanes, func(i, j int) bool { return lanes[i].Name < lanes[j].Name })
+		for i := range lanes {
+			lanes[i].Position = i
+		}
+	}
+	return append(lanes, Swimlane{ID: noLane, Name: "", Position: len(lanes)})
+}
+
+// groupByLane builds the lane × status grid. Cards whose status isn't on
+// the board end up in a trailing cell with an empty statusId.
+func groupByLane(b *Board, statuses []Status, cards []Card) []LaneRow {
+	lanes := lanesFor(b, cards)
+	rows := make([]LaneRow, len(lanes))
+	rowIdx := map[string]int{}
+	colIdx := map[string]int{}
+	for i, st := range statuses {
+		colIdx[st.ID] = i
+	}
+	for i, l := range lanes {
+		rowIdx[l.ID] = i
+		rows[i].Swimlane = l
+		rows[i].Cells = make([]LaneCell, len(statuses)+1)
+		for j, st := range statuses {
+			rows[i].Cells[j] = LaneCell{StatusID: st.ID, Cards: []Card{}}
+		}
+		rows[i].Cells[len(statuses)] = LaneCell{StatusID: "", Cards: []Card{}}
+	}
+	for _, card := range cards {
+		r := rowIdx[laneOf(b, &card)]
+		col, ok := colIdx[card.StatusID]
+		if !ok {
+			col = len(statuses)
+		}
+		rows[r].Cells[col].Cards = append(rows[r].Cells[col].Cards, card)
+	}
+	return rows
+}
+
+// laneSet returns the update that puts a card into laneID under the board's
+// mode, changing the underlying field for derived lanes.
+func laneSet(b *Board, card *Card, laneID string) (set bson.M, unset bson.M, ok bool) {
+	set, unset = bson.M{}, bson.M{}
+	if b.LaneMode == LaneManual {
+		if laneID == noLane || laneID == "" {
+			unset["laneId"] = ""
+			return set, unset, true
+		}
+		for _, l := range b.Lanes {
+			if l.ID == laneID {
+				set["laneId"] = laneID
+				return set, unset, true
+			}
+		}
+		return nil, nil, false
+	}
+	mode, value, _ := strings.Cut(laneID, ":")
+	if laneID == noLane {
+		mode, value = b.LaneMode, ""
+	}
+	if mode != b.LaneMode || b.LaneMode == LaneNone {
+		return nil, nil, false
+	}
+	switch mode {
+	case LaneAssignee:
+		if value == "" {
+			unset["assignees"] = ""
+		} else {
+			set["assignees"] = []string{value}
+		}
+	case LaneTag:
+		// the lane tag is the first one; swap it and keep the others
+		rest := card.Tags
+		if len(rest) > 0 {
+			rest = rest[1:]
+		}
+		tags := []string{}
+		if value != "" {
+			tags = append(tags, value)
+		}
+		for _, t := range rest {
+			if t != value {
+				tags = append(tags, t)
+			}
+		}
+		if len(tags) == 0 {
+			unset["tags"] = ""
+		} else {
+			set["tags"] = tags
+		}
+	case LanePriority:
+		if value == "" {
+			unset["priority"] = ""
+		} else {
+			set["priority"] = value
+		}
+	}
+	return set, unset, true
+}
+
+func registerSwimlaneRoutes(router *gin.Engine, db *mongo.Database, changes *changeLog) {
+	boardColl := db.Collection("boards")
+	statusColl := db.Collection("statuses")
+	cardColl := db.Collection("cards")
+	hub := changes.hub
+
+	loadBoard := func(c *gin.Context, id string) (*Board, bool) {
+		var b Board
+		if err := boardColl.FindOne(context.TODO(), bson.M{"_id": id, "deletedAt": nil}).Decode(&b); err != nil {
+			c.JSON(http.StatusNotFound, gin.H{"error": "board not found"})
+			return nil, false
+		}
+		return &b, true
+	}
+	// saveLanes writes the lanes back and answers with the board. They
+	// replace what was read, so only if the board is still at that version
+	// (and at one the client expects, with If-Match); otherwise the answer
+	// is 409 (412) with the board as it is now.
+	saveLanes := func(c *gin.Context, b *Board) bool {
+		pre, ok := readPrecondition(c, b.Version)
+		if !ok {
+			return false
+		}
+		b.UpdatedAt = time.Now()
+		res, err := boardColl.UpdateOne(context.TODO(), pre.filter(bson.M{"_id": b.ID}), withVersion(bson.M{
+			"$set": bson.M{"laneMode": b.LaneMode, "lanes": b.Lanes, "updatedAt": b.UpdatedAt},
+		}))
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return false
+		}
+		if res.MatchedCount == 0 {
+			if current, ok := loadBoard(c, b.ID); ok {
+				pre.fail(c, current.Version, current)
+			}
+			return false
+		}
+		b.Version++
+		hub.emit(b.ID, EventBoardUpdated, currentUserID(c), b)
+		setETag(c, b.Version)
+		c.JSON(http.StatusOK, b)
+		return true
+	}
+
+	// GET /api/boards/:id — board with its columns; ?group=lanes adds the
+	// cards grouped lane × status.
+	router.GET("/api/boards/:id", func(c *gin.Context) {
+		b, ok := loadBoard(c, c.Param("id"))
+		if !ok {
+			return
+		}
+		setETag(c, b.Version)
+		statuses := []Status{}
+		if cur, err := statusColl.Find(context.TODO(), visible(c, bson.M{"boardId": b.ID})); err == nil {
+			_ = cur.All(context.TODO(), &statuses)
+		}
+		out := gin.H{"board": b, "statuses": statuses}
+		if c.Query("group") == "lanes" {
+			opts := options.Find().SetSort(bson.D{{Key: "position", Value: 1}, {Key: "createdAt", Value: 1}})
+			cur, err := cardColl.Find(context.TODO(), visible(c, bson.M{"boardId": b.ID}), opts)
+			if err != nil {
+				c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+				return
+			}
+			var cards []Card
+			if err := cur.All(context.TODO(), &cards); err != nil {
+				c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+				return
+			}
+			for i := range cards {
+				cards[i].fillProgress()
+			}
+			out["lanes"] = groupByLane(b, statuses, cards)
+		} else {
+			out["lanes"] = lanesFor(b, nil)
+		}
+		c.JSON(http.StatusOK, out)
+	})
+
+	// PUT /api/boards/:id/swimlanes {"mode": "manual"|"assignee"|"tag"|"priority"|""}
+	router.PUT("/api/boards/:id/swimlanes", func(c *gin.Context) {
+		var in struct {
+			Mode string `json:"mode"`
+		}
+		if err := c.BindJSON(&in); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		switch in.Mode {
+		case LaneNone, LaneManual, LaneAssignee, LaneTag, LanePriority:
+		default:
+			c.JSON(http.StatusBadRequest, gin.H{"error": "unknown lane mode"})
+			return
+		}
+		b, ok := loadBoard(c, c.Param("id"))
+		if !ok {
+			return
+		}
+		b.LaneMode = in.Mode
+		saveLanes(c, b)
+	})
+
+	router.POST("/api/boards/:id/swimlanes", func(c *gin.Context) {
+		var in struct {
+			Name string `json:"name"`
+		}
+		if err := c.BindJSON(&in); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		if strings.TrimSpace(in.Name) == "" {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "name is required"})
+			return
+		}
+		b, ok := loadBoard(c, c.Param("id"))
+		if !ok {
+			return
+		}
+		if b.LaneMode == LaneNone {
+			b.LaneMode = LaneManual
+		}
+		if b.LaneMode != LaneManual {
+			c.JSON(http.StatusConflict, gin.H{"error": "lanes are derived from cards on this board"})
+			return
+		}
+		b.Lanes = append(b.Lanes, Swimlane{ID: newID(), Name: in.Name, Position: len(b.Lanes)})
+		saveLanes(c, b)
+	})
+
+	router.PUT("/api/boards/:id/swimlanes/:laneId", func(c *gin.Context) {
+		var in struct {
+			Name     string `json:"name"`
+			Position *int   `json:"position"`
+		}
+		if err := c.BindJSON(&in); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		b, ok := loadBoard(c, c.Param("id"))
+		if !ok {
+			return
+		}
+		idx := -1
+		for i, l := range b.Lanes {
+			if l.ID == c.Param("laneId") {
+				idx = i
+			}
+		}
+		if idx < 0 {
+			c.JSON(http.StatusNotFound, gin.H{"error": "lane not found"})
+			return
+		}
+		if strings.TrimSpace(in.Name) != "" {
+			b.Lanes[idx].Name = in.Name
+		}
+		if in.Position != nil {
+			lane := b.Lanes[idx]
+			sort.SliceStable(b.Lanes, func(i, j int) bool { return b.Lanes[i].Position < b.Lanes[j].Position })
+			rest := make([]Swimlane, 0, len(b.Lanes))
+			for _, l := range b.Lanes {
+				if l.ID != lane.ID {
+					rest = append(rest, l)
+				}
+			}
+			pos := *in.Position
+			if pos < 0 {
+				pos = 0
+			}
+			if pos > len(rest) {
+				pos = len(rest)
+			}
+			b.Lanes = append(rest[:pos:pos], append([]Swimlane{lane}, rest[pos:]...)...)
+			for i := range b.Lanes {
+				b.Lanes[i].Position = i
+			}
+		}
+		saveLanes(c, b)
+	})
+
+	router.DELETE("/api/boards/:id/swimlanes/:laneId", func(c *gin.Context) {
+		b, ok := loadBoard(c, c.Param("id"))
+		if !ok {
+			return
+		}
+		laneID := c.Param("laneId")
+		kept := b.Lanes[:0]
+		for _, l := range b.Lanes {
+			if l.ID != laneID {
+				kept = append(kept, l)
+			}
+		}
+		b.Lanes = kept
+		if saveLanes(c, b) {
+			_, _ = cardColl.UpdateMany(context.TODO(), bson.M{"boardId": b.ID, "laneId": laneID},
+				withVersion(bson.M{"$unset": bson.M{"laneId": ""}}))
+		}
+	})
+
+	// POST /api/cards/:id/move {"statusId", "laneId", "position"} — any
+	// combination; lane and column change in one write. Conditional like
+	// PUT /api/cards/:id.
+	router.POST("/api/cards/:id/move", func(c *gin.Context) {
+		var in struct {
+			StatusID *string  `json:"statusId"`
+			LaneID   *string  `json:"laneId"`
+			Position *float64 `json:"position"`
+			Version  *int64   `json:"version"`
+		}
+		if err := c.BindJSON(&in); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		pre, ok := writePrecondition(c, in.Version)
+		if !ok {
+			return
+		}
+		var before Card
+		if err := cardColl.FindOne(context.TODO(), bson.M{"_id": c.Param("id"), "deletedAt": nil}).Decode(&before); err != nil {
+			c.JSON(http.StatusNotFound, gin.H{"error": "not found"})
+			return
+		}
+		stale := func() {
+			var current Card
+			_ = cardColl.FindOne(context.TODO(), bson.M{"_id": before.ID}).Decode(&current)
+			current.fillProgress()
+			pre.fail(c, current.Version, current)
+		}
+		if !pre.holds(before.Version) {
+			stale()
+			return
+		}
+		set, unset := bson.M{}, bson.M{}
+		var warning string
+		if in.StatusID != nil {
+			msg, refuse := checkBlockedMove(context.TODO(), db, &before, *in.StatusID)
+			if refuse {
+				c.JSON(http.StatusConflict, gin.H{"error": msg})
+				return
+			}
+			warning = msg
+			if *in.StatusID != "" {
+				cnt, _ := statusColl.CountDocuments(context.TODO(),
+					bson.M{"_id": *in.StatusID, "boardId": before.BoardID, "deletedAt": nil})
+				if cnt == 0 {
+					c.JSON(http.StatusBadRequest, gin.H{"error": "status not found on this board"})
+					return
+				}
+			}
+			set["statusId"] = *in.StatusID
+		}
+		if in.LaneID != nil {
+			b, ok := loadBoard(c, before.BoardID)
+			if !ok {
+				return
+			}
+			ls, lu, ok := laneSet(b, &before, *in.LaneID)
+			if !ok {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "lane not found on this board"})
+				return
+			}
+			if tags, ok := ls["tags"].([]string); ok {
+				labels, err := ensureLabels(context.TODO(), db, before.BoardID, tags)
+				if err != nil {
+					c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+					return
+				}
+				var moved Card
+				moved.setLabels(labels)
+				ls["tags"], ls["labels"] = moved.Tags, moved.Labels
+			} else if _, ok := lu["tags"]; ok {
+				lu["labels"] = ""
+			}
+			for k, v := range ls {
+				set[k] = v
+			}
+			for k, v := range lu {
+				unset[k] = v
+			}
+		}
+		if in.Position != nil {
+			set["position"] = *in.Position
+		}
+		set["updatedAt"] = time.Now()
+		update := bson.M{"$set": set}
+		if len(unset) > 0 {
+			update["$unset"] = unset
+		}
+		res, err := cardColl.UpdateOne(context.TODO(), pre.filter(bson.M{"_id": before.ID}), withVersion(update))
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		if res.MatchedCount == 0 {
+			stale()
+			return
+		}
+		var out Card
+		_ = cardColl.FindOne(context.TODO(), bson.M{"_id": before.ID}).Decode(&out)
+		changes.card(currentUserID(c), &before, &out)
+		// as written by the rules the change set off, if any
+		_ = cardColl.FindOne(context.TODO(), bson.M{"_id": before.ID}).Decode(&out)
+		out.fillProgress()
+		if warning != "" {
+			out.Warnings = append(out.Warnings, warning)
+		}
+		setETag(c, out.Version)
+		c.JSON(http.StatusOK, out)
+	})
+}
@@ -0,0 +1,207 @@
+package kanban
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// BoardTemplate is a board layout new boards can start from. Built-in
+// templates live in code; saved ones are copied from a board into
+// board_templates.
+type BoardTemplate struct {
+	ID          string        `bson:"_id,omitempty" json:"_id"`
+	Name        string        `bson:"name" json:"name"`
+	Description string        `bson:"description,omitempty" json:"description,omitempty"`
+	BuiltIn     bool          `bson:"-" json:"builtIn"`
+	Snapshot    boardSnapshot `bson:"snapshot" json:"snapshot"`
+	CreatedBy   string        `bson:"createdBy,omitempty" json:"createdBy,omitempty"`
+	CreatedAt   time.Time     `bson:"createdAt" json:"createdAt"`
+}
+
+// builtin makes a cards-free template from (name, category) column pairs.
+func builtin(id, name, description string, columns ...[2]string) BoardTemplate {
+	t := BoardTemplate{ID: id, Name: name, Description: description, BuiltIn: true}
+	t.Snapshot.Board = Board{ID: id, Name: name}
+	for i, col := range columns {
+		t.Snapshot.Statuses = append(t.Snapshot.Statuses, Status{
+			ID:       id + "-" + string(rune('a'+i)),
+			Name:     col[0],
+			BoardID:  id,
+			Category: col[1],
+		})
+	}
+	return t
+}
+
+var builtinTemplates = []BoardTemplate{
+	builtin("builtin-kanban", "Kanban", "To do, doing, done.",
+		[2]string{"To Do", CategoryTodo},
+		[2]string{"In Progress", CategoryInProgress},
+		[2]string{"Done", CategoryDone}),
+	builtin("builtin-scrum", "Scrum", "Product and sprint backlogs with a review column.",
+		[2]string{"Product Backlog", CategoryTodo},
+		[2]string{"Sprint Backlog", CategoryTodo},
+		[2]string{"In Progress", CategoryInProgress},
+		[2]string{"Review", CategoryInProgress},
+		[2]string{"Done", CategoryDone}),
+	builtin("builtin-bug-triage", "Bug triage", "From report to verified fix.",
+		[2]string{"New", CategoryTodo},
+		[2]string{"Triaged", CategoryTodo},
+		[2]string{"In Progress", CategoryInProgress},
+		[2]string{"Fixed", CategoryInProgress},
+		[2]string{"Verified", CategoryDone},
+		[2]string{"Won't Fix", CategoryDone}),
+}
+
+func registerTemplateRoutes(router *gin.Engine, db *mongo.Database, hub *eventHub) {
+	templateColl := db.Collection("board_templates")
+
+	findTemplate := func(id string) (*BoardTemplate, error) {
+		for i := range builtinTemplates {
+			if builtinTemplates[i].ID == id {
+				return &builtinTemplates[i], nil
+			}
+		}
+		var t BoardTemplate
+		if err := templateColl.FindOne(context.TODO(), bson.M{"_id": id}).Decode(&t); err != nil {
+			return nil, err
+		}
+		return &t, nil
+	}
+	// create writes snap as a new board and announces it.
+	create := func(c *gin.Context, snap *boardSnapshot, name string) {
+		b, _, err := snap.insertAsNew(context.TODO(), db, name, currentUserID(c))
+		if err != nil {
+			if errors.As(err, new(*requiredFieldError)) {
+				c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+				return
+			}
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		hub.emit(b.ID, EventBoardCreated, currentUserID(c), b)
+		c.JSON(http.StatusOK, b)
+	}
+
+	// --- TEMPLATES ---
+	router.GET("/api/board-templates", func(c *gin.Context) {
+		out := append([]BoardTemplate(nil), builtinTemplates...)
+		cur, err := templateColl.Find(context.TODO(), bson.M{}, options.Find().SetSort(bson.D{{Key: "name", Value: 1}}))
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		var saved []BoardTemplate
+		if err := cur.All(context.TODO(), &saved); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(http.StatusOK, append(out, saved...))
+	})
+
+	// POST /api/board-templates {"boardId", "name", "description", "includeCards"}
+	router.POST("/api/board-templates", func(c *gin.Context) {
+		var in struct {
+			BoardID      string `json:"boardId"`
+			Name         string `json:"name"`
+			Description  string `json:"description"`
+			IncludeCards bool   `json:"includeCards"`
+		}
+		if err := c.BindJSON(&in); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		snap, err := loadSnapshot(context.TODO(), db, in.BoardID, in.IncludeCards)
+		if err != nil {
+			c.JSON(http.StatusNotFound, gin.H{"error": "board not found"})
+			return
+		}
+		t := BoardTemplate{
+			ID:          newID(),
+			Name:        strings.TrimSpace(in.Name),
+			Description: in.Description,
+			Snapshot:    *snap,
+			CreatedBy:   currentUserID(c),
+			CreatedAt:   time.Now(),
+		}
+		if t.Name == "" {
+			t.Name = snap.Board.Name
+		}
+		if _, err := templateColl.InsertOne(context.TODO(), t); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(http.StatusOK, t)
+	})
+
+	router.DELETE("/api/board-templates/:id", func(c *gin.Context) {
+		if strings.HasPrefix(c.Param("id"), "builtin-") {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "built-in templates cannot be deleted"})
+			return
+		}
+		res, err := templateColl.DeleteOne(context.TODO(), bson.M{"_id": c.Param("id")})
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		if res.DeletedCount == 0 {
+			c.JSON(http.StatusNotFound, gin.H{"error": "template not found"})
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{"status": "deleted"})
+	})
+
+	// POST /api/board-templates/:id/apply {"name"} — creates a board from the template
+	router.POST("/api/board-templates/:id/apply", func(c *gin.Context) {
+		var in struct {
+			Name string `json:"name"`
+		}
+		if err := c.BindJSON(&in); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		t, err := findTemplate(c.Param("id"))
+		if err != nil {
+			c.JSON(http.StatusNotFound, gin.H{"error": "template not found"})
+			return
+		}
+		name := strings.TrimSpace(in.Name)
+		if name == "" {
+			name = t.Name
+		}
+		create(c, &t.Snapshot, name)
+	})
+
+	// --- CLONE ---
+	// POST /api/boards/:id/clone {"name", "includeCards"} copies columns, lanes
+	// and settings, and with includeCards the live cards (tags, checklists,
+	// links between them and attachments) too, all under new ids.
+	router.POST("/api/boards/:id/clone", func(c *gin.Context) {
+		var in struct {
+			Name         string `json:"name"`
+			IncludeCards bool   `json:"includeCards"`
+		}
+		if err := c.BindJSON(&in); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		snap, err := loadSnapshot(context.TODO(), db, c.Param("id"), in.IncludeCards)
+		if err != nil {
+			c.JSON(http.StatusNotFound, gin.H{"error": "board not found"})
+			return
+		}
+		name := strings.TrimSpace(in.Name)
+		if name == "" {
+			name = snap.Board.Name + " (copy)"
+		}
+		create(c, snap, name)
+	})
+}
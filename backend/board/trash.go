@@ -0,0 +1,372 @@
+package kanban
+
+import (
+	"context"
+	"log"
+	"net/http"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// visible narrows a list filter to documents outside the trash. Archived
+// documents are hidden too unless ?archived=true (only archived) or
+// ?archived=all is passed.
+func visible(c *gin.Context, filter bson.M) bson.M {
+	filter["deletedAt"] = nil
+	switch c.Query("archived") {
+	case "true":
+		filter["archivedAt"] = bson.M{"$ne": nil}
+	case "all":
+	default:
+		filter["archivedAt"] = nil
+	}
+	return filter
+}
+
+// trashRetention reads TRASH_RETENTION_DAYS (default 30).
+func trashRetention() time.Duration {
+	days, err := strconv.Atoi(os.Getenv("TRASH_RETENTION_DAYS"))
+	if err != nil || days <= 0 {
+		days = 30
+	}
+	return time.Duration(days) * 24 * time.Hour
+}
+
+// purgeTrash permanently removes everything that has been in the trash for
+// longer than retention, together with the comments, links and attachment
+// records of removed cards and the saved views of removed boards.
+func purgeTrash(db *mongo.Database, retention time.Duration) int64 {
+	ctx := context.TODO()
+	boardColl := db.Collection("boards")
+	statusColl := db.Collection("statuses")
+	cardColl := db.Collection("cards")
+	commentColl := db.Collection("comments")
+	linkColl := db.Collection("card_links")
+	attachColl := db.Collection("attachments")
+	expired := bson.M{"deletedAt": bson.M{"$lt": time.Now().Add(-retention)}}
+
+	var purged int64
+	dropCards := func(filter bson.M) {
+		var ids []string
+		cur, err := cardColl.Find(ctx, filter)
+		if err != nil {
+			return
+		}
+		var cards []Card
+		if err := cur.All(ctx, &cards); err != nil {
+			return
+		}
+		for _, card := range cards {
+			ids = append(ids, card.ID)
+		}
+		if len(ids) == 0 {
+			return
+		}
+		res, err := cardColl.DeleteMany(ctx, bson.M{"_id": bson.M{"$in": ids}})
+		if err == nil {
+			purged += res.DeletedCount
+		}
+		_, _ = commentColl.DeleteMany(ctx, bson.M{"cardId": bson.M{"$in": ids}})
+		_, _ = attachColl.DeleteMany(ctx, bson.M{"cardId": bson.M{"$in": ids}}) // files go with cleanupUploads
+		_, _ = linkColl.DeleteMany(ctx, bson.M{"$or": []bson.M{
+			{"fromId": bson.M{"$in": ids}},
+			{"toId": bson.M{"$in": ids}},
+		}})
+	}
+
+	var boards []Board
+	if cur, err := boardColl.Find(ctx, expired); err == nil {
+		_ = cur.All(ctx, &boards)
+	}
+	for _, b := range boards {
+		dropCards(bson.M{"boardId": b.ID})
+		if res, err := statusColl.DeleteMany(ctx, bson.M{"boardId": b.ID}); err == nil {
+			purged += res.DeletedCount
+		}
+		if res, err := boardColl.DeleteOne(ctx, bson.M{"_id": b.ID}); err == nil {
+			purged += res.DeletedCount
+		}
+		_, _ = db.Collection("views").DeleteMany(ctx, bson.M{"boardId": b.ID})
+		_, _ = db.Collection("recurrences").DeleteMany(ctx, bson.M{"boardId": b.ID})
+		_, _ = db.Collection("rules").DeleteMany(ctx, bson.M{"boardId": b.ID})
+		_, _ = db.Collection("mail_inboxes").DeleteMany(ctx, bson.M{"boardId": b.ID})
+		_, _ = db.Collection("labels").DeleteMany(ctx, bson.M{"boardId": b.ID})
+	}
+	var statuses []Status
+	if cur, err := statusColl.Find(ctx, expired); err == nil {
+		_ = cur.All(ctx, &statuses)
+	}
+	for _, st := range statuses {
+		if res, err := statusColl.DeleteOne(ctx, bson.M{"_id": st.ID}); err == nil {
+			purged += res.DeletedCount
+		}
+		// the column is gone for good, nothing to go back to
+		_, _ = cardColl.UpdateMany(ctx, bson.M{"trashedStatusId": st.ID},
+			withVersion(bson.M{"$unset": bson.M{"trashedStatusId": ""}}))
+	}
+	dropCards(expired)
+	return purged
+}
+
+func registerTrashRoutes(router *gin.Engine, db *mongo.Database, changes *changeLog) {
+	boardColl := db.Collection("boards")
+	statusColl := db.Collection("statuses")
+	cardColl := db.Collection("cards")
+	hub := changes.hub
+
+	// setArchived flips archivedAt on a live document and reports whether it matched.
+	setArchived := func(coll *mongo.Collection, id string, archived bool) (bool, error) {
+		update := bson.M{"$unset": bson.M{"archivedAt": ""}, "$set": bson.M{"updatedAt": time.Now()}}
+		if archived {
+			update = bson.M{"$set": bson.M{"archivedAt": time.Now(), "updatedAt": time.Now()}}
+		}
+		if coll != statusColl { // columns are not versioned
+			update = withVersion(update)
+		}
+		res, err := coll.UpdateOne(context.TODO(), bson.M{"_id": id, "deletedAt": nil}, update)
+		if err != nil {
+			return false, err
+		}
+		return res.MatchedCount > 0, nil
+	}
+
+	// --- ARCHIVE ---
+	archiveCard := func(archived bool) gin.HandlerFunc {
+		return func(c *gin.Context) {
+			id := c.Param("id")
+			ok, err := setArchived(cardColl, id, archived)
+			if err != nil {
+				c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+				return
+			}
+			if !ok {
+				c.JSON(http.StatusNotFound, gin.H{"error": "not found"})
+				return
+			}
+			var card Card
+			_ = cardColl.FindOne(context.TODO(), bson.M{"_id": id}).Decode(&card)
+			action, eventType := ActionUnarchived, EventCardUnarchived
+			if archived {
+				action, eventType = ActionArchived, EventCardArchived
+			}
+			recordActivity(context.TODO(), changes.activity, CardActivity{
+				CardID:  card.ID,
+				BoardID: card.BoardID,
+				ActorID: currentUserID(c),
+				Action:  action,
+			})
+			hub.emit(card.BoardID, eventType, currentUserID(c), card)
+			card.fillProgress()
+			c.JSON(http.StatusOK, card)
+		}
+	}
+	router.POST("/api/cards/:id/archive", archiveCard(true))
+	router.POST("/api/cards/:id/unarchive", archiveCard(false))
+
+	archiveStatus := func(archived bool) gin.HandlerFunc {
+		return func(c *gin.Context) {
+			id := c.Param("id")
+			ok, err := setArchived(statusColl, id, archived)
+			if err != nil {
+				c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+				return
+			}
+			if !ok {
+				c.JSON(http.StatusNotFound, gin.H{"error": "not found"})
+				return
+			}
+			var st Status
+			_ = statusColl.FindOne(context.TODO(), bson.M{"_id": id}).Decode(&st)
+			eventType := EventStatusUnarchived
+			if archived {
+				eventType = EventStatusArchived
+			}
+			hub.emit(st.BoardID, eventType, currentUserID(c), st)
+			c.JSON(http.StatusOK, st)
+		}
+	}
+	router.POST("/api/statuses/:id/archive", archiveStatus(true))
+	router.POST("/api/statuses/:id/unarchive", archiveStatus(false))
+
+	archiveBoard := func(archived bool) gin.HandlerFunc {
+		return func(c *gin.Context) {
+			id := c.Param("id")
+			ok, err := setArchived(boardColl, id, archived)
+			if err != nil {
+				c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+				return
+			}
+			if !ok {
+				c.JSON(http.StatusNotFound, gin.H{"error": "not found"})
+				return
+			}
+			var b Board
+			_ = boardColl.FindOne(context.TODO(), bson.M{"_id": id}).Decode(&b)
+			eventType := EventBoardUnarchived
+			if archived {
+				eventType = EventBoardArchived
+			}
+			hub.emit(b.ID, eventType, currentUserID(c), b)
+			c.JSON(http.StatusOK, b)
+		}
+	}
+	router.POST("/api/boards/:id/archive", archiveBoard(true))
+	router.POST("/api/boards/:id/unarchive", archiveBoard(false))
+
+	// --- TRASH ---
+	// GET /api/trash?boardId= lists what was deleted directly; columns and
+	// cards that went with their board are restored with it.
+	router.GET("/api/trash", func(c *gin.Context) {
+		filter := bson.M{"deletedAt": bson.M{"$ne": nil}, "deletedWith": nil}
+		boardFilter := bson.M{"deletedAt": bson.M{"$ne": nil}}
+		if boardId := c.Query("boardId"); boardId != "" {
+			filter["boardId"] = boardId
+			boardFilter["_id"] = boardId
+		}
+		boards := []Board{}
+		statuses := []Status{}
+		cards := []Card{}
+		if cur, err := boardColl.Find(context.TODO(), boardFilter); err == nil {
+			_ = cur.All(context.TODO(), &boards)
+		}
+		if cur, err := statusColl.Find(context.TODO(), filter); err == nil {
+			_ = cur.All(context.TODO(), &statuses)
+		}
+		if cur, err := cardColl.Find(context.TODO(), filter); err == nil {
+			_ = cur.All(context.TODO(), &cards)
+		}
+		c.JSON(http.StatusOK, gin.H{
+			"boards":        boards,
+			"statuses":      statuses,
+			"cards":         cards,
+			"retentionDays": int(trashRetention().Hours() / 24),
+		})
+	})
+
+	router.POST("/api/boards/:id/restore", func(c *gin.Context) {
+		id := c.Param("id")
+		var b Board
+		if err := boardColl.FindOne(context.TODO(), bson.M{"_id": id, "deletedAt": bson.M{"$ne": nil}}).Decode(&b); err != nil {
+			c.JSON(http.StatusNotFound, gin.H{"error": "not found in trash"})
+			return
+		}
+		restore := bson.M{"deletedAt": "", "deletedWith": ""}
+		if _, err := boardColl.UpdateOne(context.TODO(), bson.M{"_id": id}, withVersion(bson.M{"$unset": restore})); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		stRes, _ := statusColl.UpdateMany(context.TODO(), bson.M{"boardId": id, "deletedWith": id}, bson.M{"$unset": restore})
+		cardRes, _ := cardColl.UpdateMany(context.TODO(), bson.M{"boardId": id, "deletedWith": id}, withVersion(bson.M{"$unset": restore}))
+		b.DeletedAt = nil
+		b.Version++
+		hub.emit(id, EventBoardRestored, currentUserID(c), b)
+		out := gin.H{"board": b, "statuses": int64(0), "cards": int64(0)}
+		if stRes != nil {
+			out["statuses"] = stRes.ModifiedCount
+		}
+		if cardRes != nil {
+			out["cards"] = cardRes.ModifiedCount
+		}
+		c.JSON(http.StatusOK, out)
+	})
+
+	router.POST("/api/statuses/:id/restore", func(c *gin.Context) {
+		id := c.Param("id")
+		var st Status
+		if err := statusColl.FindOne(context.TODO(), bson.M{"_id": id, "deletedAt": bson.M{"$ne": nil}}).Decode(&st); err != nil {
+			c.JSON(http.StatusNotFound, gin.H{"error": "not found in trash"})
+			return
+		}
+		if st.DeletedWith != "" {
+			c.JSON(http.StatusConflict, gin.H{"error": "column was deleted with its board, restore the board", "boardId": st.DeletedWith})
+			return
+		}
+		if _, err := statusColl.UpdateOne(context.TODO(), bson.M{"_id": id},
+			bson.M{"$unset": bson.M{"deletedAt": ""}}); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		st.DeletedAt = nil
+		hub.emit(st.BoardID, EventStatusRestored, currentUserID(c), st)
+
+		// put back cards that were in the column and haven't been moved since
+		var cards []Card
+		back := bson.M{"trashedStatusId": id, "statusId": ""}
+		if cur, err := cardColl.Find(context.TODO(), back); err == nil {
+			_ = cur.All(context.TODO(), &cards)
+		}
+		_, _ = cardColl.UpdateMany(context.TODO(), back,
+			withVersion(bson.M{"$set": bson.M{"statusId": id}, "$unset": bson.M{"trashedStatusId": ""}}))
+		for i := range cards {
+			after := cards[i]
+			after.StatusID = id
+			after.TrashedStatusID = ""
+			changes.card(currentUserID(c), &cards[i], &after)
+		}
+		c.JSON(http.StatusOK, gin.H{"status": st, "cards": len(cards)})
+	})
+
+	router.POST("/api/cards/:id/restore", func(c *gin.Context) {
+		id := c.Param("id")
+		var card Card
+		if err := cardColl.FindOne(context.TODO(), bson.M{"_id": id, "deletedAt": bson.M{"$ne": nil}}).Decode(&card); err != nil {
+			c.JSON(http.StatusNotFound, gin.H{"error": "not found in trash"})
+			return
+		}
+		if card.DeletedWith != "" {
+			c.JSON(http.StatusConflict, gin.H{"error": "card was deleted with its board, restore the board", "boardId": card.DeletedWith})
+			return
+		}
+		if cnt, _ := boardColl.CountDocuments(context.TODO(), bson.M{"_id": card.BoardID, "deletedAt": nil}); cnt == 0 {
+			c.JSON(http.StatusConflict, gin.H{"error": "board is in the trash, restore it first", "boardId": card.BoardID})
+			return
+		}
+		set := bson.M{"updatedAt": time.Now()}
+		// a card can't come back into a column that is gone
+		if card.StatusID != "" {
+			cnt, _ := statusColl.CountDocuments(context.TODO(), bson.M{"_id": card.StatusID, "deletedAt": nil})
+			if cnt == 0 {
+				set["statusId"] = ""
+				card.StatusID = ""
+			}
+		}
+		if _, err := cardColl.UpdateOne(context.TODO(), bson.M{"_id": id},
+			withVersion(bson.M{"$set": set, "$unset": bson.M{"deletedAt": ""}})); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		card.DeletedAt = nil
+		recordActivity(context.TODO(), changes.activity, CardActivity{
+			CardID:  card.ID,
+			BoardID: card.BoardID,
+			ActorID: currentUserID(c),
+			Action:  ActionRestored,
+		})
+		changes.transition(card.BoardID, card.ID, "", card.StatusID)
+		hub.emit(card.BoardID, EventCardRestored, currentUserID(c), card)
+		card.fillProgress()
+		c.JSON(http.StatusOK, card)
+	})
+
+	// POST /api/trash/purge runs the retention purge now.
+	router.POST("/api/trash/purge", func(c *gin.Context) {
+		c.JSON(http.StatusOK, gin.H{"purged": purgeTrash(db, trashRetention())})
+	})
+
+	// background purge (non-blocking)
+	go func() {
+		ticker := time.NewTicker(time.Hour)
+		defer ticker.Stop()
+		for {
+			<-ticker.C
+			if n := purgeTrash(db, trashRetention()); n > 0 {
+				log.Println("kanban trash purged:", n)
+			}
+		}
+	}()
+}
@@ -0,0 +1,119 @@
+package kanban
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+// Cards and boards carry a version that every write bumps (bookkeeping such
+// as sent reminders aside). Documents written before versions existed, and
+// new ones, are at version 0. Responses have it as the ETag; a PUT sending
+// If-Match, or the version in its body, only applies if nobody wrote in
+// between.
+
+// withVersion adds the version bump to a Mongo update.
+func withVersion(update bson.M) bson.M {
+	update["$inc"] = bson.M{"version": 1}
+	return update
+}
+
+func etag(version int64) string {
+	return `"` + strconv.FormatInt(version, 10) + `"`
+}
+
+func setETag(c *gin.Context, version int64) {
+	c.Header("ETag", etag(version))
+}
+
+// precondition is the version a write was based on.
+type precondition struct {
+	versions []int64 // any of them
+	header   bool    // from If-Match: a mismatch is 412, otherwise 409
+}
+
+// writePrecondition reads If-Match (ETags from earlier responses, weak or
+// not) or, without it, the version the body carried (nil if none). A nil
+// precondition means the write is unconditional, as is If-Match: *.
+func writePrecondition(c *gin.Context, bodyVersion *int64) (*precondition, bool) {
+	header := strings.TrimSpace(c.GetHeader("If-Match"))
+	if header == "" {
+		if bodyVersion == nil {
+			return nil, true
+		}
+		return &precondition{versions: []int64{*bodyVersion}}, true
+	}
+	if header == "*" {
+		return nil, true
+	}
+	p := &precondition{header: true}
+	for _, tag := range strings.Split(header, ",") {
+		tag = strings.Trim(strings.TrimPrefix(strings.TrimSpace(tag), "W/"), `"`)
+		v, err := strconv.ParseInt(tag, 10, 64)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "If-Match must hold ETags of this API"})
+			return nil, false
+		}
+		p.versions = append(p.versions, v)
+	}
+	return p, true
+}
+
+// readPrecondition is for writes that replace what they read, a whole
+// array say: the document must still be at the version it was read at, and
+// at one the client expects if it sent If-Match.
+func readPrecondition(c *gin.Context, version int64) (*precondition, bool) {
+	pre, ok := writePrecondition(c, nil)
+	if !ok {
+		return nil, false
+	}
+	read := &precondition{header: pre != nil && pre.header}
+	if pre.holds(version) {
+		read.versions = []int64{version}
+	}
+	return read, true
+}
+
+// holds reports whether a document at version may be written.
+func (p *precondition) holds(version int64) bool {
+	if p == nil {
+		return true
+	}
+	for _, v := range p.versions {
+		if v == version {
+			return true
+		}
+	}
+	return false
+}
+
+// filter narrows a write's filter to the expected versions; version 0 also
+// matches documents without one.
+func (p *precondition) filter(filter bson.M) bson.M {
+	if p == nil {
+		return filter
+	}
+	in := bson.A{}
+	for _, v := range p.versions {
+		in = append(in, v)
+		if v == 0 {
+			in = append(in, nil)
+		}
+	}
+	filter["version"] = bson.M{"$in": in}
+	return filter
+}
+
+// fail answers a write that lost the race with the document as it is now,
+// for the client to merge.
+func (p *precondition) fail(c *gin.Context, version int64, current interface{}) {
+	status := http.StatusConflict
+	if p.header {
+		status = http.StatusPreconditionFailed
+	}
+	setETag(c, version)
+	c.JSON(status, gin.H{"error": "changed since you loaded it", "version": version, "current": current})
+}
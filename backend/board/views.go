@@ -0,0 +1,210 @@
+package kanban
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// View is a saved way of looking at cards: a filter in the query language
+// of query.go, a sort, a grouping and the fields to show. Private views are
+// seen by their owner only; shared ones by everyone on the board.
+type View struct {
+	ID        string    `bson:"_id,omitempty" json:"_id"`
+	Name      string    `bson:"name" json:"name"`
+	BoardID   string    `bson:"boardId,omitempty" json:"boardId,omitempty"` // "" for a cross-board view
+	OwnerID   string    `bson:"ownerId" json:"ownerId"`
+	Shared    bool      `bson:"shared" json:"shared"`
+	Query     string    `bson:"query,omitempty" json:"query,omitempty"`
+	Sort      string    `bson:"sort,omitempty" json:"sort,omitempty"`       // as ?sort= of GET /api/cards
+	GroupBy   string    `bson:"groupBy,omitempty" json:"groupBy,omitempty"` // "", "status", "lanes"
+	Fields    []string  `bson:"fields,omitempty" json:"fields,omitempty"`   // card fields to show, as in exports
+	CreatedAt time.Time `bson:"createdAt" json:"createdAt"`
+	UpdatedAt time.Time `bson:"updatedAt" json:"updatedAt"`
+}
+
+var errViewNotFound = errors.New("view not found")
+
+// validate checks the settings a client can send.
+func (v *View) validate(ctx context.Context, db *mongo.Database, userID string) error {
+	if strings.TrimSpace(v.Name) == "" {
+		return errors.New("name is required")
+	}
+	switch sort := strings.TrimPrefix(v.Sort, "-"); {
+	case v.Sort == "", sort == "position", sort == "dueDate":
+	case strings.HasPrefix(sort, "field.") && sort != "field.":
+		if v.BoardID == "" {
+			return errors.New("sorting on a custom field needs a board view")
+		}
+		b, err := loadFieldBoard(ctx, db, v.BoardID)
+		if err != nil {
+			return err
+		}
+		if b.field(strings.TrimPrefix(sort, "field.")) == nil {
+			return fmt.Errorf("unknown custom field %q", strings.TrimPrefix(sort, "field."))
+		}
+	default:
+		return errors.New(`sort must be "", "position", "dueDate" or "field.<name>", with "-" for descending`)
+	}
+	switch v.GroupBy {
+	case "", "status", "lanes":
+	default:
+		return errors.New(`groupBy must be "", "status" or "lanes"`)
+	}
+	for _, f := range v.Fields {
+		if cardColumn(f) == nil {
+			return fmt.Errorf("unknown field %q", f)
+		}
+	}
+	if v.Query != "" {
+		if _, err := compileQuery(ctx, db, v.Query, userID); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// loadView returns a view userID may use: their own, or a shared one.
+func loadView(ctx context.Context, db *mongo.Database, id, userID string) (*View, error) {
+	var v View
+	if err := db.Collection("views").FindOne(ctx, bson.M{"_id": id}).Decode(&v); err != nil {
+		return nil, errViewNotFound
+	}
+	if !v.Shared && (userID == "" || v.OwnerID != userID) {
+		return nil, errViewNotFound
+	}
+	return &v, nil
+}
+
+func registerViewRoutes(router *gin.Engine, db *mongo.Database) {
+	viewColl := db.Collection("views")
+	boardColl := db.Collection("boards")
+	_, _ = viewColl.Indexes().CreateOne(context.TODO(), mongo.IndexModel{
+		Keys: bson.D{{Key: "boardId", Value: 1}, {Key: "ownerId", Value: 1}},
+	})
+
+	// own loads a view owned by the caller.
+	own := func(c *gin.Context) (*View, bool) {
+		var v View
+		if err := viewColl.FindOne(context.TODO(), bson.M{"_id": c.Param("id")}).Decode(&v); err != nil {
+			c.JSON(http.StatusNotFound, gin.H{"error": "view not found"})
+			return nil, false
+		}
+		if v.OwnerID != currentUserID(c) {
+			c.JSON(http.StatusForbidden, gin.H{"error": "only the owner can change a view"})
+			return nil, false
+		}
+		return &v, true
+	}
+
+	// GET /api/views?boardId= — the caller's views and the shared ones,
+	// for a board (plus cross-board views) or all of them.
+	router.GET("/api/views", func(c *gin.Context) {
+		filter := bson.M{"$or": bson.A{bson.M{"shared": true}}}
+		if uid := currentUserID(c); uid != "" {
+			filter["$or"] = append(filter["$or"].(bson.A), bson.M{"ownerId": uid})
+		}
+		if boardID := c.Query("boardId"); boardID != "" {
+			filter["boardId"] = bson.M{"$in": bson.A{boardID, nil}}
+		}
+		cur, err := viewColl.Find(context.TODO(), filter, options.Find().SetSort(bson.D{{Key: "name", Value: 1}}))
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		out := []View{}
+		if err := cur.All(context.TODO(), &out); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(http.StatusOK, out)
+	})
+
+	router.GET("/api/views/:id", func(c *gin.Context) {
+		v, err := loadView(context.TODO(), db, c.Param("id"), currentUserID(c))
+		if err != nil {
+			c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(http.StatusOK, v)
+	})
+
+	router.POST("/api/views", requireUser(), func(c *gin.Context) {
+		var v View
+		if err := c.BindJSON(&v); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		if err := v.validate(context.TODO(), db, currentUserID(c)); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		if v.BoardID != "" {
+			if n, _ := boardColl.CountDocuments(context.TODO(), bson.M{"_id": v.BoardID, "deletedAt": nil}); n == 0 {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "board not found"})
+				return
+			}
+		}
+		now := time.Now()
+		v.ID = newID()
+		v.OwnerID = currentUserID(c)
+		v.CreatedAt, v.UpdatedAt = now, now
+		if _, err := viewColl.InsertOne(context.TODO(), v); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(http.StatusOK, v)
+	})
+
+	// PUT /api/views/:id replaces the settings; the board stays the same.
+	router.PUT("/api/views/:id", requireUser(), func(c *gin.Context) {
+		v, ok := own(c)
+		if !ok {
+			return
+		}
+		var in View
+		if err := c.BindJSON(&in); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		in.BoardID = v.BoardID
+		if err := in.validate(context.TODO(), db, v.OwnerID); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		if !in.Shared && v.Shared {
+			if n, _ := boardColl.CountDocuments(context.TODO(), bson.M{"defaultViewId": v.ID}); n > 0 {
+				c.JSON(http.StatusConflict, gin.H{"error": "view is a board default and must stay shared"})
+				return
+			}
+		}
+		in.ID, in.OwnerID, in.CreatedAt = v.ID, v.OwnerID, v.CreatedAt
+		in.UpdatedAt = time.Now()
+		if _, err := viewColl.ReplaceOne(context.TODO(), bson.M{"_id": v.ID}, in); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(http.StatusOK, in)
+	})
+
+	router.DELETE("/api/views/:id", requireUser(), func(c *gin.Context) {
+		v, ok := own(c)
+		if !ok {
+			return
+		}
+		if _, err := viewColl.DeleteOne(context.TODO(), bson.M{"_id": v.ID}); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		_, _ = boardColl.UpdateMany(context.TODO(), bson.M{"defaultViewId": v.ID}, withVersion(bson.M{"$unset": bson.M{"defaultViewId": ""}}))
+		c.JSON(http.StatusOK, gin.H{"status": "deleted"})
+	})
+}
@@ -4,6 +4,7 @@ import (
 	kanban "bell-backend/board"
 	"bell-backend/config"
 	"bell-backend/routes"
+	"bell-backend/webhooks"
 	wikimodule "bell-backend/wiki"
 	"log"
 	"time"
@@ -22,8 +23,8 @@ func main() {
 	r.Use(cors.New(cors.Config{
 		AllowOrigins:     []string{"http://localhost:3000"},
 		AllowMethods:     []string{"GET", "POST", "PUT", "DELETE", "OPTIONS"},
-		AllowHeaders:     []string{"Origin", "Content-Type", "Authorization"},
-		ExposeHeaders:    []string{"Content-Length"},
+		AllowHeaders:     []string{"Origin", "Content-Type", "Authorization", "If-Match", "If-None-Match"},
+		ExposeHeaders:    []string{"Content-Length", "ETag"},
 		AllowCredentials: true,
 		MaxAge:           12 * time.Hour,
 	}))
@@ -33,9 +34,13 @@ func main() {
 	routes.RegisterAdminRoutes(r)
 	routes.AuthRoutes(r)
 	// === Роут kanban ===
-	kanban.RegisterKanbanRoutes(r, config.ConnectDB())
+	hooks := webhooks.New(config.DB)
+	hooks.Start()
+	hooks.RegisterRoutes(r, routes.AuthRequired())
+	kanban.RegisterKanbanRoutes(r, config.ConnectDB(), hooks)
 	// === Роут вики ===
 	wiki := wikimodule.NewWikiModule(config.DB.Client())
+	wiki.Hooks = hooks
 	wiki.RegisterRoutes(r)
 	// Запуск сервера
 	if err := r.Run(":8080"); err != nil {
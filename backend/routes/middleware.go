@@ -27,6 +27,9 @@ func AuthRequired() gin.HandlerFunc {
 		}
 
 		c.Set("userID", claims["user_id"])
+		if role, ok := claims["role"].(string); ok {
+			c.Set("role", role)
+		}
 		c.Next()
 	}
 
@@ -0,0 +1,312 @@
+package webhooks
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// subscriptionInput is what clients send to create or change a subscription.
+type subscriptionInput struct {
+	URL     *string   `json:"url"`
+	Events  *[]string `json:"events"`
+	BoardID *string   `json:"boardId"`
+	Enabled *bool     `json:"enabled"`
+	Secret  *string   `json:"secret"`
+}
+
+func (in *subscriptionInput) apply(s *Subscription) error {
+	if in.URL != nil {
+		s.URL = strings.TrimSpace(*in.URL)
+	}
+	if in.Events != nil {
+		s.Events = nil
+		for _, e := range *in.Events {
+			if e = strings.TrimSpace(e); e != "" {
+				s.Events = append(s.Events, e)
+			}
+		}
+	}
+	if in.BoardID != nil {
+		s.BoardID = *in.BoardID
+	}
+	if in.Enabled != nil {
+		if *in.Enabled && !s.Enabled {
+			s.Failures, s.DisabledReason = 0, ""
+		}
+		s.Enabled = *in.Enabled
+	}
+	if in.Secret != nil {
+		s.Secret = *in.Secret
+	}
+	u, err := url.Parse(s.URL)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return errors.New("url must be an http(s) URL")
+	}
+	if in.URL != nil {
+		if err := publicHost(context.TODO(), u.Hostname()); err != nil {
+			return fmt.Errorf("url: %v", err)
+		}
+	}
+	if len(s.Secret) < 16 {
+		return errors.New("secret must be at least 16 characters")
+	}
+	return nil
+}
+
+// maxPage bounds ?page= on the delivery log so the skip can't overflow.
+const maxPage = 100000
+
+// viewer is the signed-in user and whether they are an admin, as set by
+// the authentication middleware.
+func viewer(c *gin.Context) (string, bool) {
+	var userID string
+	if uid, ok := c.Get("userID"); ok {
+		userID = fmt.Sprint(uid)
+	}
+	return userID, c.GetString("role") == "admin"
+}
+
+// RegisterRoutes adds the subscription and delivery log API. middleware
+// (authentication) runs before every handler. Users see and change only
+// the subscriptions they created; admins see all of them.
+func (d *Dispatcher) RegisterRoutes(router *gin.Engine, middleware ...gin.HandlerFunc) {
+	api := router.Group("/api/webhooks", middleware...)
+
+	load := func(c *gin.Context) (*Subscription, bool) {
+		id, err := primitive.ObjectIDFromHex(c.Param("id"))
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid id"})
+			return nil, false
+		}
+		filter := bson.M{"_id": id}
+		if userID, admin := viewer(c); !admin {
+			filter["createdBy"] = userID
+		}
+		var s Subscription
+		if err := d.subs.FindOne(context.TODO(), filter).Decode(&s); err != nil {
+			c.JSON(http.StatusNotFound, gin.H{"error": "webhook not found"})
+			return nil, false
+		}
+		return &s, true
+	}
+
+	// GET /api/webhooks?boardId= — secrets are not listed.
+	api.GET("", func(c *gin.Context) {
+		filter := bson.M{}
+		if userID, admin := viewer(c); !admin {
+			filter["createdBy"] = userID
+		}
+		if boardID := c.Query("boardId"); boardID != "" {
+			filter["boardId"] = boardID
+		}
+		cur, err := d.subs.Find(context.TODO(), filter, options.Find().SetSort(bson.D{{Key: "_id", Value: 1}}))
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		out := []Subscription{}
+		if err := cur.All(context.TODO(), &out); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		for i := range out {
+			out[i].Secret = ""
+		}
+		c.JSON(http.StatusOK, out)
+	})
+
+	api.GET("/:id", func(c *gin.Context) {
+		if s, ok := load(c); ok {
+			s.Secret = ""
+			c.JSON(http.StatusOK, s)
+		}
+	})
+
+	// POST /api/webhooks {"url", "events", "boardId", "secret"} — a secret
+	// is generated when none is given; this is the only response with it.
+	api.POST("", func(c *gin.Context) {
+		var in subscriptionInput
+		if err := c.BindJSON(&in); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		now := time.Now()
+		s := Subscription{ID: primitive.NewObjectID(), Enabled: true, Secret: newSecret(), CreatedAt: now, UpdatedAt: now}
+		s.CreatedBy, _ = viewer(c)
+		if err := in.apply(&s); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		if _, err := d.subs.InsertOne(context.TODO(), s); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(http.StatusOK, s)
+	})
+
+	// PUT /api/webhooks/:id changes the given fields. Enabling a disabled
+	// subscription resets its failure count.
+	api.PUT("/:id", func(c *gin.Context) {
+		s, ok := load(c)
+		if !ok {
+			return
+		}
+		var in subscriptionInput
+		if err := c.BindJSON(&in); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		if err := in.apply(s); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		s.UpdatedAt = time.Now()
+		if _, err := d.subs.ReplaceOne(context.TODO(), bson.M{"_id": s.ID}, s); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		s.Secret = ""
+		c.JSON(http.StatusOK, s)
+	})
+
+	api.DELETE("/:id", func(c *gin.Context) {
+		s, ok := load(c)
+		if !ok {
+			return
+		}
+		if _, err := d.subs.DeleteOne(context.TODO(), bson.M{"_id": s.ID}); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		_, _ = d.deliveries.DeleteMany(context.TODO(), bson.M{"webhookId": s.ID})
+		c.JSON(http.StatusOK, gin.H{"status": "deleted"})
+	})
+
+	// POST /api/webhooks/:id/ping queues a "ping" event for this endpoint
+	// only, to check the URL and signature handling.
+	api.POST("/:id/ping", func(c *gin.Context) {
+		s, ok := load(c)
+		if !ok {
+			return
+		}
+		now := time.Now()
+		id := primitive.NewObjectID()
+		body := fmt.Sprintf(`{"id":%q,"event":"ping","createdAt":%q,"data":{"webhookId":%q}}`,
+			id.Hex(), now.UTC().Format(time.RFC3339Nano), s.ID.Hex())
+		dl := Delivery{ID: id, WebhookID: s.ID, Event: "ping", Body: body, Status: StatusPending, NextAttempt: now, CreatedAt: now}
+		if _, err := d.deliveries.InsertOne(context.TODO(), dl); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		d.kick()
+		c.JSON(http.StatusOK, dl)
+	})
+
+	// GET /api/webhooks/:id/deliveries?status=&page=1&limit=50 — newest
+	// first, without bodies.
+	api.GET("/:id/deliveries", func(c *gin.Context) {
+		s, ok := load(c)
+		if !ok {
+			return
+		}
+		page, _ := strconv.Atoi(c.DefaultQuery("page", "1"))
+		limit, _ := strconv.Atoi(c.DefaultQuery("limit", "50"))
+		if page < 1 {
+			page = 1
+		} else if page > maxPage {
+			page = maxPage
+		}
+		if limit < 1 || limit > 200 {
+			limit = 50
+		}
+		filter := bson.M{"webhookId": s.ID}
+		if status := c.Query("status"); status != "" {
+			filter["status"] = status
+		}
+		total, err := d.deliveries.CountDocuments(context.TODO(), filter)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		cur, err := d.deliveries.Find(context.TODO(), filter, options.Find().
+			SetSort(bson.D{{Key: "_id", Value: -1}}).
+			SetSkip(int64((page-1)*limit)).
+			SetLimit(int64(limit)).
+			SetProjection(bson.M{"body": 0}))
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		items := []Delivery{}
+		if err := cur.All(context.TODO(), &items); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{"items": items, "total": total, "page": page, "limit": limit})
+	})
+
+	loadDelivery := func(c *gin.Context, s *Subscription) (*Delivery, bool) {
+		id, err := primitive.ObjectIDFromHex(c.Param("deliveryId"))
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid delivery id"})
+			return nil, false
+		}
+		var dl Delivery
+		if err := d.deliveries.FindOne(context.TODO(), bson.M{"_id": id, "webhookId": s.ID}).Decode(&dl); err != nil {
+			c.JSON(http.StatusNotFound, gin.H{"error": "delivery not found"})
+			return nil, false
+		}
+		return &dl, true
+	}
+
+	api.GET("/:id/deliveries/:deliveryId", func(c *gin.Context) {
+		s, ok := load(c)
+		if !ok {
+			return
+		}
+		if dl, ok := loadDelivery(c, s); ok {
+			c.JSON(http.StatusOK, dl)
+		}
+	})
+
+	// POST /api/webhooks/:id/deliveries/:deliveryId/redeliver sends the same
+	// body again with a fresh set of retries; earlier attempts stay listed.
+	api.POST("/:id/deliveries/:deliveryId/redeliver", func(c *gin.Context) {
+		s, ok := load(c)
+		if !ok {
+			return
+		}
+		dl, ok := loadDelivery(c, s)
+		if !ok {
+			return
+		}
+		if !s.Enabled {
+			c.JSON(http.StatusConflict, gin.H{"error": "webhook is disabled"})
+			return
+		}
+		res, err := d.deliveries.UpdateOne(context.TODO(),
+			bson.M{"_id": dl.ID, "status": bson.M{"$ne": StatusSending}},
+			bson.M{"$set": bson.M{"status": StatusPending, "tries": 0, "nextAttempt": time.Now()}})
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		if res.MatchedCount == 0 {
+			c.JSON(http.StatusConflict, gin.H{"error": "delivery is being sent"})
+			return
+		}
+		d.kick()
+		c.JSON(http.StatusOK, gin.H{"status": StatusPending})
+	})
+}
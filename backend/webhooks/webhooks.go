@@ -0,0 +1,492 @@
+// Package webhooks delivers board and wiki events to subscribed HTTP
+// endpoints. Events are queued in Mongo, signed with the subscription's
+// secret and retried with exponential backoff, so deliveries survive
+// restarts and are shared between replicas.
+package webhooks
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"net/netip"
+	"strings"
+	"syscall"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+const (
+	// maxAttempts is how often a delivery is tried before it is given up.
+	maxAttempts = 8
+	// firstRetry is the wait after the first failure; it doubles each time
+	// up to maxRetryWait.
+	firstRetry   = 30 * time.Second
+	maxRetryWait = 2 * time.Hour
+	// disableAfter consecutive failed attempts turn a subscription off.
+	disableAfter = 20
+	// lease is how long a worker owns a delivery it is sending.
+	lease = time.Minute
+)
+
+// Delivery states.
+const (
+	StatusPending   = "pending"
+	StatusSending   = "sending"
+	StatusSucceeded = "succeeded"
+	StatusFailed    = "failed"
+)
+
+// Subscription is an endpoint and the events it wants. Events are exact
+// types ("card.moved"), prefixes ("card.*") or "*"; none means all. BoardID
+// limits board events to one board (wiki events have no board and are
+// never sent to such subscriptions).
+type Subscription struct {
+	ID             primitive.ObjectID `bson:"_id,omitempty" json:"_id"`
+	URL            string             `bson:"url" json:"url"`
+	Secret         string             `bson:"secret" json:"secret,omitempty"` // only returned when created
+	Events         []string           `bson:"events,omitempty" json:"events,omitempty"`
+	BoardID        string             `bson:"boardId,omitempty" json:"boardId,omitempty"`
+	Enabled        bool               `bson:"enabled" json:"enabled"`
+	DisabledReason string             `bson:"disabledReason,omitempty" json:"disabledReason,omitempty"`
+	Failures       int                `bson:"failures" json:"failures"` // consecutive failed attempts
+	CreatedBy      string             `bson:"createdBy,omitempty" json:"createdBy,omitempty"`
+	CreatedAt      time.Time          `bson:"createdAt" json:"createdAt"`
+	UpdatedAt      time.Time          `bson:"updatedAt" json:"updatedAt"`
+}
+
+// wants reports whether the subscription takes an event.
+func (s *Subscription) wants(event, boardID string) bool {
+	if s.BoardID != "" && s.BoardID != boardID {
+		return false
+	}
+	if len(s.Events) == 0 {
+		return true
+	}
+	for _, e := range s.Events {
+		if e == "*" || e == event || (strings.HasSuffix(e, ".*") && strings.HasPrefix(event, strings.TrimSuffix(e, "*"))) {
+			return true
+		}
+	}
+	return false
+}
+
+// Attempt is one try at sending a delivery.
+type Attempt struct {
+	At         time.Time `bson:"at" json:"at"`
+	StatusCode int       `bson:"statusCode,omitempty" json:"statusCode,omitempty"`
+	Error      string    `bson:"error,omitempty" json:"error,omitempty"`
+	Response   string    `bson:"response,omitempty" json:"response,omitempty"` // start of the body
+	Duration   int64     `bson:"duration" json:"duration"`                     // milliseconds
+}
+
+// Delivery is one event for one subscription, with every attempt made.
+// Body is the exact JSON that is signed and sent.
+type Delivery struct {
+	ID          primitive.ObjectID `bson:"_id,omitempty" json:"_id"`
+	WebhookID   primitive.ObjectID `bson:"webhookId" json:"webhookId"`
+	Event       string             `bson:"event" json:"event"`
+	BoardID     string             `bson:"boardId,omitempty" json:"boardId,omitempty"`
+	Body        string             `bson:"body" json:"body,omitempty"`
+	Status      string             `bson:"status" json:"status"`
+	Attempts    []Attempt          `bson:"attempts,omitempty" json:"attempts,omitempty"`
+	Tries       int                `bson:"tries" json:"tries"` // attempts since the last (re)delivery
+	NextAttempt time.Time          `bson:"nextAttempt" json:"nextAttempt"`
+	LockedUntil *time.Time         `bson:"lockedUntil,omitempty" json:"-"`
+	DeliveredAt *time.Time         `bson:"deliveredAt,omitempty" json:"deliveredAt,omitempty"`
+	CreatedAt   time.Time          `bson:"createdAt" json:"createdAt"`
+}
+
+// envelope is the JSON posted to endpoints.
+type envelope struct {
+	ID        string          `json:"id"`
+	Event     string          `json:"event"`
+	BoardID   string          `json:"boardId,omitempty"`
+	ActorID   string          `json:"actorId,omitempty"`
+	CreatedAt time.Time       `json:"createdAt"`
+	Data      json.RawMessage `json:"data"`
+}
+
+// Sign returns the X-Bell-Signature value for a body: "sha256=" and the
+// hex HMAC-SHA256 of the body under the subscription's secret. Receivers
+// compute the same and compare in constant time.
+func Sign(secret string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// newSecret returns a random signing secret.
+func newSecret() string {
+	b := make([]byte, 24)
+	_, _ = rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// backoff is the wait before retry number tries (1 after the first failure).
+func backoff(tries int) time.Duration {
+	wait := firstRetry
+	for i := 1; i < tries && wait < maxRetryWait; i++ {
+		wait *= 2
+	}
+	if wait > maxRetryWait {
+		wait = maxRetryWait
+	}
+	return wait
+}
+
+// blockedNets are ranges that are not private in the net/netip sense but
+// still lead inside: shared address space, benchmarking and NAT64, which
+// can wrap any IPv4 address.
+var blockedNets = []netip.Prefix{
+	netip.MustParsePrefix("0.0.0.0/8"),
+	netip.MustParsePrefix("100.64.0.0/10"),
+	netip.MustParsePrefix("198.18.0.0/15"),
+	netip.MustParsePrefix("64:ff9b::/96"),
+}
+
+// publicAddr reports whether an address is on the public internet: not
+// loopback, private, link-local, multicast or unspecified.
+func publicAddr(a netip.Addr) bool {
+	a = a.Unmap()
+	if !a.IsGlobalUnicast() || a.IsPrivate() {
+		return false
+	}
+	for _, p := range blockedNets {
+		if p.Contains(a) {
+			return false
+		}
+	}
+	return true
+}
+
+// publicHost resolves host and fails unless all its addresses are public.
+// It catches bad URLs early; dialPublic is what enforces it, since the
+// name can resolve elsewhere by the time a delivery is sent.
+func publicHost(ctx context.Context, host string) error {
+	addrs, err := net.DefaultResolver.LookupNetIP(ctx, "ip", host)
+	if err != nil {
+		return fmt.Errorf("cannot resolve %s", host)
+	}
+	for _, a := range addrs {
+		if !publicAddr(a) {
+			return fmt.Errorf("%s is not a public address", host)
+		}
+	}
+	return nil
+}
+
+// dialPublic refuses connections to anything but public addresses. It runs
+// after name resolution, for every connection including redirects, so
+// subscribers can't reach the server's own network.
+func dialPublic(network, address string, _ syscall.RawConn) error {
+	ap, err := netip.ParseAddrPort(address)
+	if err != nil {
+		return err
+	}
+	if !publicAddr(ap.Addr()) {
+		return fmt.Errorf("%s is not a public address", ap.Addr())
+	}
+	return nil
+}
+
+// publicClient is the HTTP client deliveries go out with. It uses no
+// proxy: a proxy would dial for us and skip dialPublic.
+func publicClient() *http.Client {
+	dialer := &net.Dialer{Timeout: 5 * time.Second, Control: dialPublic}
+	return &http.Client{
+		Timeout: 10 * time.Second,
+		Transport: &http.Transport{
+			DialContext:         dialer.DialContext,
+			TLSHandshakeTimeout: 5 * time.Second,
+			MaxIdleConns:        10,
+			IdleConnTimeout:     90 * time.Second,
+		},
+	}
+}
+
+// Dispatcher queues events for matching subscriptions and sends them.
+// A nil *Dispatcher ignores events.
+type Dispatcher struct {
+	subs       *mongo.Collection
+	deliveries *mongo.Collection
+	client     *http.Client
+	wake       chan struct{}
+}
+
+// New prepares the collections; call Start to begin sending.
+func New(db *mongo.Database) *Dispatcher {
+	d := &Dispatcher{
+		subs:       db.Collection("webhooks"),
+		deliveries: db.Collection("webhook_deliveries"),
+		client:     publicClient(),
+		wake:       make(chan struct{}, 1),
+	}
+	_, _ = d.deliveries.Indexes().CreateMany(context.TODO(), []mongo.IndexModel{
+		{Keys: bson.D{{Key: "status", Value: 1}, {Key: "nextAttempt", Value: 1}}},
+		{Keys: bson.D{{Key: "webhookId", Value: 1}, {Key: "createdAt", Value: -1}}},
+		{Keys: bson.D{{Key: "createdAt", Value: 1}}, Options: options.Index().SetExpireAfterSeconds(30 * 24 * 60 * 60)},
+	})
+	return d
+}
+
+// Publish queues event for every enabled subscription that wants it. data
+// is the event's document, already JSON or anything json.Marshal takes.
+// Errors are logged: a failed queue must not fail the request behind it.
+func (d *Dispatcher) Publish(event, boardID, actorID string, data interface{}) {
+	if d == nil {
+		return
+	}
+	ctx := context.TODO()
+	var subs []Subscription
+	cur, err := d.subs.Find(ctx, bson.M{"enabled": true})
+	if err != nil {
+		log.Println("webhooks:", err)
+		return
+	}
+	if err := cur.All(ctx, &subs); err != nil {
+		log.Println("webhooks:", err)
+		return
+	}
+	raw, ok := data.(json.RawMessage)
+	if !ok {
+		if raw, err = json.Marshal(data); err != nil {
+			log.Println("webhooks: encode:", err)
+			return
+		}
+	}
+	now := time.Now()
+	var queued []interface{}
+	for i := range subs {
+		if !subs[i].wants(event, boardID) {
+			continue
+		}
+		id := primitive.NewObjectID()
+		body, _ := json.Marshal(envelope{ID: id.Hex(), Event: event, BoardID: boardID, ActorID: actorID, CreatedAt: now, Data: raw})
+		queued = append(queued, Delivery{
+			ID:          id,
+			WebhookID:   subs[i].ID,
+			Event:       event,
+			BoardID:     boardID,
+			Body:        string(body),
+			Status:      StatusPending,
+			NextAttempt: now,
+			CreatedAt:   now,
+		})
+	}
+	if len(queued) == 0 {
+		return
+	}
+	if _, err := d.deliveries.InsertMany(ctx, queued); err != nil {
+		log.Println("webhooks: queue:", err)
+		return
+	}
+	d.kick()
+}
+
+// Deliver queues event for the one subscription id, whatever events it
+// takes otherwise; board automations use it to call an endpoint a rule
+// names. It fails when the subscription is missing, disabled or limited
+// to another board.
+func (d *Dispatcher) Deliver(id, event, boardID, actorID string, data interface{}) error {
+	if d == nil {
+		return errors.New("webhooks are not set up")
+	}
+	sub, err := d.lookup(id)
+	if err != nil {
+		return err
+	}
+	if !sub.Enabled {
+		return errors.New("webhook is disabled")
+	}
+	if sub.BoardID != "" && sub.BoardID != boardID {
+		return errors.New("webhook is limited to another board")
+	}
+	raw, err := json.Marshal(data)
+	if err != nil {
+		return err
+	}
+	now := time.Now()
+	dl := Delivery{ID: primitive.NewObjectID(), WebhookID: sub.ID, Event: event, BoardID: boardID, Status: StatusPending, NextAttempt: now, CreatedAt: now}
+	body, _ := json.Marshal(envelope{ID: dl.ID.Hex(), Event: event, BoardID: boardID, ActorID: actorID, CreatedAt: now, Data: raw})
+	dl.Body = string(body)
+	if _, err := d.deliveries.InsertOne(context.TODO(), dl); err != nil {
+		return err
+	}
+	d.kick()
+	return nil
+}
+
+// Owner returns who created subscription id, so callers can let users pick
+// only their own.
+func (d *Dispatcher) Owner(id string) (string, error) {
+	if d == nil {
+		return "", errors.New("webhooks are not set up")
+	}
+	sub, err := d.lookup(id)
+	if err != nil {
+		return "", err
+	}
+	return sub.CreatedBy, nil
+}
+
+func (d *Dispatcher) lookup(id string) (*Subscription, error) {
+	oid, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		return nil, errors.New("webhook not found")
+	}
+	var sub Subscription
+	if err := d.subs.FindOne(context.TODO(), bson.M{"_id": oid}).Decode(&sub); err != nil {
+		if err == mongo.ErrNoDocuments {
+			return nil, errors.New("webhook not found")
+		}
+		return nil, err
+	}
+	return &sub, nil
+}
+
+// kick wakes the worker without blocking.
+func (d *Dispatcher) kick() {
+	select {
+	case d.wake <- struct{}{}:
+	default:
+	}
+}
+
+// Start runs the worker: it sends due deliveries whenever something is
+// queued and at least every few seconds for retries.
+func (d *Dispatcher) Start() {
+	go func() {
+		ticker := time.NewTicker(5 * time.Second)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+			case <-d.wake:
+			}
+			for d.sendNext(time.Now()) {
+			}
+		}
+	}()
+}
+
+// sendNext claims one due delivery and sends it. It reports whether there
+// was one. A delivery left "sending" by a crashed worker is claimed again
+// once its lease runs out.
+func (d *Dispatcher) sendNext(now time.Time) bool {
+	ctx := context.TODO()
+	until := now.Add(lease)
+	var dl Delivery
+	err := d.deliveries.FindOneAndUpdate(ctx,
+		bson.M{"$or": bson.A{
+			bson.M{"status": StatusPending, "nextAttempt": bson.M{"$lte": now}},
+			bson.M{"status": StatusSending, "lockedUntil": bson.M{"$lt": now}},
+		}},
+		bson.M{"$set": bson.M{"status": StatusSending, "lockedUntil": until}},
+		options.FindOneAndUpdate().SetSort(bson.D{{Key: "nextAttempt", Value: 1}}).SetReturnDocument(options.After),
+	).Decode(&dl)
+	if err != nil {
+		if err != mongo.ErrNoDocuments {
+			log.Println("webhooks: claim:", err)
+		}
+		return false
+	}
+
+	var sub Subscription
+	if err := d.subs.FindOne(ctx, bson.M{"_id": dl.WebhookID}).Decode(&sub); err != nil || !sub.Enabled {
+		_, _ = d.deliveries.UpdateOne(ctx, bson.M{"_id": dl.ID}, bson.M{
+			"$set":   bson.M{"status": StatusFailed},
+			"$unset": bson.M{"lockedUntil": ""},
+			"$push":  bson.M{"attempts": Attempt{At: now, Error: "webhook deleted or disabled"}},
+		})
+		return true
+	}
+
+	attempt := d.send(&sub, &dl)
+	set := bson.M{"tries": dl.Tries + 1}
+	if attempt.Error == "" {
+		set["status"] = StatusSucceeded
+		set["deliveredAt"] = attempt.At
+	} else if dl.Tries+1 >= maxAttempts {
+		set["status"] = StatusFailed
+	} else {
+		set["status"] = StatusPending
+		set["nextAttempt"] = time.Now().Add(backoff(dl.Tries + 1))
+	}
+	_, _ = d.deliveries.UpdateOne(ctx, bson.M{"_id": dl.ID, "lockedUntil": until}, bson.M{
+		"$set":   set,
+		"$unset": bson.M{"lockedUntil": ""},
+		"$push":  bson.M{"attempts": attempt},
+	})
+	d.track(&sub, attempt.Error == "")
+	return true
+}
+
+// send posts one delivery and reports how it went; any non-2xx answer is
+// a failure.
+func (d *Dispatcher) send(sub *Subscription, dl *Delivery) Attempt {
+	body := []byte(dl.Body)
+	started := time.Now()
+	attempt := Attempt{At: started}
+	req, err := http.NewRequest(http.MethodPost, sub.URL, bytes.NewReader(body))
+	if err != nil {
+		attempt.Error = err.Error()
+		return attempt
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "Bell-Webhooks/1")
+	req.Header.Set("X-Bell-Event", dl.Event)
+	req.Header.Set("X-Bell-Delivery", dl.ID.Hex())
+	req.Header.Set("X-Bell-Signature", Sign(sub.Secret, body))
+	resp, err := d.client.Do(req)
+	attempt.Duration = time.Since(started).Milliseconds()
+	if err != nil {
+		attempt.Error = err.Error()
+		return attempt
+	}
+	defer resp.Body.Close()
+	snippet, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+	attempt.StatusCode = resp.StatusCode
+	attempt.Response = string(snippet)
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		attempt.Error = resp.Status
+	}
+	return attempt
+}
+
+// track counts consecutive failures of a subscription and turns it off
+// after disableAfter of them; its queued deliveries then fail on claim.
+func (d *Dispatcher) track(sub *Subscription, ok bool) {
+	ctx := context.TODO()
+	if ok {
+		if sub.Failures > 0 {
+			_, _ = d.subs.UpdateOne(ctx, bson.M{"_id": sub.ID}, bson.M{"$set": bson.M{"failures": 0}})
+		}
+		return
+	}
+	var after Subscription
+	err := d.subs.FindOneAndUpdate(ctx, bson.M{"_id": sub.ID}, bson.M{"$inc": bson.M{"failures": 1}},
+		options.FindOneAndUpdate().SetReturnDocument(options.After)).Decode(&after)
+	if err != nil || after.Failures < disableAfter || !after.Enabled {
+		return
+	}
+	_, _ = d.subs.UpdateOne(ctx, bson.M{"_id": sub.ID, "enabled": true}, bson.M{"$set": bson.M{
+		"enabled":        false,
+		"disabledReason": fmt.Sprintf("disabled after %d failed attempts in a row", after.Failures),
+		"updatedAt":      time.Now(),
+	}})
+	log.Printf("webhooks: disabled %s (%s) after %d failures", sub.ID.Hex(), sub.URL, after.Failures)
+}
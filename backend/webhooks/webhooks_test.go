@@ -0,0 +1,424 @@
+package webhooks
+
+import (
+	"context"
+	"crypto/hmac"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/netip"
+	"os"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// testDB connects to MONGO_TEST_URI and returns a fresh database that is
+// dropped after the test. Tests that need it are skipped without the URI.
+func testDB(t *testing.T) *mongo.Database {
+	t.Helper()
+	uri := os.Getenv("MONGO_TEST_URI")
+	if uri == "" {
+		t.Skip("MONGO_TEST_URI not set")
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	client, err := mongo.Connect(ctx, options.Client().ApplyURI(uri))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := client.Ping(ctx, nil); err != nil {
+		t.Fatal(err)
+	}
+	db := client.Database("bell_test_" + primitive.NewObjectID().Hex())
+	t.Cleanup(func() {
+		_ = db.Drop(context.Background())
+		_ = client.Disconnect(context.Background())
+	})
+	return db
+}
+
+// receiver is an endpoint that checks signatures and answers with the
+// queued status codes, then 200.
+type receiver struct {
+	*httptest.Server
+	secret string
+
+	mu       sync.Mutex
+	statuses []int
+	got      []string // X-Bell-Event of each request
+	bad      int      // requests with a wrong signature
+}
+
+func newReceiver(t *testing.T, secret string, statuses ...int) *receiver {
+	r := &receiver{secret: secret, statuses: statuses}
+	r.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		body, _ := io.ReadAll(req.Body)
+		r.mu.Lock()
+		defer r.mu.Unlock()
+		if !hmac.Equal([]byte(req.Header.Get("X-Bell-Signature")), []byte(Sign(r.secret, body))) {
+			r.bad++
+		}
+		r.got = append(r.got, req.Header.Get("X-Bell-Event"))
+		status := http.StatusOK
+		if len(r.statuses) > 0 {
+			status, r.statuses = r.statuses[0], r.statuses[1:]
+		}
+		w.WriteHeader(status)
+	}))
+	t.Cleanup(r.Close)
+	return r
+}
+
+func (r *receiver) requests() (int, int) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return len(r.got), r.bad
+}
+
+func TestSign(t *testing.T) {
+	// RFC 4231, test case 2
+	got := Sign("Jefe", []byte("what do ya want for nothing?"))
+	if want := "sha256=5bdcc146bf60754e6a042426089575c75a003f089d2739839dec58b964ec3843"; got != want {
+		t.Errorf("Sign = %s, want %s", got, want)
+	}
+	body := []byte(`{"event":"card.created"}`)
+	if Sign("secret-one-secret", body) == Sign("secret-two-secret", body) {
+		t.Error("different secrets give the same signature")
+	}
+	if Sign("secret-one-secret", body) == Sign("secret-one-secret", append(body, ' ')) {
+		t.Error("different bodies give the same signature")
+	}
+}
+
+func TestWants(t *testing.T) {
+	for _, tc := range []struct {
+		events  []string
+		board   string
+		event   string
+		boardID string
+		want    bool
+	}{
+		{nil, "", "card.created", "b1", true},
+		{nil, "", "wiki.page.updated", "", true},
+		{[]string{"*"}, "", "card.moved", "b1", true},
+		{[]string{"card.moved"}, "", "card.moved", "b1", true},
+		{[]string{"card.moved"}, "", "card.created", "b1", false},
+		{[]string{"card.*"}, "", "card.deleted", "b1", true},
+		{[]string{"card.*"}, "", "cards.deleted", "b1", false},
+		{[]string{"card.*"}, "", "comment.created", "b1", false},
+		{[]string{"comment.created", "card.*"}, "", "comment.created", "b1", true},
+		{nil, "b1", "card.created", "b1", true},
+		{nil, "b1", "card.created", "b2", false},
+		{nil, "b1", "wiki.page.updated", "", false},
+	} {
+		s := Subscription{Events: tc.events, BoardID: tc.board}
+		if got := s.wants(tc.event, tc.boardID); got != tc.want {
+			t.Errorf("%+v wants(%q, %q) = %v, want %v", s, tc.event, tc.boardID, got, tc.want)
+		}
+	}
+}
+
+func TestBackoff(t *testing.T) {
+	for tries, want := range map[int]time.Duration{
+		0:  30 * time.Second,
+		1:  30 * time.Second,
+		2:  time.Minute,
+		3:  2 * time.Minute,
+		7:  32 * time.Minute,
+		8:  64 * time.Minute,
+		9:  2 * time.Hour,
+		50: 2 * time.Hour,
+	} {
+		if got := backoff(tries); got != want {
+			t.Errorf("backoff(%d) = %v, want %v", tries, got, want)
+		}
+	}
+}
+
+func TestPublicAddr(t *testing.T) {
+	for addr, want := range map[string]bool{
+		"93.184.215.14":         true,
+		"2606:2800:21f:cb07::1": true,
+		"127.0.0.1":             false,
+		"::1":                   false,
+		"10.1.2.3":              false,
+		"172.16.0.1":            false,
+		"192.168.1.1":           false,
+		"169.254.169.254":       false,
+		"0.0.0.0":               false,
+		"::":                    false,
+		"100.100.100.200":       false,
+		"fd00::1":               false,
+		"fe80::1":               false,
+		"::ffff:127.0.0.1":      false,
+		"64:ff9b::a00:1":        false,
+		"224.0.0.1":             false,
+		"255.255.255.255":       false,
+	} {
+		if got := publicAddr(netip.MustParseAddr(addr)); got != want {
+			t.Errorf("publicAddr(%s) = %v, want %v", addr, got, want)
+		}
+	}
+}
+
+func TestSubscriptionURL(t *testing.T) {
+	for url, ok := range map[string]bool{
+		"https://93.184.215.14/hook":  true,
+		"ftp://93.184.215.14/hook":    false,
+		"http://127.0.0.1:8080/hook":  false,
+		"http://[::1]/hook":           false,
+		"http://10.0.0.5/hook":        false,
+		"http://169.254.169.254/meta": false,
+	} {
+		s := Subscription{Secret: "0123456789abcdef"}
+		err := (&subscriptionInput{URL: &url}).apply(&s)
+		if (err == nil) != ok {
+			t.Errorf("apply(%q) = %v, want ok %v", url, err, ok)
+		}
+	}
+}
+
+func TestDialPublic(t *testing.T) {
+	r := newReceiver(t, "unused")
+	resp, err := publicClient().Get(r.URL)
+	if err == nil {
+		resp.Body.Close()
+		t.Fatalf("GET %s went through", r.URL)
+	}
+	if !strings.Contains(err.Error(), "not a public address") {
+		t.Errorf("GET %s: %v", r.URL, err)
+	}
+	if n, _ := r.requests(); n != 0 {
+		t.Errorf("receiver got %d requests", n)
+	}
+}
+
+// testDispatcher is a Dispatcher on db that may call local test servers.
+func testDispatcher(t *testing.T) (*Dispatcher, context.Context) {
+	d := New(testDB(t))
+	d.client = &http.Client{Timeout: 5 * time.Second}
+	return d, context.Background()
+}
+
+func addSubscription(t *testing.T, d *Dispatcher, r *receiver, failures int) Subscription {
+	t.Helper()
+	s := Subscription{ID: primitive.NewObjectID(), URL: r.URL, Secret: r.secret, Enabled: true, Failures: failures, CreatedBy: "u1", CreatedAt: time.Now()}
+	if _, err := d.subs.InsertOne(context.Background(), s); err != nil {
+		t.Fatal(err)
+	}
+	return s
+}
+
+func delivery(t *testing.T, d *Dispatcher, filter bson.M) Delivery {
+	t.Helper()
+	var dl Delivery
+	if err := d.deliveries.FindOne(context.Background(), filter).Decode(&dl); err != nil {
+		t.Fatal(err)
+	}
+	return dl
+}
+
+func subscription(t *testing.T, d *Dispatcher, id primitive.ObjectID) Subscription {
+	t.Helper()
+	var s Subscription
+	if err := d.subs.FindOne(context.Background(), bson.M{"_id": id}).Decode(&s); err != nil {
+		t.Fatal(err)
+	}
+	return s
+}
+
+func TestSendNextRetries(t *testing.T) {
+	d, ctx := testDispatcher(t)
+	r := newReceiver(t, "0123456789abcdef-retry", http.StatusInternalServerError)
+	s := addSubscription(t, d, r, 0)
+	other := addSubscription(t, d, r, 0)
+	other.Events = []string{"wiki.*"}
+	if _, err := d.subs.ReplaceOne(ctx, bson.M{"_id": other.ID}, other); err != nil {
+		t.Fatal(err)
+	}
+
+	d.Publish("card.created", "b1", "u1", map[string]string{"_id": "c1"})
+	if n, _ := d.deliveries.CountDocuments(ctx, bson.M{}); n != 1 {
+		t.Fatalf("queued %d deliveries, want 1 (the wiki subscription takes none)", n)
+	}
+
+	now := time.Now()
+	if !d.sendNext(now) {
+		t.Fatal("nothing was due")
+	}
+	dl := delivery(t, d, bson.M{"webhookId": s.ID})
+	if dl.Status != StatusPending || dl.Tries != 1 || len(dl.Attempts) != 1 || dl.Attempts[0].StatusCode != 500 {
+		t.Fatalf("after a 500: %+v", dl)
+	}
+	if wait := dl.NextAttempt.Sub(now); wait < firstRetry-time.Second || wait > firstRetry+5*time.Second {
+		t.Errorf("retry in %v, want about %v", wait, firstRetry)
+	}
+	if got := subscription(t, d, s.ID).Failures; got != 1 {
+		t.Errorf("failures = %d, want 1", got)
+	}
+	if d.sendNext(now) {
+		t.Fatal("the retry was sent before it was due")
+	}
+
+	if !d.sendNext(dl.NextAttempt) {
+		t.Fatal("the retry was not due")
+	}
+	dl = delivery(t, d, bson.M{"webhookId": s.ID})
+	if dl.Status != StatusSucceeded || dl.Tries != 2 || len(dl.Attempts) != 2 || dl.DeliveredAt == nil {
+		t.Fatalf("after a 200: %+v", dl)
+	}
+	if got := subscription(t, d, s.ID).Failures; got != 0 {
+		t.Errorf("failures = %d after a success, want 0", got)
+	}
+	if n, bad := r.requests(); n != 2 || bad != 0 {
+		t.Errorf("receiver got %d requests, %d badly signed", n, bad)
+	}
+
+	var env envelope
+	if err := json.Unmarshal([]byte(dl.Body), &env); err != nil || env.Event != "card.created" || env.ID != dl.ID.Hex() || string(env.Data) != `{"_id":"c1"}` {
+		t.Errorf("body = %s (%v)", dl.Body, err)
+	}
+}
+
+func TestSendNextGivesUp(t *testing.T) {
+	d, ctx := testDispatcher(t)
+	r := newReceiver(t, "0123456789abcdef-fail", http.StatusBadGateway)
+	s := addSubscription(t, d, r, 0)
+	d.Publish("card.moved", "b1", "u1", map[string]string{"_id": "c1"})
+	if _, err := d.deliveries.UpdateMany(ctx, bson.M{}, bson.M{"$set": bson.M{"tries": maxAttempts - 1}}); err != nil {
+		t.Fatal(err)
+	}
+	d.sendNext(time.Now())
+	dl := delivery(t, d, bson.M{"webhookId": s.ID})
+	if dl.Status != StatusFailed || dl.Tries != maxAttempts || dl.Attempts[0].Error != "502 Bad Gateway" {
+		t.Errorf("after the last attempt: %+v", dl)
+	}
+	if d.sendNext(time.Now().Add(maxRetryWait)) {
+		t.Error("a failed delivery was sent again")
+	}
+}
+
+func TestAutoDisable(t *testing.T) {
+	d, ctx := testDispatcher(t)
+	r := newReceiver(t, "0123456789abcdef-off", http.StatusInternalServerError)
+	s := addSubscription(t, d, r, disableAfter-2)
+	d.Publish("card.created", "b1", "u1", map[string]string{"_id": "c1"})
+	d.Publish("card.created", "b1", "u1", map[string]string{"_id": "c2"})
+	d.Publish("card.created", "b1", "u1", map[string]string{"_id": "c3"})
+
+	now := time.Now()
+	d.sendNext(now)
+	if got := subscription(t, d, s.ID); !got.Enabled || got.Failures != disableAfter-1 {
+		t.Fatalf("after %d failures: %+v", disableAfter-1, got)
+	}
+	d.sendNext(now)
+	got := subscription(t, d, s.ID)
+	if got.Enabled || got.Failures != disableAfter || got.DisabledReason == "" {
+		t.Fatalf("after %d failures: %+v", disableAfter, got)
+	}
+
+	// what is still queued fails without being sent
+	d.sendNext(now)
+	if n, _ := r.requests(); n != 2 {
+		t.Errorf("receiver got %d requests, want 2", n)
+	}
+	if n, _ := d.deliveries.CountDocuments(ctx, bson.M{"status": StatusFailed}); n != 1 {
+		t.Errorf("%d deliveries failed on claim, want 1", n)
+	}
+	d.Publish("card.created", "b1", "u1", map[string]string{"_id": "c4"})
+	if n, _ := d.deliveries.CountDocuments(ctx, bson.M{}); n != 3 {
+		t.Errorf("%d deliveries after publishing to a disabled webhook, want 3", n)
+	}
+}
+
+func TestRedeliver(t *testing.T) {
+	d, ctx := testDispatcher(t)
+	r := newReceiver(t, "0123456789abcdef-again", http.StatusInternalServerError)
+	s := addSubscription(t, d, r, 0)
+	d.Publish("card.created", "b1", "u1", map[string]string{"_id": "c1"})
+	if _, err := d.deliveries.UpdateMany(ctx, bson.M{}, bson.M{"$set": bson.M{"tries": maxAttempts - 1}}); err != nil {
+		t.Fatal(err)
+	}
+	d.sendNext(time.Now())
+	dl := delivery(t, d, bson.M{"webhookId": s.ID})
+	if dl.Status != StatusFailed {
+		t.Fatalf("delivery = %+v, want failed", dl)
+	}
+
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	d.RegisterRoutes(router, func(c *gin.Context) { c.Set("userID", c.GetHeader("X-User")) })
+	redeliver := func(user string) int {
+		w := httptest.NewRecorder()
+		req := httptest.NewRequest(http.MethodPost, "/api/webhooks/"+s.ID.Hex()+"/deliveries/"+dl.ID.Hex()+"/redeliver", nil)
+		req.Header.Set("X-User", user)
+		router.ServeHTTP(w, req)
+		return w.Code
+	}
+	if code := redeliver("u2"); code != http.StatusNotFound {
+		t.Errorf("redeliver by another user = %d, want 404", code)
+	}
+	if code := redeliver("u1"); code != http.StatusOK {
+		t.Fatalf("redeliver = %d", code)
+	}
+	dl = delivery(t, d, bson.M{"_id": dl.ID})
+	if dl.Status != StatusPending || dl.Tries != 0 {
+		t.Fatalf("after redeliver: %+v", dl)
+	}
+
+	d.sendNext(time.Now())
+	dl = delivery(t, d, bson.M{"_id": dl.ID})
+	if dl.Status != StatusSucceeded || len(dl.Attempts) != 2 {
+		t.Errorf("redelivered: %+v", dl)
+	}
+	if n, bad := r.requests(); n != 2 || bad != 0 {
+		t.Errorf("receiver got %d requests, %d badly signed", n, bad)
+	}
+}
+
+func TestDeliver(t *testing.T) {
+	d, ctx := testDispatcher(t)
+	r := newReceiver(t, "0123456789abcdef-rule")
+	s := addSubscription(t, d, r, 0)
+	if _, err := d.subs.UpdateOne(ctx, bson.M{"_id": s.ID}, bson.M{"$set": bson.M{"events": []string{"rule.webhook"}, "boardId": "b1"}}); err != nil {
+		t.Fatal(err)
+	}
+	if owner, err := d.Owner(s.ID.Hex()); err != nil || owner != "u1" {
+		t.Errorf("Owner = %q, %v", owner, err)
+	}
+
+	// Publish never sends rule events; Deliver sends them whatever the filter
+	d.Publish("card.created", "b1", "u1", map[string]string{"_id": "c1"})
+	if err := d.Deliver(s.ID.Hex(), "rule.webhook", "b1", "u1", map[string]string{"_id": "c1"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := d.Deliver(s.ID.Hex(), "rule.webhook", "b2", "u1", nil); err == nil {
+		t.Error("delivered for another board")
+	}
+	if err := d.Deliver(primitive.NewObjectID().Hex(), "rule.webhook", "b1", "u1", nil); err == nil {
+		t.Error("delivered to a missing webhook")
+	}
+	d.sendNext(time.Now())
+	if dl := delivery(t, d, bson.M{"webhookId": s.ID}); dl.Status != StatusSucceeded || dl.Event != "rule.webhook" {
+		t.Errorf("delivery = %+v", dl)
+	}
+	if n, bad := r.requests(); n != 1 || bad != 0 {
+		t.Errorf("receiver got %d requests, %d badly signed", n, bad)
+	}
+
+	if _, err := d.subs.UpdateOne(ctx, bson.M{"_id": s.ID}, bson.M{"$set": bson.M{"enabled": false}}); err != nil {
+		t.Fatal(err)
+	}
+	if err := d.Deliver(s.ID.Hex(), "rule.webhook", "b1", "u1", nil); err == nil {
+		t.Error("delivered to a disabled webhook")
+	}
+}
@@ -2,9 +2,14 @@ package modules
 
 import (
 	"context"
+	"errors"
 	"net/http"
+	"strconv"
+	"strings"
 	"time"
 
+	"bell-backend/webhooks"
+
 	"github.com/gin-gonic/gin"
 	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/bson/primitive"
@@ -18,13 +23,22 @@ type WikiPage struct {
 	Title     string              `bson:"title" json:"title"`
 	Content   string              `bson:"content" json:"content"`
 	Emoji     string              `bson:"emoji" json:"emoji"`
+	Version   int64               `bson:"version,omitempty" json:"version"` // растёт при каждом изменении
 	CreatedAt time.Time           `bson:"createdAt" json:"createdAt"`
 	UpdatedAt time.Time           `bson:"updatedAt" json:"updatedAt"`
 }
 
+// События вики для исходящих вебхуков
+const (
+	EventPageCreated = "wiki.page.created"
+	EventPageUpdated = "wiki.page.updated"
+	EventPageDeleted = "wiki.page.deleted"
+)
+
 // Структура модуля
 type WikiModule struct {
 	Collection *mongo.Collection
+	Hooks      *webhooks.Dispatcher // исходящие вебхуки, может быть nil
 }
 
 // Инициализация модуля
@@ -88,9 +102,43 @@ func (m *WikiModule) GetPage(c *gin.Context) {
 		return
 	}
 
+	c.Header("ETag", etag(page.Version))
+	if c.GetHeader("If-None-Match") == etag(page.Version) {
+		c.Status(http.StatusNotModified)
+		return
+	}
 	c.JSON(http.StatusOK, page)
 }
 
+// ETag страницы — её версия в кавычках
+func etag(version int64) string {
+	return `"` + strconv.FormatInt(version, 10) + `"`
+}
+
+// Версии, на которых основано изменение: из If-Match или, если заголовка
+// нет, из поля version в теле. nil — изменение без условия (как и If-Match: *).
+func expectedVersions(c *gin.Context, bodyVersion *int64) (versions []int64, fromHeader bool, err error) {
+	header := strings.TrimSpace(c.GetHeader("If-Match"))
+	if header == "" {
+		if bodyVersion == nil {
+			return nil, false, nil
+		}
+		return []int64{*bodyVersion}, false, nil
+	}
+	if header == "*" {
+		return nil, true, nil
+	}
+	for _, tag := range strings.Split(header, ",") {
+		tag = strings.Trim(strings.TrimPrefix(strings.TrimSpace(tag), "W/"), `"`)
+		v, err := strconv.ParseInt(tag, 10, 64)
+		if err != nil {
+			return nil, true, errors.New("If-Match должен содержать ETag страницы")
+		}
+		versions = append(versions, v)
+	}
+	return versions, true, nil
+}
+
 // Создание новой страницы
 func (m *WikiModule) CreatePage(c *gin.Context) {
 	var input WikiPage
@@ -100,6 +148,7 @@ func (m *WikiModule) CreatePage(c *gin.Context) {
 	}
 
 	input.ID = primitive.NewObjectID()
+	input.Version = 0
 	input.CreatedAt = time.Now()
 	input.UpdatedAt = time.Now()
 
@@ -108,6 +157,7 @@ func (m *WikiModule) CreatePage(c *gin.Context) {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		return
 	}
+	m.Hooks.Publish(EventPageCreated, "", "", input)
 
 	c.JSON(http.StatusCreated, input)
 }
@@ -125,11 +175,17 @@ func (m *WikiModule) UpdatePage(c *gin.Context) {
 		Title   string `json:"title"`
 		Content string `json:"content"`
 		Emoji   string `json:"emoji"`
+		Version *int64 `json:"version"`
 	}
 	if err := c.BindJSON(&body); err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 		return
 	}
+	versions, fromHeader, err := expectedVersions(c, body.Version)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
 
 	update := bson.M{
 		"$set": bson.M{
@@ -138,19 +194,47 @@ func (m *WikiModule) UpdatePage(c *gin.Context) {
 			"emoji":     body.Emoji,
 			"updatedAt": time.Now(),
 		},
+		"$inc": bson.M{"version": 1},
+	}
+
+	// Пишем, только если страницу не изменили с указанной версии
+	filter := bson.M{"_id": objID}
+	if versions != nil {
+		in := bson.A{}
+		for _, v := range versions {
+			in = append(in, v)
+			if v == 0 {
+				in = append(in, nil) // страницы без версии
+			}
+		}
+		filter["version"] = bson.M{"$in": in}
 	}
 
-	res, err := m.Collection.UpdateOne(context.Background(), bson.M{"_id": objID}, update)
+	res, err := m.Collection.UpdateOne(context.Background(), filter, update)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		return
 	}
-	if res.MatchedCount == 0 {
+
+	var page WikiPage
+	if err := m.Collection.FindOne(context.Background(), bson.M{"_id": objID}).Decode(&page); err != nil {
 		c.JSON(http.StatusNotFound, gin.H{"error": "Страница не найдена"})
 		return
 	}
+	c.Header("ETag", etag(page.Version))
 
-	c.JSON(http.StatusOK, gin.H{"message": "Страница обновлена"})
+	// Кто-то успел сохранить раньше: отдаём текущую страницу для слияния
+	if res.MatchedCount == 0 {
+		status := http.StatusConflict
+		if fromHeader {
+			status = http.StatusPreconditionFailed
+		}
+		c.JSON(status, gin.H{"error": "Страница изменена с момента загрузки", "version": page.Version, "current": page})
+		return
+	}
+	m.Hooks.Publish(EventPageUpdated, "", "", page)
+
+	c.JSON(http.StatusOK, gin.H{"message": "Страница обновлена", "page": page})
 }
 
 // Удаление страницы
@@ -163,7 +247,7 @@ func (m *WikiModule) DeletePage(c *gin.Context) {
 	}
 
 	// Удаляем страницу
-	_, err = m.Collection.DeleteOne(context.Background(), bson.M{"_id": objID})
+	res, err := m.Collection.DeleteOne(context.Background(), bson.M{"_id": objID})
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		return
@@ -172,5 +256,9 @@ func (m *WikiModule) DeletePage(c *gin.Context) {
 	// Удаляем дочерние
 	_, _ = m.Collection.DeleteMany(context.Background(), bson.M{"parentId": objID})
 
+	if res.DeletedCount > 0 {
+		m.Hooks.Publish(EventPageDeleted, "", "", gin.H{"_id": objID})
+	}
+
 	c.JSON(http.StatusOK, gin.H{"message": "Страница и дочерние удалены"})
 }